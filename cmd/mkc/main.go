@@ -0,0 +1,136 @@
+// Command mkc compiles Monkey programs to bytecode files and runs them
+// later without compiling them again:
+//
+//	mkc build [-o prog.mkc] [-O] [-debug=false] prog.mk
+//	mkc run prog.mkc
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/repl"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/vm"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch flag.Arg(0) {
+	case "build":
+		err = build(flag.Args()[1:])
+	case "run":
+		err = run(flag.Args()[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mkc: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  mkc build [-o prog.mkc] [-O] [-debug=false] prog.mk
+  mkc run prog.mkc
+`)
+}
+
+func build(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	out := flags.String("o", "", "Bytecode file to write (default: the source with a .mkc extension)")
+	optimize := flags.Bool("O", false, "Run every optimization pass")
+	debug := flags.Bool("debug", true, "Keep source positions and local names for error messages")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("build takes one source file")
+	}
+	path := flags.Arg(0)
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	replInstance := repl.New()
+	if *optimize {
+		replInstance.Optimize = compiler.AllPasses()
+	}
+
+	bytecode, err := replInstance.CompileToBytecode(string(source))
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	data, err := compiler.Marshal(bytecode, replInstance.Stdlib, *debug)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	}
+
+	return os.WriteFile(*out, data, 0o644)
+}
+
+// run verifies the bytecode first, since the file may not come from mkc.
+// The value of the program is printed unless it is null.
+func run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("run takes one bytecode file")
+	}
+	path := flags.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	replInstance := repl.New()
+
+	bytecode, err := compiler.Unmarshal(data, replInstance.Stdlib)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	if err := vm.Verify(bytecode, replInstance.Stdlib); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	result, err := replInstance.RunBytecode(context.Background(), bytecode)
+	if err != nil {
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			return errors.New(runtimeErr.Trace())
+		}
+		return err
+	}
+
+	if result != nil && result != object.NULL {
+		fmt.Println(result.Inspect())
+	}
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/repl"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/vm"
+)
+
+const (
+	maxDebugSessions = 100
+	// Sessions nobody touched for this long are dropped.
+	debugSessionTTL = 15 * time.Minute
+)
+
+// debugSessions holds the debuggers the browser is stepping through, by id.
+type debugSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*debugSession
+}
+
+type debugSession struct {
+	// Held while the debugger runs or is read.
+	mu       sync.Mutex
+	debugger *vm.Debugger
+	output   *outputBuffer
+	lastUsed time.Time
+}
+
+func newDebugSessions() *debugSessions {
+	return &debugSessions{sessions: map[string]*debugSession{}}
+}
+
+func (s *debugSessions) add(session *debugSession) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	if len(s.sessions) >= maxDebugSessions {
+		return "", errors.New("too many debug sessions, try again later")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	session.lastUsed = time.Now()
+	s.sessions[id] = session
+
+	return id, nil
+}
+
+func (s *debugSessions) get(id string) (*debugSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	session, ok := s.sessions[id]
+	if ok {
+		session.lastUsed = time.Now()
+	}
+
+	return session, ok
+}
+
+func (s *debugSessions) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.sessions[id]
+	delete(s.sessions, id)
+
+	return ok
+}
+
+func (s *debugSessions) prune() {
+	for id, session := range s.sessions {
+		if time.Since(session.lastUsed) > debugSessionTTL {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// view is the state of the session sent after every request. The caller
+// must hold session.mu.
+func (session *debugSession) view(id string) envelope {
+	d := session.debugger
+
+	frames := []envelope{}
+	for _, f := range d.Frames() {
+		frames = append(frames, envelope{
+			"function":    f.Function,
+			"next":        f.Next,
+			"ip":          f.IP,
+			"basePointer": f.BasePointer,
+			"free":        jsonValues(f.Free),
+			"position":    f.Position,
+		})
+	}
+
+	data := envelope{
+		"id":        id,
+		"done":      d.Done(),
+		"location":  d.Location(),
+		"frames":    frames,
+		"stack":     jsonValues(d.Stack()),
+		"globals":   jsonValues(d.Globals()),
+		"constants": jsonValues(d.Constants()),
+		"breakpoints": envelope{
+			"offsets": d.Breakpoints(),
+			"lines":   d.LineBreakpoints(),
+		},
+		"output": session.output.Lines(),
+	}
+
+	if result := d.Result(); result != nil {
+		data["result"] = object.JSONValue{Object: result}
+	}
+
+	var runtimeErr *vm.RuntimeError
+	if errors.As(d.Err(), &runtimeErr) {
+		data["result"] = "vm error: " + runtimeErr.Trace()
+		data["error"] = runtimeErr
+	}
+
+	return data
+}
+
+func jsonValues(objs []object.Object) []object.JSONValue {
+	values := make([]object.JSONValue, len(objs))
+	for i, obj := range objs {
+		values[i] = object.JSONValue{Object: obj}
+	}
+
+	return values
+}
+
+func (app *application) createDebugSession(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Input    string          `json:"input"`
+		Locale   string          `json:"locale"`
+		Builtins []string        `json:"builtins"`
+		Stdin    string          `json:"stdin"`
+		Optimize compiler.Passes `json:"optimize"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := newValidator()
+
+	v.Check(input.Input != "", "input", "must be provided")
+	validateLocale(v, input.Locale)
+	validateBuiltins(v, input.Builtins)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	replInstance := repl.New()
+	setLocale(replInstance, input.Locale)
+	setBuiltins(replInstance, input.Builtins)
+	replInstance.Budget = app.budget
+	replInstance.Optimize = input.Optimize
+
+	io, output := captureIO(input.Stdin)
+	replInstance.IO = io
+
+	debugger, err := replInstance.DebugLine(input.Input)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"input": err.Error()})
+		return
+	}
+
+	session := &debugSession{debugger: debugger, output: output}
+
+	id, err := app.debugSessions.add(session)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/debug/sessions/%s", id))
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	err = app.writeJSON(w, http.StatusCreated, session.view(id), headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showDebugSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	session, ok := app.debugSessions.get(id)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	err := app.writeJSON(w, http.StatusOK, session.view(id), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteDebugSession(w http.ResponseWriter, r *http.Request) {
+	if !app.debugSessions.remove(r.PathValue("id")) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resumeDebugSession runs the program by one of "step", "step-over",
+// "step-out" or "continue". A program that fails still answers 200, with
+// the error in the session. Each resume gets the time of a run, so the
+// session is never held longer than that.
+func (app *application) resumeDebugSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	session, ok := app.debugSessions.get(id)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	d := session.debugger
+	actions := map[string]func(context.Context) error{
+		"step":      d.Step,
+		"step-over": d.StepOver,
+		"step-out":  d.StepOut,
+		"continue":  d.Continue,
+	}
+
+	action, ok := actions[r.PathValue("action")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := app.runContext(r)
+	defer cancel()
+
+	action(ctx)
+
+	err := app.writeJSON(w, http.StatusOK, session.view(id), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setDebugBreakpoints replaces every breakpoint of a session.
+func (app *application) setDebugBreakpoints(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	session, ok := app.debugSessions.get(id)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Offsets []vm.Location `json:"offsets"`
+		Lines   []int         `json:"lines"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := newValidator()
+
+	for _, line := range input.Lines {
+		v.Check(line > 0, "lines", "must be positive")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	d := session.debugger
+	previous := d.Breakpoints()
+
+	for _, loc := range previous {
+		d.ClearBreakpoint(loc)
+	}
+	for _, loc := range input.Offsets {
+		if err := d.SetBreakpoint(loc); err != nil {
+			v.AddError("offsets", err.Error())
+		}
+	}
+
+	if !v.Valid() {
+		for _, loc := range d.Breakpoints() {
+			d.ClearBreakpoint(loc)
+		}
+		for _, loc := range previous {
+			d.SetBreakpoint(loc)
+		}
+
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	for _, line := range d.LineBreakpoints() {
+		d.ClearLineBreakpoint(line)
+	}
+	for _, line := range input.Lines {
+		d.SetLineBreakpoint(line)
+	}
+
+	err := app.writeJSON(w, http.StatusOK, session.view(id), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+)
+
+func newTestApplication(runTimeout time.Duration) *application {
+	return &application{
+		errorLog:      log.New(io.Discard, "", 0),
+		infoLog:       log.New(io.Discard, "", 0),
+		budget:        object.Budget{Steps: 5_000_000, Depth: 1_000, Allocs: 10_000_000},
+		runTimeout:    runTimeout,
+		debugSessions: newDebugSessions(),
+	}
+}
+
+// request sends a request to the application and decodes the JSON it
+// answers with, if any.
+func request(t *testing.T, handler http.Handler, method, path, body string) (int, map[string]any) {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	data := map[string]any{}
+	if w.Body.Len() > 0 {
+		if err := json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&data); err != nil {
+			t.Fatalf("%s %s: bad JSON: %s", method, path, err)
+		}
+	}
+
+	return w.Code, data
+}
+
+func createTestSession(t *testing.T, handler http.Handler, input string) string {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"input": input})
+	status, data := request(t, handler, http.MethodPost, "/api/debug/sessions", string(body))
+	if status != http.StatusCreated {
+		t.Fatalf("wrong status creating a session. want=%d, got=%d (%v)",
+			http.StatusCreated, status, data)
+	}
+
+	id, _ := data["id"].(string)
+	if id == "" {
+		t.Fatalf("session has no id: %v", data)
+	}
+
+	return id
+}
+
+func TestDebugSession(t *testing.T) {
+	handler := newTestApplication(3 * time.Second).routes()
+	id := createTestSession(t, handler, "let add = fn(a, b) { a + b };\nadd(1, 2)")
+	path := "/api/debug/sessions/" + id
+
+	status, data := request(t, handler, http.MethodGet, path, "")
+	if status != http.StatusOK || data["done"] != false {
+		t.Fatalf("wrong session. status=%d, data=%v", status, data)
+	}
+
+	status, data = request(t, handler, http.MethodPost, path+"/step", "")
+	if status != http.StatusOK {
+		t.Fatalf("wrong status stepping. want=%d, got=%d", http.StatusOK, status)
+	}
+	if location := data["location"].(map[string]any); location["offset"] == 0.0 {
+		t.Errorf("step didn't move: %v", location)
+	}
+
+	status, _ = request(t, handler, http.MethodPut, path+"/breakpoints", `{"lines": [0]}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status for a bad breakpoint. want=%d, got=%d",
+			http.StatusUnprocessableEntity, status)
+	}
+
+	status, data = request(t, handler, http.MethodPut, path+"/breakpoints", `{"lines": [2]}`)
+	if status != http.StatusOK {
+		t.Fatalf("wrong status setting breakpoints. want=%d, got=%d (%v)", http.StatusOK, status, data)
+	}
+
+	status, data = request(t, handler, http.MethodPost, path+"/continue", "")
+	if status != http.StatusOK || data["done"] != false {
+		t.Fatalf("didn't stop at the line breakpoint. status=%d, data=%v", status, data)
+	}
+
+	request(t, handler, http.MethodPut, path+"/breakpoints", `{}`)
+	status, data = request(t, handler, http.MethodPost, path+"/continue", "")
+	if status != http.StatusOK || data["done"] != true || data["result"] != 3.0 {
+		t.Fatalf("wrong end of the program. status=%d, data=%v", status, data)
+	}
+
+	if status, _ := request(t, handler, http.MethodPost, path+"/jump", ""); status != http.StatusNotFound {
+		t.Errorf("wrong status for an unknown action. want=%d, got=%d", http.StatusNotFound, status)
+	}
+
+	if status, _ := request(t, handler, http.MethodDelete, path, ""); status != http.StatusNoContent {
+		t.Errorf("wrong status deleting. want=%d, got=%d", http.StatusNoContent, status)
+	}
+	if status, _ := request(t, handler, http.MethodGet, path, ""); status != http.StatusNotFound {
+		t.Errorf("deleted session still found, status=%d", status)
+	}
+}
+
+func TestDebugSessionErrors(t *testing.T) {
+	handler := newTestApplication(3 * time.Second).routes()
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"input": ""}`, http.StatusUnprocessableEntity},
+		{`{"input": "let = 1"}`, http.StatusUnprocessableEntity},
+		{`{"input": "1", "builtins": ["nope"]}`, http.StatusUnprocessableEntity},
+		{`{"input": 1}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		status, data := request(t, handler, http.MethodPost, "/api/debug/sessions", tt.body)
+		if status != tt.status {
+			t.Errorf("%s: wrong status. want=%d, got=%d (%v)", tt.body, tt.status, status, data)
+		}
+	}
+
+	if status, _ := request(t, handler, http.MethodPost, "/api/debug/sessions/nope/step", ""); status != http.StatusNotFound {
+		t.Errorf("wrong status for an unknown session. want=%d, got=%d", http.StatusNotFound, status)
+	}
+}
+
+// A resume gets no more time than a run, however much budget is left.
+func TestDebugSessionTimeout(t *testing.T) {
+	handler := newTestApplication(time.Nanosecond).routes()
+	id := createTestSession(t, handler, "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(500)")
+
+	status, data := request(t, handler, http.MethodPost, "/api/debug/sessions/"+id+"/continue", "")
+	if status != http.StatusOK || data["done"] != true {
+		t.Fatalf("resume not stopped. status=%d, data=%v", status, data)
+	}
+
+	result, _ := data["result"].(string)
+	if !strings.Contains(result, "execution cancelled") {
+		t.Errorf("wrong result. want a cancelled run, got=%q", result)
+	}
+}
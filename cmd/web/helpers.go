@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"runtime/debug"
 	"strings"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
 )
 
 type envelope map[string]any
@@ -178,6 +180,11 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested resource could not be found"
+	app.errorResponse(w, r, http.StatusNotFound, message)
+}
+
 func (app *application) failedValidationResponse(w http.ResponseWriter,
 	r *http.Request, errors map[string]string,
 ) {
@@ -192,3 +199,51 @@ func (app *application) serverErrorResponse(w http.ResponseWriter,
 	message := "the server encountered a problem and coult not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
+
+// maxOutputBytes caps how much a single program run may print.
+const maxOutputBytes = 1_048_576
+
+// outputBuffer collects what a program prints, dropping anything past
+// maxOutputBytes.
+type outputBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := maxOutputBytes - b.buf.Len(); n > room {
+		b.truncated = true
+		p = p[:room]
+	}
+
+	b.buf.Write(p)
+
+	return n, nil
+}
+
+// Lines splits the output into lines, without their line breaks.
+func (b *outputBuffer) Lines() []string {
+	output := strings.TrimSuffix(b.buf.String(), "\n")
+	if output == "" {
+		return []string{}
+	}
+
+	lines := strings.Split(output, "\n")
+	if b.truncated {
+		lines = append(lines, "... output truncated")
+	}
+
+	return lines
+}
+
+// captureIO feeds stdin to a program and collects both of its output streams.
+func captureIO(stdin string) (object.IO, *outputBuffer) {
+	output := &outputBuffer{}
+
+	return object.IO{
+		Stdout: output,
+		Stderr: output,
+		Stdin:  strings.NewReader(stdin),
+	}, output
+}
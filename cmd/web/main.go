@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
 )
 
 type application struct {
@@ -14,10 +16,22 @@ type application struct {
 	infoLog  *log.Logger
 
 	templateCache map[string]*template.Template
+
+	// Every program run through the API gets its own budget and deadline.
+	budget     object.Budget
+	runTimeout time.Duration
+
+	debugSessions *debugSessions
 }
 
 func main() {
 	addr := flag.String("addr", ":5173", "HTTP network address")
+
+	var budget object.Budget
+	flag.IntVar(&budget.Steps, "max-steps", 5_000_000, "Maximum evaluation steps per request (0 = no limit)")
+	flag.IntVar(&budget.Depth, "max-depth", 1_000, "Maximum call depth per request (0 = no limit)")
+	flag.IntVar(&budget.Allocs, "max-allocs", 10_000_000, "Maximum allocations per request (0 = no limit)")
+	runTimeout := flag.Duration("run-timeout", 3*time.Second, "Maximum running time per request")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -32,6 +46,9 @@ func main() {
 		errorLog:      errorLog,
 		infoLog:       infoLog,
 		templateCache: templateCache,
+		budget:        budget,
+		runTimeout:    *runTimeout,
+		debugSessions: newDebugSessions(),
 	}
 
 	srv := &http.Server{
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/parser"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/repl"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/vm"
 	"github.com/ZeroBl21/go-monkey-visualizer/ui"
 )
 
@@ -29,11 +37,55 @@ func (app *application) routes() http.Handler {
 
 	mux.HandleFunc("POST /api/compiler", app.compilerMonkey)
 
+	mux.HandleFunc("POST /api/assemble", app.assembleMonkey)
+
+	mux.HandleFunc("GET /api/builtins", app.listBuiltins)
+
+	mux.HandleFunc("POST /api/debug/sessions", app.createDebugSession)
+	mux.HandleFunc("GET /api/debug/sessions/{id}", app.showDebugSession)
+	mux.HandleFunc("DELETE /api/debug/sessions/{id}", app.deleteDebugSession)
+	mux.HandleFunc("POST /api/debug/sessions/{id}/{action}", app.resumeDebugSession)
+	mux.HandleFunc("PUT /api/debug/sessions/{id}/breakpoints", app.setDebugBreakpoints)
+
 	return mux
 }
 
 // Handlers
 
+// validateLocale checks a request's locale. It picks the keywords and the
+// language of error messages.
+func validateLocale(v *Validator, locale string) {
+	v.Check(locale == "" || In(locale, token.LocaleNames()...), "locale",
+		fmt.Sprintf("must be one of %v", token.LocaleNames()))
+}
+
+func setLocale(replInstance *repl.REPL, name string) {
+	if locale, ok := token.LookupLocale(name); ok {
+		replInstance.Locale = locale
+	}
+}
+
+// validateBuiltins checks a request's choice of builtins. Leaving it out
+// means the whole standard library.
+func validateBuiltins(v *Validator, names []string) {
+	known := object.DefaultStdlib().Names()
+	for _, name := range names {
+		v.Check(In(name, known...), "builtins", fmt.Sprintf("%q is not a builtin", name))
+	}
+}
+
+func setBuiltins(replInstance *repl.REPL, names []string) {
+	if names != nil {
+		replInstance.Stdlib = object.DefaultStdlib().Only(names...)
+	}
+}
+
+// runContext bounds the time a single evaluation or VM run started by r may
+// take. It is also cancelled when the client goes away.
+func (app *application) runContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), app.runTimeout)
+}
+
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 
@@ -48,7 +100,8 @@ func (app *application) tutorial(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) lexerMonkey(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Input string `json:"input"`
+		Input  string `json:"input"`
+		Locale string `json:"locale"`
 	}
 
 	if err := app.readJSON(w, r, &input); err != nil {
@@ -58,12 +111,16 @@ func (app *application) lexerMonkey(w http.ResponseWriter, r *http.Request) {
 
 	v := newValidator()
 
-	if v.Check(input.Input != "", "input", "must be provided"); !v.Valid() {
+	v.Check(input.Input != "", "input", "must be provided")
+	validateLocale(v, input.Locale)
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	replInstance := repl.New()
+	setLocale(replInstance, input.Locale)
 	result := replInstance.ParseTokens(input.Input)
 
 	err := app.writeJSON(w, http.StatusOK, envelope{"result": result}, nil)
@@ -74,7 +131,9 @@ func (app *application) lexerMonkey(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) parserPratt(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Input string `json:"input"`
+		Input     string               `json:"input"`
+		Locale    string               `json:"locale"`
+		Operators parser.OperatorTable `json:"operators"`
 	}
 
 	if err := app.readJSON(w, r, &input); err != nil {
@@ -84,12 +143,36 @@ func (app *application) parserPratt(w http.ResponseWriter, r *http.Request) {
 
 	v := newValidator()
 
-	if v.Check(input.Input != "", "input", "must be provided"); !v.Valid() {
+	v.Check(input.Input != "", "input", "must be provided")
+	validateLocale(v, input.Locale)
+	defaults := parser.DefaultOperators()
+	for symbol, op := range input.Operators {
+		key := fmt.Sprintf("operators.%s", symbol)
+		// Only the built-in operators have a precedence to change. Giving
+		// one to another token, like the `:` of hashes, breaks parsing.
+		_, ok := defaults[symbol]
+		v.Check(ok, key, "must be a built-in operator")
+		v.Check(op.Precedence > parser.LOWEST && op.Precedence < parser.PREFIX, key,
+			fmt.Sprintf("precedence must be between %d and %d",
+				parser.LOWEST+1, parser.PREFIX-1))
+		v.Check(In(string(op.Associativity), string(parser.LeftAssoc),
+			string(parser.RightAssoc)), key, "associativity must be left or right")
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	replInstance := repl.New()
+	setLocale(replInstance, input.Locale)
+	if len(input.Operators) != 0 {
+		replInstance.Operators = parser.DefaultOperators()
+		for symbol, op := range input.Operators {
+			replInstance.Operators[symbol] = op
+		}
+	}
+
 	result := replInstance.ParseAST(input.Input)
 
 	if len(result.Errors) != 0 {
@@ -109,7 +192,11 @@ func (app *application) parserPratt(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) evaluateMonkey(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Input string `json:"input"`
+		Input    string   `json:"input"`
+		Locale   string   `json:"locale"`
+		Builtins []string `json:"builtins"`
+		Stdin    string   `json:"stdin"`
+		Trace    bool     `json:"trace"`
 	}
 
 	if err := app.readJSON(w, r, &input); err != nil {
@@ -119,13 +206,33 @@ func (app *application) evaluateMonkey(w http.ResponseWriter, r *http.Request) {
 
 	v := newValidator()
 
-	if v.Check(input.Input != "", "input", "must be provided"); !v.Valid() {
+	v.Check(input.Input != "", "input", "must be provided")
+	validateLocale(v, input.Locale)
+	validateBuiltins(v, input.Builtins)
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	replInstance := repl.New()
-	result := replInstance.EvaluateLine(input.Input)
+	setLocale(replInstance, input.Locale)
+	setBuiltins(replInstance, input.Builtins)
+	replInstance.Budget = app.budget
+
+	io, output := captureIO(input.Stdin)
+	replInstance.IO = io
+
+	ctx, cancel := app.runContext(r)
+	defer cancel()
+
+	var result *repl.ParseResult
+	var err error
+	if input.Trace {
+		result, err = replInstance.TraceLine(ctx, input.Input)
+	} else {
+		result, err = replInstance.EvaluateLine(ctx, input.Input)
+	}
 
 	if len(result.Errors) != 0 {
 		err := app.writeJSON(w, http.StatusOK, envelope{"result": result.Errors}, nil)
@@ -136,7 +243,15 @@ func (app *application) evaluateMonkey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := app.writeJSON(w, http.StatusOK, envelope{"result": result.Evaluate}, nil)
+	data := envelope{"result": object.JSONValue{Object: result.Evaluate}, "output": output.Lines()}
+	if err != nil {
+		data["result"] = err.Error()
+	}
+	if input.Trace {
+		data["trace"] = result.Trace
+	}
+
+	err = app.writeJSON(w, http.StatusOK, data, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -144,7 +259,10 @@ func (app *application) evaluateMonkey(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) bytecodeMonkey(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Input string `json:"input"`
+		Input    string          `json:"input"`
+		Locale   string          `json:"locale"`
+		Builtins []string        `json:"builtins"`
+		Optimize compiler.Passes `json:"optimize"`
 	}
 
 	if err := app.readJSON(w, r, &input); err != nil {
@@ -154,13 +272,21 @@ func (app *application) bytecodeMonkey(w http.ResponseWriter, r *http.Request) {
 
 	v := newValidator()
 
-	if v.Check(input.Input != "", "input", "must be provided"); !v.Valid() {
+	v.Check(input.Input != "", "input", "must be provided")
+	validateLocale(v, input.Locale)
+	validateBuiltins(v, input.Builtins)
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	replInstance := repl.New()
-	result, err := replInstance.CompileToBytecode(input.Input)
+	setLocale(replInstance, input.Locale)
+	setBuiltins(replInstance, input.Builtins)
+	replInstance.Optimize = input.Optimize
+
+	stages, err := replInstance.OptimizeLine(input.Input)
 	if err != nil {
 		fmt.Println(err)
 		err := app.writeJSON(w, http.StatusOK, envelope{"result": err.Error()}, nil)
@@ -171,7 +297,18 @@ func (app *application) bytecodeMonkey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"result": result}, nil)
+	// With passes switched on, the code after each of them is sent too.
+	bytecode := stages[len(stages)-1].Bytecode
+	data := envelope{
+		"result":      bytecode,
+		"constants":   bytecode.ConstantPool(),
+		"disassembly": compiler.Disassemble(bytecode),
+	}
+	if input.Optimize != (compiler.Passes{}) {
+		data["stages"] = stages
+	}
+
+	err = app.writeJSON(w, http.StatusOK, data, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -179,7 +316,11 @@ func (app *application) bytecodeMonkey(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) compilerMonkey(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Input string `json:"input"`
+		Input    string          `json:"input"`
+		Locale   string          `json:"locale"`
+		Builtins []string        `json:"builtins"`
+		Stdin    string          `json:"stdin"`
+		Optimize compiler.Passes `json:"optimize"`
 	}
 
 	if err := app.readJSON(w, r, &input); err != nil {
@@ -189,16 +330,60 @@ func (app *application) compilerMonkey(w http.ResponseWriter, r *http.Request) {
 
 	v := newValidator()
 
-	if v.Check(input.Input != "", "input", "must be provided"); !v.Valid() {
+	v.Check(input.Input != "", "input", "must be provided")
+	validateLocale(v, input.Locale)
+	validateBuiltins(v, input.Builtins)
+
+	// ?trace=1 records every instruction the VM runs.
+	trace := false
+	if query := r.URL.Query().Get("trace"); query != "" {
+		var err error
+		trace, err = strconv.ParseBool(query)
+		v.Check(err == nil, "trace", "must be a boolean")
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	replInstance := repl.New()
-	result, err := replInstance.CompileToVM(input.Input)
+	setLocale(replInstance, input.Locale)
+	setBuiltins(replInstance, input.Builtins)
+	replInstance.Budget = app.budget
+	replInstance.Optimize = input.Optimize
+
+	io, output := captureIO(input.Stdin)
+	replInstance.IO = io
+
+	ctx, cancel := app.runContext(r)
+	defer cancel()
+
+	var result object.Object
+	var vmTrace *vm.Trace
+	var err error
+	if trace {
+		result, vmTrace, err = replInstance.TraceVM(ctx, input.Input)
+	} else {
+		result, err = replInstance.CompileToVM(ctx, input.Input)
+	}
+
 	if err != nil {
 		fmt.Println(err)
-		err := app.writeJSON(w, http.StatusOK, envelope{"result": err.Error()}, nil)
+		data := envelope{"result": err.Error(), "output": output.Lines()}
+		if trace {
+			data["trace"] = vmTrace
+		}
+
+		// A runtime error reads as a stack trace, with the frames also
+		// sent as JSON for the UI to point at.
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			data["result"] = "vm error: " + runtimeErr.Trace()
+			data["error"] = runtimeErr
+		}
+
+		err := app.writeJSON(w, http.StatusOK, data, nil)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 		}
@@ -206,7 +391,92 @@ func (app *application) compilerMonkey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"result": result}, nil)
+	data := envelope{"result": object.JSONValue{Object: result}, "output": output.Lines()}
+	if trace {
+		data["trace"] = vmTrace
+	}
+
+	err = app.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// assembleMonkey assembles Monkey assembly, see compiler.Assemble, and runs
+// it on the VM once it is verified.
+func (app *application) assembleMonkey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Input    string   `json:"input"`
+		Builtins []string `json:"builtins"`
+		Stdin    string   `json:"stdin"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := newValidator()
+
+	v.Check(input.Input != "", "input", "must be provided")
+	validateBuiltins(v, input.Builtins)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	replInstance := repl.New()
+	setBuiltins(replInstance, input.Builtins)
+	replInstance.Budget = app.budget
+
+	io, output := captureIO(input.Stdin)
+	replInstance.IO = io
+
+	bytecode, err := compiler.Assemble(input.Input, replInstance.Stdlib)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"input": err.Error()})
+		return
+	}
+
+	// Hand-written bytecode could break what the VM takes for granted.
+	if err := vm.Verify(bytecode, replInstance.Stdlib); err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"input": err.Error()})
+		return
+	}
+
+	ctx, cancel := app.runContext(r)
+	defer cancel()
+
+	data := envelope{
+		"bytecode":    bytecode,
+		"disassembly": compiler.Disassemble(bytecode),
+	}
+
+	result, err := replInstance.RunBytecode(ctx, bytecode)
+	if err != nil {
+		data["result"] = err.Error()
+
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			data["result"] = "vm error: " + runtimeErr.Trace()
+			data["error"] = runtimeErr
+		}
+	} else {
+		data["result"] = object.JSONValue{Object: result}
+	}
+	data["output"] = output.Lines()
+
+	err = app.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBuiltins(w http.ResponseWriter, r *http.Request) {
+	builtins := object.DefaultStdlib().Builtins()
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"builtins": builtins}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParserPrattOperators(t *testing.T) {
+	handler := newTestApplication(3 * time.Second).routes()
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"input": "1 + 2", "operators": {"+": {"precedence": 40, "associativity": "right"}}}`, http.StatusOK},
+		{`{"input": "{\"a\": 1}", "operators": {":": {"precedence": 40, "associativity": "left"}}}`, http.StatusUnprocessableEntity},
+		{`{"input": "1", "operators": {"<+>": {"precedence": 40, "associativity": "left"}}}`, http.StatusUnprocessableEntity},
+		{`{"input": "1 + 2", "operators": {"+": {"precedence": 0, "associativity": "left"}}}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		status, data := request(t, handler, http.MethodPost, "/api/pratt", tt.body)
+		if status != tt.status {
+			t.Errorf("%s: wrong status. want=%d, got=%d (%v)", tt.body, tt.status, status, data)
+		}
+	}
+}
+
+func TestLexerOperators(t *testing.T) {
+	handler := newTestApplication(3 * time.Second).routes()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"infixl 45 <+> = add; a <+> b", "infixl 45 <+> = add ; a <+> b"},
+		{"infixl 5 <+> = add; a <+> b", "infixl 5 <+> = add ; a < + > b"},
+	}
+
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"input": tt.input})
+		status, data := request(t, handler, http.MethodPost, "/api/lexer", string(body))
+		if status != http.StatusOK {
+			t.Fatalf("%s: wrong status. want=%d, got=%d (%v)", tt.input, http.StatusOK, status, data)
+		}
+
+		literals := []string{}
+		for _, tok := range data["result"].([]any) {
+			literals = append(literals, tok.(map[string]any)["literal"].(string))
+		}
+		if got := strings.Join(literals, " "); got != tt.expected {
+			t.Errorf("%s: wrong tokens. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"encoding/json"
+	"math/big"
 	"strings"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
@@ -11,8 +12,19 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	NodeSpan() Span
 }
 
+// Span is the range of source a node was parsed from. End is just past the
+// last char of the node.
+type Span struct {
+	Start token.Position `json:"start"`
+	End   token.Position `json:"end"`
+}
+
+func (s *Span) NodeSpan() Span        { return *s }
+func (s *Span) SetNodeSpan(span Span) { *s = span }
+
 type Statement interface {
 	Node
 	statementNode()
@@ -24,6 +36,7 @@ type Expression interface {
 }
 
 type Program struct {
+	Span
 	Statements []Statement
 }
 
@@ -46,6 +59,7 @@ func (p *Program) String() string {
 }
 
 type LetStatement struct {
+	Span
 	Token token.Token // The token.LET token
 	Name  *Identifier
 	Value Expression
@@ -70,6 +84,7 @@ func (s *LetStatement) String() string {
 }
 
 type ReturnStatement struct {
+	Span
 	Token       token.Token
 	ReturnValue Expression
 }
@@ -91,6 +106,7 @@ func (s *ReturnStatement) String() string {
 }
 
 type ExpressionStatement struct {
+	Span
 	Token      token.Token // the first token of the expression
 	Expression Expression
 }
@@ -105,7 +121,35 @@ func (s *ExpressionStatement) String() string {
 	return ""
 }
 
+// StructStatement declares a struct type: `struct Point { x, y }`.
+type StructStatement struct {
+	Span
+	Token  token.Token // The 'struct' token
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (s *StructStatement) statementNode()       {}
+func (s *StructStatement) TokenLiteral() string { return s.Token.Literal }
+func (s *StructStatement) String() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, f := range s.Fields {
+		fields = append(fields, f.String())
+	}
+
+	out.WriteString(s.TokenLiteral() + " ")
+	out.WriteString(s.Name.String())
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
 type BlockStatement struct {
+	Span
 	Token      token.Token // The { Token
 	Statements []Statement
 }
@@ -125,6 +169,7 @@ func (s *BlockStatement) String() string {
 // Literals
 
 type Identifier struct {
+	Span
 	Token token.Token // The 'Return' token
 	Value string
 }
@@ -134,8 +179,11 @@ func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
 
 type IntegerLiteral struct {
+	Span
 	Token token.Token
 	Value int64
+	// Big holds the value instead of Value when it doesn't fit in an int64.
+	Big *big.Int `json:",omitempty"`
 }
 
 func (l *IntegerLiteral) expressionNode()      {}
@@ -143,6 +191,7 @@ func (l *IntegerLiteral) TokenLiteral() string { return l.Token.Literal }
 func (l *IntegerLiteral) String() string       { return l.Token.Literal }
 
 type StringLiteral struct {
+	Span
 	Token token.Token
 	Value string
 }
@@ -152,6 +201,7 @@ func (l *StringLiteral) TokenLiteral() string { return l.Token.Literal }
 func (l *StringLiteral) String() string       { return l.Token.Literal }
 
 type Boolean struct {
+	Span
 	Token token.Token
 	Value bool
 }
@@ -161,6 +211,7 @@ func (l *Boolean) TokenLiteral() string { return l.Token.Literal }
 func (l *Boolean) String() string       { return l.Token.Literal }
 
 type ArrayLiteral struct {
+	Span
 	Token    token.Token // The '[' token
 	Elements []Expression
 }
@@ -182,19 +233,25 @@ func (l *ArrayLiteral) String() string {
 	return out.String()
 }
 
-type HashPairs map[Expression]Expression
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+// HashPairs keeps the pairs of a hash literal in source order.
+type HashPairs []HashPair
 
 func (hp HashPairs) MarshalJSON() ([]byte, error) {
 	type Pair struct {
 		Key   string
 		Value Expression
 	}
-	var pairs []Pair
+	pairs := []Pair{}
 
-	for key, value := range hp {
+	for _, pair := range hp {
 		pairs = append(pairs, Pair{
-			Key:   key.String(),
-			Value: value,
+			Key:   pair.Key.String(),
+			Value: pair.Value,
 		})
 	}
 
@@ -202,6 +259,7 @@ func (hp HashPairs) MarshalJSON() ([]byte, error) {
 }
 
 type HashLiteral struct {
+	Span
 	Token token.Token // The '{' token
 	Pairs HashPairs
 }
@@ -212,8 +270,8 @@ func (l *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range l.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range l.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -224,9 +282,12 @@ func (l *HashLiteral) String() string {
 }
 
 type FunctionLiteral struct {
+	Span
 	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	// Name is the name the function is bound to by a let statement, if any.
+	Name string `json:",omitempty"`
 }
 
 func (l *FunctionLiteral) expressionNode()      {}
@@ -251,6 +312,7 @@ func (l *FunctionLiteral) String() string {
 // Expressions
 
 type IndexExpression struct {
+	Span
 	Token token.Token // The '[' Token
 	Left  Expression
 	Index Expression
@@ -270,7 +332,84 @@ func (e *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression is `left[start:end]`. Start and End are nil when left out.
+type SliceExpression struct {
+	Span
+	Token token.Token // The '[' Token
+	Left  Expression
+	Start Expression
+	End   Expression
+}
+
+func (e *SliceExpression) expressionNode()      {}
+func (e *SliceExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(e.Left.String())
+	out.WriteString("[")
+	if e.Start != nil {
+		out.WriteString(e.Start.String())
+	}
+	out.WriteString(":")
+	if e.End != nil {
+		out.WriteString(e.End.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+// FieldExpression reads a field of a struct: `left.field`.
+type FieldExpression struct {
+	Span
+	Token token.Token // The '.' token
+	Left  Expression
+	Field *Identifier
+}
+
+func (e *FieldExpression) expressionNode()      {}
+func (e *FieldExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *FieldExpression) String() string {
+	return "(" + e.Left.String() + "." + e.Field.String() + ")"
+}
+
+type FieldValue struct {
+	Name  *Identifier
+	Value Expression
+}
+
+// WithExpression copies a struct with some fields replaced:
+// `left with { x: 1 }`.
+type WithExpression struct {
+	Span
+	Token  token.Token // The 'with' token
+	Left   Expression
+	Fields []FieldValue
+}
+
+func (e *WithExpression) expressionNode()      {}
+func (e *WithExpression) TokenLiteral() string { return e.Token.Literal }
+func (e *WithExpression) String() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, f := range e.Fields {
+		fields = append(fields, f.Name.String()+": "+f.Value.String())
+	}
+
+	out.WriteString("(")
+	out.WriteString(e.Left.String())
+	out.WriteString(" " + e.TokenLiteral() + " {")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("})")
+
+	return out.String()
+}
+
 type CallExpression struct {
+	Span
 	Token     token.Token // The '(' token
 	Function  Expression  //  Identifier or FunctionLiteral
 	Arguments []Expression
@@ -295,6 +434,7 @@ func (e *CallExpression) String() string {
 }
 
 type PrefixExpression struct {
+	Span
 	Token    token.Token
 	Operator string
 	Right    Expression
@@ -314,6 +454,7 @@ func (e *PrefixExpression) String() string {
 }
 
 type InfixExpression struct {
+	Span
 	Token    token.Token // Operator token, e.g +
 	Left     Expression
 	Operator string
@@ -335,9 +476,11 @@ func (e *InfixExpression) String() string {
 }
 
 type IfExpression struct {
+	Span
 	Token       token.Token // The 'if' token
 	Condition   Expression
 	Consequence *BlockStatement
+	Else        token.Token // The 'else' token, or the locale's spelling of it
 	Alternative *BlockStatement
 }
 
@@ -346,13 +489,13 @@ func (e *IfExpression) TokenLiteral() string { return e.Token.Literal }
 func (e *IfExpression) String() string {
 	var out bytes.Buffer
 
-	out.WriteString("if")
+	out.WriteString(e.TokenLiteral())
 	out.WriteString(e.Condition.String())
 	out.WriteString(" ")
 	out.WriteString(e.Consequence.String())
 
 	if e.Alternative != nil {
-		out.WriteString("else ")
+		out.WriteString(e.Else.Literal + " ")
 		out.WriteString(e.Alternative.String())
 	}
 
@@ -9,6 +9,11 @@ import (
 
 type Opcode byte
 
+// Version numbers the opcode set. It must go up whenever an opcode is
+// added, removed or changes its operands, so saved bytecode isn't run on a
+// VM that reads it differently.
+const Version = 1
+
 const (
 	OpConstant Opcode = iota
 	OpAdd
@@ -50,8 +55,14 @@ const (
 	OpArray
 	OpHash
 	OpIndex
+	OpSlice
+
+	OpGetField
+	OpWith
 
 	OpNull
+
+	OpConstantWide
 )
 
 type Definition struct {
@@ -100,8 +111,36 @@ var definitions = map[Opcode]*Definition{
 	OpArray: {"OpArray", []int{2}},
 	OpHash:  {"OpHash", []int{2}},
 	OpIndex: {"OpIndex", []int{}},
+	OpSlice: {"OpSlice", []int{}},
+
+	OpGetField: {"OpGetField", []int{2}},
+	OpWith:     {"OpWith", []int{2}},
 
 	OpNull: {"OpNull", []int{}},
+
+	// OpConstantWide loads constants past the reach of OpConstant.
+	OpConstantWide: {"OpConstantWide", []int{4}},
+}
+
+// LookupName finds an opcode by the name of its definition, like "OpAdd".
+func LookupName(name string) (Opcode, *Definition, bool) {
+	for op, def := range definitions {
+		if def.Name == name {
+			return op, def, true
+		}
+	}
+
+	return 0, nil, false
+}
+
+// Width is the number of bytes an instruction takes, the opcode included.
+func (def *Definition) Width() int {
+	width := 1
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+
+	return width
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -131,6 +170,8 @@ func Make(op Opcode, operants ...int) []byte {
 	for i, o := range operants {
 		width := def.OperandWidths[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -148,6 +189,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 4:
+			operants[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operants[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -160,6 +203,10 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operants, offset
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
@@ -177,9 +224,14 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
 			continue
 		}
+		if i+def.Width() > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: %s is cut short\n", i, def.Name)
+			break
+		}
 
 		operants, read := ReadOperands(def, ins[i+1:])
 
@@ -199,9 +251,13 @@ func (ins Instructions) MarshalJSON() ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		if i+def.Width() > len(ins) {
+			return nil, fmt.Errorf("%s at %d is cut short", def.Name, i)
+		}
 
 		operands, read := ReadOperands(def, ins[i+1:])
 		instruction := map[string]interface{}{
+			"offset":   i,
 			"opCode":   def.Name,
 			"operands": operands,
 		}
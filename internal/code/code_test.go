@@ -1,6 +1,10 @@
 package code
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -12,6 +16,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpConstantWide, []int{65_536}, []byte{byte(OpConstantWide), 0, 1, 0, 0}},
 	}
 
 	for _, tt := range tests {
@@ -58,6 +63,24 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringInvalid(t *testing.T) {
+	ins := Instructions{255, byte(OpAdd), byte(OpConstant), 1}
+
+	expected := `0000 ERROR: opcode 255 undefined
+0001 OpAdd
+0002 ERROR: OpConstant is cut short
+`
+
+	if ins.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot =%q",
+			expected, ins.String())
+	}
+
+	if _, err := ins.MarshalJSON(); err == nil {
+		t.Errorf("expected an error marshaling invalid instructions")
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -67,6 +90,7 @@ func TestReadOperands(t *testing.T) {
 		{OpConstant, []int{65_535}, 2},
 		{OpGetLocal, []int{255}, 1},
 		{OpClosure, []int{65_535, 255}, 3},
+		{OpConstantWide, []int{16_777_216}, 4},
 	}
 
 	for _, tt := range tests {
@@ -93,3 +117,37 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestSourceMapLookup(t *testing.T) {
+	m := SourceMap{
+		{Offset: 0, Start: token.Position{Line: 1, Column: 1}},
+		{Offset: 3, Start: token.Position{Line: 2, Column: 5}},
+		{Offset: 4, Start: token.Position{Line: 3, Column: 1}},
+	}
+
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{-1, "none"},
+		{0, "1:1"},
+		{2, "1:1"},
+		{3, "2:5"},
+		{9, "3:1"},
+	}
+
+	for _, tt := range tests {
+		got := "none"
+		if mapping, ok := m.Lookup(tt.offset); ok {
+			got = mapping.Start.String()
+		}
+
+		if got != tt.expected {
+			t.Errorf("wrong mapping for offset %d. want=%s, got=%s", tt.offset, tt.expected, got)
+		}
+	}
+
+	if n := len(m.Truncate(3)); n != 1 {
+		t.Errorf("Truncate(3) should keep 1 mapping. got=%d", n)
+	}
+}
@@ -0,0 +1,37 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// SourceMap links instructions back to the source they were compiled from.
+// It holds a mapping for each instruction, sorted by offset. Instructions
+// that came from no particular source have none.
+type SourceMap []SourceMapping
+
+// SourceMapping gives the source range of the instruction at Offset.
+type SourceMapping struct {
+	Offset int            `json:"offset"`
+	Start  token.Position `json:"start"`
+	End    token.Position `json:"end"`
+}
+
+// Lookup finds the mapping of the instruction at offset, or of the one
+// before it when offset points into its operands.
+func (m SourceMap) Lookup(offset int) (SourceMapping, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if i == 0 {
+		return SourceMapping{}, false
+	}
+
+	return m[i-1], true
+}
+
+// Truncate drops the mappings of instructions at or past offset.
+func (m SourceMap) Truncate(offset int) SourceMap {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset >= offset })
+
+	return m[:i]
+}
@@ -0,0 +1,412 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// Assemble builds bytecode from Monkey assembly, a line per instruction
+// named as in the code package:
+//
+//	.const two 2              ; adds 2 to the constant pool as "two"
+//	.func double 1            ; a function of 1 parameter
+//	    OpGetLocal 0
+//	    OpConstant two
+//	    OpMul
+//	    OpReturnValue
+//	.end
+//
+//	    OpClosure double 0
+//	    OpConstant 0          ; operands may also be plain numbers
+//	    OpCall 1
+//	    OpJump done
+//	done:
+//	    OpPop
+//
+// Everything outside a `.func` block is the main program. `.func NAME
+// PARAMS [LOCALS]` takes as many locals as parameters unless told
+// otherwise. Constants and functions go into the pool in the order they
+// are declared and may be used before that. A jump goes to a label of its
+// own block, and OpGetBuiltin takes the name of a builtin of stdlib.
+//
+// Instructions are mapped to the line they are on, so runtime errors point
+// at the assembly.
+func Assemble(source string, stdlib *object.Stdlib) (*Bytecode, error) {
+	symbols := NewSymbolTable()
+	for i, name := range stdlib.Names() {
+		symbols.DefineBuiltin(i, name)
+	}
+
+	main := &asmBlock{labels: map[string]int{}}
+	a := &assembler{
+		names:   map[string]int{},
+		symbols: symbols,
+		main:    main,
+		block:   main,
+	}
+
+	offset := 0
+	for i, line := range strings.Split(source, "\n") {
+		start := token.Position{Offset: offset, Line: i + 1, Column: 1}
+		if err := a.line(line, start); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		offset += len(line) + 1
+	}
+	if a.block != a.main {
+		return nil, fmt.Errorf("line %d: .func %s has no .end", a.block.line, a.block.name)
+	}
+
+	for _, block := range a.functions {
+		ins, sourceMap, err := a.encode(block)
+		if err != nil {
+			return nil, err
+		}
+		block.fn.Instructions, block.fn.SourceMap = ins, sourceMap
+	}
+
+	ins, sourceMap, err := a.encode(a.main)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bytecode{
+		Instructions: ins,
+		Constants:    a.constants,
+		SourceMap:    sourceMap,
+		Symbols:      symbols,
+	}, nil
+}
+
+type assembler struct {
+	constants []object.Object
+	// The pool index of every named constant and function.
+	names   map[string]int
+	symbols *SymbolTable
+
+	main      *asmBlock
+	functions []*asmBlock
+	// The block lines are being added to.
+	block *asmBlock
+}
+
+// asmBlock is the main program or a function.
+type asmBlock struct {
+	name string
+	// The line of its .func, and the function it becomes.
+	line int
+	fn   *object.CompiledFunction
+
+	instructions []asmInstruction
+	// The index of the instruction every label stands before.
+	labels map[string]int
+}
+
+type asmInstruction struct {
+	op         code.Opcode
+	def        *code.Definition
+	operands   []string
+	start, end token.Position
+}
+
+// asmField is a word of a line, or a quoted string, and the column it
+// starts at.
+type asmField struct {
+	text   string
+	column int
+}
+
+func (a *assembler) line(line string, start token.Position) error {
+	fields, err := splitFields(line)
+	if err != nil {
+		return err
+	}
+
+	for len(fields) > 0 && strings.HasSuffix(fields[0].text, ":") {
+		name := strings.TrimSuffix(fields[0].text, ":")
+		if !isAsmName(name) {
+			return fmt.Errorf("bad label %q", name)
+		}
+		if _, ok := a.block.labels[name]; ok {
+			return fmt.Errorf("label %s is already defined", name)
+		}
+
+		a.block.labels[name] = len(a.block.instructions)
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	directive, args := fields[0].text, fields[1:]
+	switch {
+	case directive == ".const":
+		return a.constant(args)
+	case directive == ".func":
+		return a.function(args, start.Line)
+	case directive == ".end":
+		if a.block == a.main {
+			return errors.New(".end without .func")
+		}
+		if len(args) != 0 {
+			return errors.New(".end takes nothing after it")
+		}
+		a.block = a.main
+		return nil
+	case strings.HasPrefix(directive, "."):
+		return fmt.Errorf("unknown directive %s", directive)
+	}
+
+	return a.instruction(fields, start)
+}
+
+func (a *assembler) constant(args []asmField) error {
+	if len(args) != 2 {
+		return errors.New("usage: .const NAME VALUE")
+	}
+	if err := a.define(args[0].text); err != nil {
+		return err
+	}
+
+	value, err := parseAsmConstant(args[1].text)
+	if err != nil {
+		return err
+	}
+	a.constants = append(a.constants, value)
+
+	return nil
+}
+
+func (a *assembler) function(args []asmField, line int) error {
+	if a.block != a.main {
+		return fmt.Errorf(".func can't be inside .func %s", a.block.name)
+	}
+	if len(args) != 2 && len(args) != 3 {
+		return errors.New("usage: .func NAME PARAMS [LOCALS]")
+	}
+
+	params, err := strconv.Atoi(args[1].text)
+	if err != nil || params < 0 || params > 255 {
+		return fmt.Errorf("parameters must be a number from 0 to 255, got %s", args[1].text)
+	}
+	locals := params
+	if len(args) == 3 {
+		locals, err = strconv.Atoi(args[2].text)
+		if err != nil || locals < params || locals > 255 {
+			return fmt.Errorf("locals must be a number from %d to 255, got %s", params, args[2].text)
+		}
+	}
+
+	if err := a.define(args[0].text); err != nil {
+		return err
+	}
+
+	fn := &object.CompiledFunction{
+		Name:          args[0].text,
+		NumLocals:     locals,
+		NumParameters: params,
+	}
+	a.constants = append(a.constants, fn)
+
+	a.block = &asmBlock{name: fn.Name, line: line, fn: fn, labels: map[string]int{}}
+	a.functions = append(a.functions, a.block)
+
+	return nil
+}
+
+// define names the constant about to be added to the pool.
+func (a *assembler) define(name string) error {
+	if !isAsmName(name) {
+		return fmt.Errorf("bad name %q", name)
+	}
+	if _, ok := a.names[name]; ok {
+		return fmt.Errorf("%s is already defined", name)
+	}
+
+	a.names[name] = len(a.constants)
+
+	return nil
+}
+
+func (a *assembler) instruction(fields []asmField, start token.Position) error {
+	name := fields[0].text
+	op, def, ok := code.LookupName(name)
+	if !ok {
+		return fmt.Errorf("unknown instruction %q", name)
+	}
+
+	operands := []string{}
+	for _, field := range fields[1:] {
+		operands = append(operands, field.text)
+	}
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Errorf("%s takes %d operands, got %d", name, len(def.OperandWidths), len(operands))
+	}
+
+	last := fields[len(fields)-1]
+	end := start
+	end.Column = last.column + len(last.text)
+	end.Offset += end.Column - 1
+	start.Column = fields[0].column
+	start.Offset += start.Column - 1
+
+	a.block.instructions = append(a.block.instructions, asmInstruction{
+		op:       op,
+		def:      def,
+		operands: operands,
+		start:    start,
+		end:      end,
+	})
+
+	return nil
+}
+
+// encode lays out the instructions of block once every name is known.
+func (a *assembler) encode(block *asmBlock) (code.Instructions, code.SourceMap, error) {
+	offsets := make([]int, len(block.instructions)+1)
+	for i, in := range block.instructions {
+		offsets[i+1] = offsets[i] + in.def.Width()
+	}
+
+	ins := code.Instructions{}
+	sourceMap := code.SourceMap{}
+
+	for i, in := range block.instructions {
+		operands := make([]int, len(in.operands))
+		for j, text := range in.operands {
+			value, err := a.operand(block, in.op, j, text, offsets)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", in.start.Line, err)
+			}
+
+			width := in.def.OperandWidths[j]
+			if value < 0 || value >= 1<<(8*width) {
+				return nil, nil, fmt.Errorf("line %d: %s takes a %d-byte operand, %d is out of range",
+					in.start.Line, in.def.Name, width, value)
+			}
+			operands[j] = value
+		}
+
+		ins = append(ins, code.Make(in.op, operands...)...)
+		sourceMap = append(sourceMap, code.SourceMapping{
+			Offset: offsets[i],
+			Start:  in.start,
+			End:    in.end,
+		})
+	}
+
+	return ins, sourceMap, nil
+}
+
+// operand works out operand j of op, a number or a name.
+func (a *assembler) operand(block *asmBlock, op code.Opcode, j int, text string, offsets []int) (int, error) {
+	if value, err := strconv.Atoi(text); err == nil {
+		return value, nil
+	}
+
+	if j == 0 {
+		switch op {
+		case code.OpJump, code.OpJumpNotTruthy:
+			index, ok := block.labels[text]
+			if !ok {
+				return 0, fmt.Errorf("undefined label %s", text)
+			}
+			return offsets[index], nil
+
+		case code.OpConstant, code.OpConstantWide, code.OpClosure, code.OpGetField:
+			index, ok := a.names[text]
+			if !ok {
+				return 0, fmt.Errorf("undefined constant %s", text)
+			}
+			return index, nil
+
+		case code.OpGetBuiltin, code.OpSetBuiltin:
+			symbol, ok := a.symbols.Resolve(text)
+			if !ok {
+				return 0, fmt.Errorf("undefined builtin %s", text)
+			}
+			return symbol.Index, nil
+		}
+	}
+
+	return 0, fmt.Errorf("operand %q must be a number", text)
+}
+
+func parseAsmConstant(text string) (object.Object, error) {
+	if strings.HasPrefix(text, `"`) {
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", text)
+		}
+		return &object.String{Value: value}, nil
+	}
+
+	if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return &object.Integer{Value: value}, nil
+	}
+	if value, ok := new(big.Int).SetString(text, 10); ok {
+		return &object.BigInteger{Value: value}, nil
+	}
+
+	return nil, fmt.Errorf("%s is not an integer or a quoted string", text)
+}
+
+// splitFields splits a line at spaces, keeping quoted strings whole and
+// dropping what follows a `;`.
+func splitFields(line string) ([]asmField, error) {
+	fields := []asmField{}
+
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case c == ';':
+			return fields, nil
+		}
+
+		start := i
+		if line[i] == '"' {
+			i++
+			for i < len(line) && line[i] != '"' {
+				if line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(line) {
+				return nil, errors.New("unterminated string")
+			}
+			i++
+		} else {
+			for i < len(line) && !strings.ContainsRune(" \t\r;", rune(line[i])) {
+				i++
+			}
+		}
+
+		fields = append(fields, asmField{text: line[start:i], column: start + 1})
+	}
+
+	return fields, nil
+}
+
+func isAsmName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return true
+}
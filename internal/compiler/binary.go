@@ -0,0 +1,460 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// The layout of a bytecode file, numbers being unsigned varints unless
+// said otherwise:
+//
+//	magic        "MKC\x00"
+//	version      BinaryVersion, 2 bytes big endian
+//	opcodes      code.Version, 2 bytes big endian
+//	flags        1 byte, flagDebug
+//	builtins     count, then the name of each builtin the code uses
+//	instructions the main program, length then bytes
+//	source map   with flagDebug, see binaryWriter.sourceMap
+//	constants    count, then a tag byte and the value of each
+//
+// Compiled functions are written like the main program, after their name,
+// locals and parameters, and followed by the names of their locals and
+// free variables with flagDebug.
+//
+// The operand of OpGetBuiltin is an index into the builtins of the file
+// rather than of a standard library, so files keep calling the same builtins
+// when the library changes.
+const (
+	binaryMagic = "MKC\x00"
+	// BinaryVersion numbers the layout above.
+	BinaryVersion = 2
+)
+
+const flagDebug = 1 << 0
+
+const (
+	tagInteger byte = iota + 1
+	tagBigInteger
+	tagString
+	tagBoolean
+	tagNull
+	tagFunction
+	tagStructType
+)
+
+// Marshal encodes bytecode compiled against stdlib for saving. debug keeps
+// the source maps and the names of locals and free variables, which runtime
+// errors and the disassembler use. The names of globals are never kept.
+func Marshal(bytecode *Bytecode, stdlib *object.Stdlib, debug bool) ([]byte, error) {
+	w := &binaryWriter{debug: debug, stdlib: stdlib, builtins: map[int]int{}}
+
+	if err := w.code(bytecode.Instructions, bytecode.SourceMap); err != nil {
+		return nil, err
+	}
+
+	w.uvarint(len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		if err := w.constant(constant); err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	// The builtins are only known once the code is written.
+	body := w.buf
+	w.buf = nil
+
+	w.buf = append(w.buf, binaryMagic...)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, BinaryVersion)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, code.Version)
+
+	var flags byte
+	if debug {
+		flags |= flagDebug
+	}
+	w.buf = append(w.buf, flags)
+
+	w.strings(w.builtinNames)
+
+	return append(w.buf, body...), nil
+}
+
+// Unmarshal decodes bytecode saved by Marshal to run against stdlib, failing
+// if stdlib lacks a builtin the bytecode uses. It only checks the file is
+// well formed otherwise; verify the bytecode before running it.
+func Unmarshal(data []byte, stdlib *object.Stdlib) (*Bytecode, error) {
+	if len(data) < len(binaryMagic)+5 || string(data[:len(binaryMagic)]) != binaryMagic {
+		return nil, errors.New("not a Monkey bytecode file")
+	}
+	data = data[len(binaryMagic):]
+
+	if version := binary.BigEndian.Uint16(data); version != BinaryVersion {
+		return nil, fmt.Errorf("bytecode file version %d, want %d", version, BinaryVersion)
+	}
+	if version := binary.BigEndian.Uint16(data[2:]); version != code.Version {
+		return nil, fmt.Errorf("bytecode for opcode set %d, want %d", version, code.Version)
+	}
+
+	r := &binaryReader{data: data[5:], debug: data[4]&flagDebug != 0}
+
+	names := r.strings()
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, name := range names {
+		index, ok := stdlib.Index(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown builtin %q", name)
+		}
+		r.builtins = append(r.builtins, index)
+	}
+
+	bytecode := &Bytecode{}
+	bytecode.Instructions, bytecode.SourceMap = r.code()
+
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, r.constant())
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("%d bytes left over", len(r.data))
+	}
+
+	return bytecode, nil
+}
+
+type binaryWriter struct {
+	buf   []byte
+	debug bool
+
+	stdlib *object.Stdlib
+	// The index in builtinNames of every builtin used, by its index in
+	// stdlib.
+	builtins     map[int]int
+	builtinNames []string
+}
+
+func (w *binaryWriter) uvarint(n int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(n))
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uvarint(len(s))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *binaryWriter) strings(s []string) {
+	w.uvarint(len(s))
+	for _, s := range s {
+		w.string(s)
+	}
+}
+
+func (w *binaryWriter) code(ins code.Instructions, sourceMap code.SourceMap) error {
+	ins, err := mapBuiltins(ins, w.builtin)
+	if err != nil {
+		return err
+	}
+
+	w.string(string(ins))
+	if w.debug {
+		w.sourceMap(sourceMap)
+	}
+
+	return nil
+}
+
+// builtin adds the builtin at index in stdlib to those of the file.
+func (w *binaryWriter) builtin(index int) (int, error) {
+	if i, ok := w.builtins[index]; ok {
+		return i, nil
+	}
+
+	builtin, ok := w.stdlib.At(index)
+	if !ok {
+		return 0, fmt.Errorf("unknown builtin %d", index)
+	}
+
+	w.builtins[index] = len(w.builtinNames)
+	w.builtinNames = append(w.builtinNames, builtin.Name)
+
+	return w.builtins[index], nil
+}
+
+// mapBuiltins copies ins with the operand of every OpGetBuiltin and
+// OpSetBuiltin passed through index.
+func mapBuiltins(ins code.Instructions, index func(int) (int, error)) (code.Instructions, error) {
+	ins = slices.Clone(ins)
+
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return nil, fmt.Errorf("at %04d: %w", offset, err)
+		}
+		if offset+def.Width() > len(ins) {
+			return nil, fmt.Errorf("at %04d: %s is cut short", offset, def.Name)
+		}
+
+		switch code.Opcode(ins[offset]) {
+		case code.OpGetBuiltin, code.OpSetBuiltin:
+			i, err := index(int(ins[offset+1]))
+			if err != nil {
+				return nil, fmt.Errorf("at %04d: %w", offset, err)
+			}
+			ins[offset+1] = byte(i)
+		}
+
+		offset += def.Width()
+	}
+
+	return ins, nil
+}
+
+// sourceMap writes the count of mappings, then the offset of each and the
+// offset, line and column of its start and end.
+func (w *binaryWriter) sourceMap(sourceMap code.SourceMap) {
+	w.uvarint(len(sourceMap))
+	for _, mapping := range sourceMap {
+		w.uvarint(mapping.Offset)
+		for _, pos := range []token.Position{mapping.Start, mapping.End} {
+			w.uvarint(pos.Offset)
+			w.uvarint(pos.Line)
+			w.uvarint(pos.Column)
+		}
+	}
+}
+
+func (w *binaryWriter) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		w.buf = append(w.buf, tagInteger)
+		w.buf = binary.AppendVarint(w.buf, obj.Value)
+
+	case *object.BigInteger:
+		w.buf = append(w.buf, tagBigInteger)
+		w.string(obj.Value.String())
+
+	case *object.String:
+		w.buf = append(w.buf, tagString)
+		w.string(obj.Value)
+
+	case *object.Boolean:
+		w.buf = append(w.buf, tagBoolean)
+		if obj.Value {
+			w.buf = append(w.buf, 1)
+		} else {
+			w.buf = append(w.buf, 0)
+		}
+
+	case *object.Null:
+		w.buf = append(w.buf, tagNull)
+
+	case *object.CompiledFunction:
+		w.buf = append(w.buf, tagFunction)
+		w.string(obj.Name)
+		w.uvarint(obj.NumLocals)
+		w.uvarint(obj.NumParameters)
+		if err := w.code(obj.Instructions, obj.SourceMap); err != nil {
+			return err
+		}
+		if w.debug {
+			w.strings(obj.LocalNames)
+			w.strings(obj.FreeNames)
+		}
+
+	case *object.StructType:
+		w.buf = append(w.buf, tagStructType)
+		w.string(obj.Name)
+		w.strings(obj.Fields)
+
+	default:
+		return fmt.Errorf("can't save a %s", obj.Type())
+	}
+
+	return nil
+}
+
+// binaryReader decodes what binaryWriter wrote. The first error sticks,
+// and everything read after it is a zero value.
+type binaryReader struct {
+	data  []byte
+	debug bool
+	err   error
+
+	// The index in the standard library of every builtin of the file.
+	builtins []int
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.data = nil
+}
+
+func (r *binaryReader) byte() byte {
+	if len(r.data) == 0 {
+		r.fail(errors.New("file is cut short"))
+		return 0
+	}
+
+	b := r.data[0]
+	r.data = r.data[1:]
+
+	return b
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	n, read := binary.Uvarint(r.data)
+	if read <= 0 {
+		r.fail(errors.New("bad number"))
+		return 0
+	}
+	r.data = r.data[read:]
+
+	return n
+}
+
+func (r *binaryReader) varint() int64 {
+	n, read := binary.Varint(r.data)
+	if read <= 0 {
+		r.fail(errors.New("bad number"))
+		return 0
+	}
+	r.data = r.data[read:]
+
+	return n
+}
+
+func (r *binaryReader) int() int {
+	n := r.uvarint()
+	if n > math.MaxInt32 {
+		r.fail(fmt.Errorf("number %d out of range", n))
+		return 0
+	}
+
+	return int(n)
+}
+
+// count reads how many of something follow. Every one takes at least a
+// byte, which bounds what is worth allocating.
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.fail(errors.New("file is cut short"))
+		return 0
+	}
+
+	return int(n)
+}
+
+func (r *binaryReader) string() string {
+	n := r.count()
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+
+	return s
+}
+
+func (r *binaryReader) strings() []string {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+
+	s := make([]string, n)
+	for i := range s {
+		s[i] = r.string()
+	}
+
+	return s
+}
+
+func (r *binaryReader) code() (code.Instructions, code.SourceMap) {
+	ins, err := mapBuiltins(code.Instructions(r.string()), r.builtin)
+	if err != nil {
+		r.fail(err)
+		return nil, nil
+	}
+	if !r.debug {
+		return ins, nil
+	}
+
+	var sourceMap code.SourceMap
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		mapping := code.SourceMapping{Offset: r.int()}
+		for _, pos := range []*token.Position{&mapping.Start, &mapping.End} {
+			pos.Offset = r.int()
+			pos.Line = r.int()
+			pos.Column = r.int()
+		}
+		sourceMap = append(sourceMap, mapping)
+	}
+
+	return ins, sourceMap
+}
+
+func (r *binaryReader) builtin(index int) (int, error) {
+	if index >= len(r.builtins) {
+		return 0, fmt.Errorf("builtin %d out of range, the file has %d", index, len(r.builtins))
+	}
+
+	return r.builtins[index], nil
+}
+
+func (r *binaryReader) constant() object.Object {
+	switch tag := r.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: r.varint()}
+
+	case tagBigInteger:
+		text := r.string()
+		value, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			r.fail(fmt.Errorf("bad big integer %q", text))
+			return nil
+		}
+		return &object.BigInteger{Value: value}
+
+	case tagString:
+		return &object.String{Value: r.string()}
+
+	case tagBoolean:
+		return object.NativeBool(r.byte() != 0)
+
+	case tagNull:
+		return object.NULL
+
+	case tagFunction:
+		fn := &object.CompiledFunction{
+			Name:          r.string(),
+			NumLocals:     r.int(),
+			NumParameters: r.int(),
+		}
+		fn.Instructions, fn.SourceMap = r.code()
+		if r.debug {
+			fn.LocalNames = r.strings()
+			fn.FreeNames = r.strings()
+		}
+		return fn
+
+	case tagStructType:
+		return &object.StructType{Name: r.string(), Fields: r.strings()}
+
+	default:
+		r.fail(fmt.Errorf("unknown constant tag %d", tag))
+		return nil
+	}
+}
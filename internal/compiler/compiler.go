@@ -2,7 +2,7 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
+	"math"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
@@ -18,18 +18,33 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	// Where in the source each instruction of the scope came from.
+	sourceMap code.SourceMap
 }
 
 type Compiler struct {
 	constants []object.Object
+	// The indexes of the integers, strings and booleans in constants by
+	// hash key, so every value is only added once.
+	constantIndexes map[object.HashKey][]int
 
 	symbolTable *SymbolTable
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// The source of the innermost node being compiled, which emit records
+	// for every instruction.
+	span ast.Span
 }
 
 func New() *Compiler {
+	return NewWithStdlib(object.DefaultStdlib())
+}
+
+// NewWithStdlib creates a compiler that resolves builtins against stdlib.
+// The bytecode must run on a VM with the same stdlib.
+func NewWithStdlib(stdlib *object.Stdlib) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
@@ -37,13 +52,14 @@ func New() *Compiler {
 	}
 
 	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+	for i, name := range stdlib.Names() {
+		symbolTable.DefineBuiltin(i, name)
 	}
 
 	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: symbolTable,
+		constants:       []object.Object{},
+		constantIndexes: map[object.HashKey][]int{},
+		symbolTable:     symbolTable,
 
 		scopes:     []CompilationScope{mainScope},
 		scopeIndex: 0,
@@ -54,11 +70,23 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for i, constant := range constants {
+		if hashable, ok := constant.(object.Hashable); ok {
+			key := hashable.HashKey()
+			compiler.constantIndexes[key] = append(compiler.constantIndexes[key], i)
+		}
+	}
 
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	outer := c.span
+	if span := node.NodeSpan(); span != (ast.Span{}) {
+		c.span = span
+	}
+	defer func() { c.span = outer }()
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -70,7 +98,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return object.Errorf("undefined variable %s", node.Value)
 		}
 
 		c.loadSymbol(symbol)
@@ -89,6 +117,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpSetLocal, symbol.Index)
 		}
 
+	case *ast.StructStatement:
+		symbol := c.symbolTable.Define(node.Name.Value)
+
+		def := &object.StructType{Name: node.Name.Value}
+		for _, field := range node.Fields {
+			def.Fields = append(def.Fields, field.Value)
+		}
+		c.emitConstant(def)
+
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+
 	case *ast.ExpressionStatement:
 		if err := c.Compile(node.Expression); err != nil {
 			return err
@@ -145,7 +188,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return object.Errorf("unknown operator %s", node.Operator)
 		}
 
 	case *ast.PrefixExpression:
@@ -159,7 +202,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return object.Errorf("unknown operator %s", node.Operator)
 		}
 
 	case *ast.IfExpression:
@@ -210,15 +253,64 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpIndex)
 
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSlice)
+
+	case *ast.FieldExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		name := &object.String{Value: node.Field.Value}
+		index := c.addConstant(name)
+		if index > math.MaxUint16 {
+			return errConstantOutOfReach(code.OpGetField, index)
+		}
+		c.emit(code.OpGetField, index)
+
+	case *ast.WithExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		// The new values go on the stack after their field names, like the
+		// pairs of a hash.
+		for _, field := range node.Fields {
+			name := &object.String{Value: field.Name.Value}
+			c.emitConstant(name)
+
+			if err := c.Compile(field.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpWith, len(node.Fields)*2)
+
 	// Literals
 
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(integer))
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInteger{Value: node.Big}
+		}
+		c.emitConstant(integer)
 
 	case *ast.StringLiteral:
 		string := &object.String{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(string))
+		c.emitConstant(string)
 
 	case *ast.Boolean:
 		if node.Value {
@@ -236,21 +328,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, key := range keys {
-			if err := c.Compile(key); err != nil {
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
 				return err
 			}
 
-			if err := c.Compile(node.Pairs[key]); err != nil {
+			if err := c.Compile(pair.Value); err != nil {
 				return err
 			}
 		}
@@ -277,6 +360,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.names(LocalScope, numLocals)
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
 		instructions := c.leaveScope()
 
 		for _, sym := range freeSymbols {
@@ -284,12 +369,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			SourceMap:     sourceMap,
+			LocalNames:    localNames,
+		}
+		for _, sym := range freeSymbols {
+			compiledFn.FreeNames = append(compiledFn.FreeNames, sym.Name)
 		}
 
 		fnIndex := c.addConstant(compiledFn)
+		if fnIndex > math.MaxUint16 {
+			return errConstantOutOfReach(code.OpClosure, fnIndex)
+		}
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 
 	case *ast.CallExpression:
@@ -310,9 +404,46 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// addConstant adds obj to the constant pool and returns its index. An
+// integer, string or boolean already in the pool is reused rather than
+// added again. Functions and struct types are always added.
 func (c *Compiler) addConstant(obj object.Object) int {
+	hashable, ok := obj.(object.Hashable)
+	if !ok {
+		c.constants = append(c.constants, obj)
+		return len(c.constants) - 1
+	}
+
+	key := hashable.HashKey()
+	for _, i := range c.constantIndexes[key] {
+		if object.Equal(c.constants[i], obj) {
+			return i
+		}
+	}
+
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	index := len(c.constants) - 1
+	c.constantIndexes[key] = append(c.constantIndexes[key], index)
+
+	return index
+}
+
+// emitConstant loads obj from the constant pool, with OpConstantWide once
+// the pool has outgrown the operand of OpConstant.
+func (c *Compiler) emitConstant(obj object.Object) int {
+	index := c.addConstant(obj)
+	if index > math.MaxUint16 {
+		return c.emit(code.OpConstantWide, index)
+	}
+
+	return c.emit(code.OpConstant, index)
+}
+
+// errConstantOutOfReach reports a constant too far into the pool for the
+// 2-byte operand of op, which has no wide form.
+func errConstantOutOfReach(op code.Opcode, index int) error {
+	def, _ := code.Lookup(byte(op))
+	return object.Errorf("constant pool too large: %s can't reach constant %d", def.Name, index)
 }
 
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
@@ -320,10 +451,24 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
+	c.recordSource(pos)
 
 	return pos
 }
 
+func (c *Compiler) recordSource(pos int) {
+	if c.span == (ast.Span{}) {
+		return
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.sourceMap = append(scope.sourceMap, code.SourceMapping{
+		Offset: pos,
+		Start:  c.span.Start,
+		End:    c.span.End,
+	})
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -387,6 +532,7 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].sourceMap = c.scopes[c.scopeIndex].sourceMap.Truncate(last.Position)
 }
 
 func (c *Compiler) loadSymbol(s Symbol) {
@@ -432,10 +578,18 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		Symbols:      c.symbolTable,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// SourceMap covers Instructions. Compiled functions among the Constants
+	// carry their own.
+	SourceMap code.SourceMap
+	// Symbols is the global symbol table the code was compiled against,
+	// which names its globals and builtins. It is nil when unknown.
+	Symbols *SymbolTable `json:"-"`
 }
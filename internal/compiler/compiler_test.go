@@ -2,6 +2,8 @@ package compiler
 
 import (
 	"fmt"
+	"math"
+	"reflect"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
@@ -303,14 +305,14 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []any{1, 2, 3, 1, 1},
+			expectedConstants: []any{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -318,18 +320,87 @@ func TestIndexExpressions(t *testing.T) {
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []any{1, 2, 2, 1},
+			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "[1, 2][1:]",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"abc"[:-1]`,
+			expectedConstants: []any{"abc", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMinus),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestStructs(t *testing.T) {
+	point := &object.StructType{Name: "Point", Fields: []string{"x", "y"}}
+
+	tests := []compilerTestCase{
+		{
+			input:             "struct Point { x, y }; Point(1, 2).x",
+			expectedConstants: []any{point, 1, 2, "x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpGetField, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(p) { struct Point { x, y }; p with { y: 3 } }",
+			expectedConstants: []any{
+				point,
+				"y",
+				3,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpWith, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -919,6 +990,632 @@ func TestLetStatementScopes(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestSourceMaps(t *testing.T) {
+	input := "let x = 1;\nif (x > 0) { x + 2 };\nfn(a) {\n  a * 2\n}"
+
+	program := parse(input)
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	// Each instruction maps to the innermost node it was emitted for. The
+	// OpPop dropped from the end of the consequence leaves no mapping behind.
+	expectedMain := []string{
+		"0 1:9-1:10", "3 1:1-1:11",
+		"6 2:5-2:6", "9 2:9-2:10", "12 2:5-2:10", "13 2:1-2:21",
+		"16 2:14-2:15", "19 2:18-2:19", "22 2:14-2:19", "23 2:1-2:21",
+		"26 2:1-2:21", "27 2:1-2:22",
+		"28 3:1-5:2", "32 3:1-5:2",
+	}
+	testSourceMap(t, expectedMain, bytecode.SourceMap)
+
+	fn, ok := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("last constant is not a function. got=%T", bytecode.Constants[len(bytecode.Constants)-1])
+	}
+
+	expectedFn := []string{"0 4:3-4:4", "2 4:7-4:8", "5 4:3-4:8", "6 4:3-4:8"}
+	testSourceMap(t, expectedFn, fn.SourceMap)
+}
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * x", "(3 * x)"},
+		{"10 / 0", "(10 / 0)"},
+		{"1 < 2", "true"},
+		{"2 == 2", "true"},
+		{"true != false", "true"},
+		{"!true", "false"},
+		{"!5", "false"},
+		{"-(2 + 3)", "-5"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{`"a" + "b"`, "ab"},
+		{`"a" == "a"`, "(a == a)"},
+		{"let f = fn() { if (1 > 2) { 3 * 4 } }", "let f = fn()iffalse 12else ;"},
+		{"[1 + 1, {2 * 2: -1}]", "[2, {4:-1}]"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		actual := FoldConstants(program).String()
+		if actual != tt.expected {
+			t.Errorf("%s: want=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		passes   Passes
+		expected []code.Instructions
+	}{
+		{
+			input:  "!false",
+			passes: Passes{Peephole: true},
+			expected: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:  "if (true) { 10 } else { 20 }",
+			passes: Passes{Peephole: true},
+			expected: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:  "if (false) { 10 }",
+			passes: Passes{Peephole: true},
+			expected: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:  "if (1 > 2) { 10 } else { 20 }",
+			passes: Passes{ConstantFolding: true, Peephole: true},
+			expected: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The inner if jumps past its else right onto the jump past
+			// the outer else.
+			input:  "let x = true; let y = false; if (x) { if (y) { 1 } else { 2 } } else { 3 }",
+			passes: Passes{JumpThreading: true},
+			expected: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpSetGlobal, 1),
+				// 0008
+				code.Make(code.OpGetGlobal, 0),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 32),
+				// 0014
+				code.Make(code.OpGetGlobal, 1),
+				// 0017
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0020
+				code.Make(code.OpConstant, 0),
+				// 0023
+				code.Make(code.OpJump, 35),
+				// 0026
+				code.Make(code.OpConstant, 1),
+				// 0029
+				code.Make(code.OpJump, 35),
+				// 0032
+				code.Make(code.OpConstant, 2),
+				// 0035
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		stages, err := Optimize(program, object.DefaultStdlib(), tt.passes)
+		if err != nil {
+			t.Fatalf("%s: optimizer error: %s", tt.input, err)
+		}
+
+		final := stages[len(stages)-1].Bytecode
+		if err := testInstructions(tt.expected, final.Instructions); err != nil {
+			t.Errorf("%s: %s", tt.input, err)
+		}
+	}
+}
+
+func TestOptimizeStages(t *testing.T) {
+	stages, err := Optimize(parse("fn() { !true }"), object.DefaultStdlib(), AllPasses())
+	if err != nil {
+		t.Fatalf("optimizer error: %s", err)
+	}
+
+	passes := []string{}
+	for _, stage := range stages {
+		passes = append(passes, stage.Pass)
+	}
+	if fmt.Sprint(passes) != "[none constantFolding peephole jumpThreading]" {
+		t.Errorf("wrong stages. got=%v", passes)
+	}
+
+	// Later passes leave the functions of earlier stages alone.
+	before := stages[0].Bytecode.Constants[0].(*object.CompiledFunction)
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpBang),
+		code.Make(code.OpReturnValue),
+	}, before.Instructions)
+	if err != nil {
+		t.Errorf("first stage changed: %s", err)
+	}
+
+	after := stages[len(stages)-1].Bytecode.Constants[0].(*object.CompiledFunction)
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpFalse),
+		code.Make(code.OpReturnValue),
+	}, after.Instructions)
+	if err != nil {
+		t.Errorf("last stage: %s", err)
+	}
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"hello"; 1; "hello"; 1`,
+			expectedConstants: []any{"hello", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Functions are never shared, even when they read the same.
+			input: `fn() { "a" }; fn() { "a" }`,
+			expectedConstants: []any{
+				"a",
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 1 and "1" hash apart, and are both kept.
+			input:             `1; "1"`,
+			expectedConstants: []any{1, "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantDeduplicationAcrossState(t *testing.T) {
+	symbols := NewSymbolTable()
+	constants := []object.Object{&object.String{Value: "hello"}}
+
+	compiler := NewWithState(symbols, constants)
+	if err := compiler.Compile(parse(`"hello"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	if len(bytecode.Constants) != 1 {
+		t.Fatalf("constant added again. got=%d constants", len(bytecode.Constants))
+	}
+	err := testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestConstantWide(t *testing.T) {
+	constants := make([]object.Object, math.MaxUint16+1)
+	for i := range constants {
+		constants[i] = &object.Integer{Value: int64(i)}
+	}
+
+	compiler := NewWithState(NewSymbolTable(), constants)
+	if err := compiler.Compile(parse(`"wide"; 7`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := testInstructions([]code.Instructions{
+		code.Make(code.OpConstantWide, 65_536),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 7),
+		code.Make(code.OpPop),
+	}, compiler.Bytecode().Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	compiler = NewWithState(NewSymbolTable(), constants)
+	err = compiler.Compile(parse(`fn() { 1 }`))
+	want := "constant pool too large: OpClosure can't reach constant 65536"
+	if err == nil || err.Error() != want {
+		t.Fatalf("wrong error. want=%q, got=%v", want, err)
+	}
+}
+
+func TestConstantPool(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse(`let add = fn(a, b) { a + 1 }; add(1, 2)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	pool := compiler.Bytecode().ConstantPool()
+
+	expected := []Constant{
+		{Index: 0, Type: object.INTEGER_OBJ, Value: "1", References: []Reference{
+			{Function: MainFunction, Offset: 10},
+			{Function: 1, Offset: 2},
+		}},
+		{Index: 1, Type: object.COMPILED_FUNCTION_OBJ, Value: "fn add(2 parameters)", References: []Reference{
+			{Function: MainFunction, Offset: 0},
+		}},
+		{Index: 2, Type: object.INTEGER_OBJ, Value: "2", References: []Reference{
+			{Function: MainFunction, Offset: 13},
+		}},
+	}
+
+	if !reflect.DeepEqual(pool, expected) {
+		t.Fatalf("wrong constant pool.\nwant=%+v\ngot =%+v", expected, pool)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `let total = 0;
+let add = fn(a) { let b = fn() { a + total }; if (a > 1) { b() } else { len("x") } };
+add(2)`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `main:
+  0000 OpConstant 0             ; 0
+  0003 OpSetGlobal 0            ; total
+  0006 OpClosure 4 0            ; fn add(1 parameters)
+  0010 OpSetGlobal 1            ; add
+  0013 OpGetGlobal 1            ; add
+  0016 OpConstant 5             ; 2
+  0019 OpCall 1
+  0021 OpPop
+
+fn add(1 parameters), constant 4 (2 locals, 0 free):
+  0000 OpGetLocal 0             ; a
+  0002 OpClosure 1 1            ; fn b(0 parameters)
+  0006 OpSetLocal 1             ; b
+  0008 OpGetLocal 0             ; a
+  0010 OpConstant 2             ; 1
+  0013 OpGreaterThan
+  0014 OpJumpNotTruthy 24       ; L1
+  0017 OpGetLocal 1             ; b
+  0019 OpCall 0
+  0021 OpJump 31                ; L2
+L1:
+  0024 OpGetBuiltin 0           ; len
+  0026 OpConstant 3             ; "x"
+  0029 OpCall 1
+L2:
+  0031 OpReturnValue
+
+fn b(0 parameters), constant 1 (0 locals, 1 free):
+  0000 OpGetFree 0              ; a
+  0002 OpGetGlobal 0            ; total
+  0005 OpAdd
+  0006 OpReturnValue
+`
+
+	if got := Disassemble(compiler.Bytecode()); got != expected {
+		t.Errorf("wrong disassembly.\nwant=%s\ngot =%s", expected, got)
+	}
+}
+
+func TestDisassembleInvalid(t *testing.T) {
+	bytecode := &Bytecode{
+		Instructions: concatInstructions([]code.Instructions{
+			{255},
+			code.Make(code.OpJump, 2),
+			code.Make(code.OpConstant, 9),
+			{byte(code.OpGetGlobal), 0},
+		}),
+	}
+
+	expected := `main:
+  0000 ERROR: opcode 255 undefined
+  0001 OpJump 2                 ; no instruction there
+  0004 OpConstant 9             ; no such constant
+  0007 ERROR: OpGetGlobal is cut short
+`
+
+	if got := Disassemble(bytecode); got != expected {
+		t.Errorf("wrong disassembly.\nwant=%s\ngot =%s", expected, got)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	input := `
+.const two 2
+.const name "a; b"     ; the ; in the string is kept
+
+.func double 1 2
+    OpGetLocal 0
+    OpConstant two
+    OpMul
+    OpReturnValue
+.end
+
+start:
+    OpClosure double 0
+    OpConstant two
+    OpCall 1
+    OpJumpNotTruthy start
+    OpGetBuiltin len
+    OpJump done
+done: OpPop
+`
+
+	bytecode, err := Assemble(input, object.DefaultStdlib())
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpClosure, 2, 0),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpJumpNotTruthy, 0),
+		code.Make(code.OpGetBuiltin, 0),
+		code.Make(code.OpJump, 17),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	err = testConstants(t, []any{
+		2,
+		"a; b",
+		[]code.Instructions{
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpMul),
+			code.Make(code.OpReturnValue),
+		},
+	}, bytecode.Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+
+	fn := bytecode.Constants[2].(*object.CompiledFunction)
+	if fn.Name != "double" || fn.NumParameters != 1 || fn.NumLocals != 2 {
+		t.Errorf("wrong function. got=%+v", fn)
+	}
+
+	mapping, ok := bytecode.SourceMap.Lookup(17)
+	if !ok || mapping.Start.String() != "19:7" || mapping.End.String() != "19:12" {
+		t.Errorf("wrong source mapping for OpPop. got=%+v", mapping)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"OpFoo", `line 1: unknown instruction "OpFoo"`},
+		{"OpAdd 1", "line 1: OpAdd takes 0 operands, got 1"},
+		{"\nOpGetLocal 256", "line 2: OpGetLocal takes a 1-byte operand, 256 is out of range"},
+		{"OpConstant 65536", "line 1: OpConstant takes a 2-byte operand, 65536 is out of range"},
+		{"OpJump nowhere", "line 1: undefined label nowhere"},
+		{"OpConstant nothing", "line 1: undefined constant nothing"},
+		{"OpGetBuiltin nothing", "line 1: undefined builtin nothing"},
+		{"OpGetLocal x", `line 1: operand "x" must be a number`},
+		{".const a 1\n.const a 2", "line 2: a is already defined"},
+		{".const a true", "line 1: true is not an integer or a quoted string"},
+		{`.const a "open`, "line 1: unterminated string"},
+		{".func f 1\nOpReturn", "line 1: .func f has no .end"},
+		{".func f 1\n.func g 0", "line 2: .func can't be inside .func f"},
+		{".func f 2 1", "line 1: locals must be a number from 2 to 255, got 1"},
+		{".end", "line 1: .end without .func"},
+		{".data", "line 1: unknown directive .data"},
+		{"a:\na:", "line 2: label a is already defined"},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(tt.input, object.DefaultStdlib())
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	input := `struct Point { x, y }
+let big = 99999999999999999999;
+let add = fn(a, b) { let c = a + b; fn() { c } };
+add(1, -2)()`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	bytecode.Constants = append(bytecode.Constants, object.TRUE, object.NULL)
+
+	for _, debug := range []bool{true, false} {
+		data, err := Marshal(bytecode, object.DefaultStdlib(), debug)
+		if err != nil {
+			t.Fatalf("marshal error: %s", err)
+		}
+
+		loaded, err := Unmarshal(data, object.DefaultStdlib())
+		if err != nil {
+			t.Fatalf("unmarshal error: %s", err)
+		}
+
+		if loaded.Instructions.String() != bytecode.Instructions.String() {
+			t.Errorf("wrong instructions.\nwant=%s\ngot =%s", bytecode.Instructions, loaded.Instructions)
+		}
+
+		for i, want := range bytecode.Constants {
+			got := loaded.Constants[i]
+			if got.Type() != want.Type() {
+				t.Fatalf("constant %d has the wrong type. want=%s, got=%s", i, want.Type(), got.Type())
+			}
+
+			wantFn, ok := want.(*object.CompiledFunction)
+			if !ok {
+				if got.Inspect() != want.Inspect() {
+					t.Errorf("constant %d wrong. want=%s, got=%s", i, want.Inspect(), got.Inspect())
+				}
+				continue
+			}
+
+			gotFn := got.(*object.CompiledFunction)
+			expected := *wantFn
+			if !debug {
+				expected.SourceMap, expected.LocalNames, expected.FreeNames = nil, nil, nil
+			}
+			if !reflect.DeepEqual(gotFn, &expected) {
+				t.Errorf("function %d wrong.\nwant=%+v\ngot =%+v", i, &expected, gotFn)
+			}
+		}
+
+		if debug && !reflect.DeepEqual(loaded.SourceMap, bytecode.SourceMap) {
+			t.Errorf("wrong source map.\nwant=%v\ngot =%v", bytecode.SourceMap, loaded.SourceMap)
+		}
+		if !debug && loaded.SourceMap != nil {
+			t.Errorf("source map kept without debug. got=%v", loaded.SourceMap)
+		}
+	}
+}
+
+// A file keeps calling the builtins it was compiled with, by name, when the
+// standard library changes.
+func TestMarshalBuiltins(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse(`push(rest([1, 2]), 3)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	data, err := Marshal(compiler.Bytecode(), object.DefaultStdlib(), false)
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	stdlib := object.DefaultStdlib().Without("len", "unicodeLen", "first")
+	loaded, err := Unmarshal(data, stdlib)
+	if err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+
+	names := []string{}
+	for offset := 0; offset < len(loaded.Instructions); {
+		def, _ := code.Lookup(loaded.Instructions[offset])
+		if code.Opcode(loaded.Instructions[offset]) == code.OpGetBuiltin {
+			builtin, _ := stdlib.At(int(loaded.Instructions[offset+1]))
+			names = append(names, builtin.Name)
+		}
+		offset += def.Width()
+	}
+	if !reflect.DeepEqual(names, []string{"push", "rest"}) {
+		t.Errorf("wrong builtins. want=[push rest], got=%v", names)
+	}
+
+	_, err = Unmarshal(data, object.DefaultStdlib().Without("rest"))
+	if err == nil || err.Error() != `unknown builtin "rest"` {
+		t.Errorf("wrong error for a missing builtin. got=%v", err)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse(`let f = fn(x) { x + "a" }; f("b")`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := Marshal(compiler.Bytecode(), object.DefaultStdlib(), true)
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	// Every cut of the file fails rather than panicking.
+	for i := range data {
+		if _, err := Unmarshal(data[:i], object.DefaultStdlib()); err == nil {
+			t.Fatalf("no error for the first %d bytes", i)
+		}
+	}
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("#!/bin/monkey"), "not a Monkey bytecode file"},
+		{append([]byte("MKC\x00\x00\x09"), data[6:]...), "bytecode file version 9, want 2"},
+		{append([]byte("MKC\x00\x00\x02\x00\x09"), data[8:]...), "bytecode for opcode set 9, want 1"},
+		{append(data, 0), "1 bytes left over"},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal(tt.data, object.DefaultStdlib())
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+
+	bytecode := &Bytecode{Constants: []object.Object{&object.Array{}}}
+	if _, err := Marshal(bytecode, object.DefaultStdlib(), false); err == nil || err.Error() != "constant 0: can't save a ARRAY" {
+		t.Errorf("wrong marshal error. got=%v", err)
+	}
+}
+
 // Helpers
 
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
@@ -1003,6 +1700,12 @@ func testConstants(t *testing.T, expected []any, actual []object.Object) error {
 				return fmt.Errorf("constant %d - testStringObject failed: %s", i, err)
 			}
 
+		case *object.StructType:
+			if actual[i].Inspect() != constant.Inspect() {
+				return fmt.Errorf("constant %d - wrong struct type. want=%s, got=%s",
+					i, constant.Inspect(), actual[i].Inspect())
+			}
+
 		case []code.Instructions:
 			fn, ok := actual[i].(*object.CompiledFunction)
 			if !ok {
@@ -1047,3 +1750,16 @@ func testStringObject(expected string, actual object.Object) error {
 
 	return nil
 }
+
+func testSourceMap(t *testing.T, expected []string, actual code.SourceMap) {
+	t.Helper()
+
+	got := []string{}
+	for _, m := range actual {
+		got = append(got, fmt.Sprintf("%d %s-%s", m.Offset, m.Start, m.End))
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("wrong source map.\nwant=%v\ngot =%v", expected, got)
+	}
+}
@@ -0,0 +1,209 @@
+package compiler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+)
+
+// Disassemble lists the main program and then every compiled function in
+// the order their closures are made, each function after the code that
+// makes it. Operands are annotated with the constant, global, builtin,
+// local or free variable they stand for, and jump targets are labeled.
+//
+// Bytes that aren't an instruction are reported in the listing rather than
+// failing, so any bytecode can be shown.
+func Disassemble(bytecode *Bytecode) string {
+	d := &disassembler{
+		constants: bytecode.Constants,
+		globals:   map[int]string{},
+		builtins:  map[int]string{},
+		listed:    map[int]bool{},
+	}
+	if bytecode.Symbols != nil {
+		for _, symbol := range bytecode.Symbols.store {
+			switch symbol.Scope {
+			case GlobalScope:
+				d.globals[symbol.Index] = symbol.Name
+			case BuiltinScope:
+				d.builtins[symbol.Index] = symbol.Name
+			}
+		}
+	}
+
+	d.function("main:", bytecode.Instructions, nil)
+
+	// Functions no closure is made of, like those an optimization left
+	// behind, go last.
+	for i, constant := range bytecode.Constants {
+		if _, ok := constant.(*object.CompiledFunction); ok && !d.listed[i] {
+			d.compiledFunction(i)
+		}
+	}
+
+	return d.out.String()
+}
+
+type disassembler struct {
+	out strings.Builder
+
+	constants []object.Object
+	globals   map[int]string
+	builtins  map[int]string
+	// The compiled functions already listed, by constant index.
+	listed map[int]bool
+}
+
+func (d *disassembler) compiledFunction(index int) {
+	d.listed[index] = true
+	fn := d.constants[index].(*object.CompiledFunction)
+
+	header := fmt.Sprintf("\n%s, constant %d (%d locals, %d free):",
+		constantValue(fn), index, fn.NumLocals, len(fn.FreeNames))
+	d.function(header, fn.Instructions, fn)
+}
+
+// function lists ins, then the functions it makes closures of. fn is nil
+// for the main program.
+func (d *disassembler) function(header string, ins code.Instructions, fn *object.CompiledFunction) {
+	d.out.WriteString(header + "\n")
+
+	labels := jumpLabels(ins)
+	closures := []int{}
+
+	for offset := 0; offset < len(ins); {
+		if label, ok := labels[offset]; ok {
+			fmt.Fprintf(&d.out, "%s:\n", label)
+		}
+
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			fmt.Fprintf(&d.out, "  %04d ERROR: %s\n", offset, err)
+			offset++
+			continue
+		}
+		if offset+def.Width() > len(ins) {
+			fmt.Fprintf(&d.out, "  %04d ERROR: %s is cut short\n", offset, def.Name)
+			break
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		op := code.Opcode(ins[offset])
+
+		line := fmt.Sprintf("%s %s", def.Name, strings.Trim(fmt.Sprint(operands), "[]"))
+		line = strings.TrimSpace(line)
+		if note := d.annotate(op, operands, fn, labels); note != "" {
+			line = fmt.Sprintf("%-24s ; %s", line, note)
+		}
+		fmt.Fprintf(&d.out, "  %04d %s\n", offset, line)
+
+		if op == code.OpClosure && d.isFunction(operands[0]) {
+			closures = append(closures, operands[0])
+		}
+
+		offset += 1 + read
+	}
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&d.out, "%s:\n", label)
+	}
+
+	for _, index := range closures {
+		if !d.listed[index] {
+			d.compiledFunction(index)
+		}
+	}
+}
+
+func (d *disassembler) annotate(
+	op code.Opcode,
+	operands []int,
+	fn *object.CompiledFunction,
+	labels map[int]string,
+) string {
+	switch op {
+	case code.OpConstant, code.OpConstantWide, code.OpClosure, code.OpGetField:
+		if operands[0] >= len(d.constants) {
+			return "no such constant"
+		}
+		constant := d.constants[operands[0]]
+		if s, ok := constant.(*object.String); ok {
+			return fmt.Sprintf("%q", s.Value)
+		}
+		return constantValue(constant)
+
+	case code.OpJump, code.OpJumpNotTruthy:
+		if label, ok := labels[operands[0]]; ok {
+			return label
+		}
+		return "no instruction there"
+
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return d.globals[operands[0]]
+
+	case code.OpGetBuiltin, code.OpSetBuiltin:
+		return d.builtins[operands[0]]
+
+	case code.OpGetLocal, code.OpSetLocal:
+		if fn != nil && operands[0] < len(fn.LocalNames) {
+			return fn.LocalNames[operands[0]]
+		}
+
+	case code.OpGetFree:
+		if fn != nil && operands[0] < len(fn.FreeNames) {
+			return fn.FreeNames[operands[0]]
+		}
+	}
+
+	return ""
+}
+
+func (d *disassembler) isFunction(index int) bool {
+	if index >= len(d.constants) {
+		return false
+	}
+	_, ok := d.constants[index].(*object.CompiledFunction)
+
+	return ok
+}
+
+// jumpLabels names every offset a jump lands on L1, L2 and so on, in the
+// order of the code. A target that isn't an instruction gets no label.
+func jumpLabels(ins code.Instructions) map[int]string {
+	starts := map[int]bool{len(ins): true}
+	targets := []int{}
+
+	for offset := 0; offset < len(ins); {
+		starts[offset] = true
+
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			offset++
+			continue
+		}
+		if offset+def.Width() > len(ins) {
+			break
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		switch code.Opcode(ins[offset]) {
+		case code.OpJump, code.OpJumpNotTruthy:
+			targets = append(targets, operands[0])
+		}
+
+		offset += 1 + read
+	}
+
+	slices.Sort(targets)
+
+	labels := map[int]string{}
+	for _, target := range slices.Compact(targets) {
+		if starts[target] {
+			labels[target] = fmt.Sprintf("L%d", len(labels)+1)
+		}
+	}
+
+	return labels
+}
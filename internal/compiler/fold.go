@@ -0,0 +1,221 @@
+package compiler
+
+import (
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// FoldConstants replaces the operators whose operands are all literals with
+// the literal they evaluate to, like `1 + 2` with `3` and `!true` with
+// `false`. It rewrites node in place and returns it, or its replacement.
+//
+// Only what the VM would compute the same way every time is folded, so a
+// division by zero is left to fail at runtime.
+func FoldConstants(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.Program:
+		foldStatements(node.Statements)
+
+	case *ast.BlockStatement:
+		foldStatements(node.Statements)
+
+	case *ast.LetStatement:
+		node.Value = foldExpression(node.Value)
+
+	case *ast.ReturnStatement:
+		node.ReturnValue = foldExpression(node.ReturnValue)
+
+	case *ast.ExpressionStatement:
+		node.Expression = foldExpression(node.Expression)
+
+	case *ast.PrefixExpression:
+		node.Right = foldExpression(node.Right)
+
+		if folded := foldPrefix(node); folded != nil {
+			return folded
+		}
+
+	case *ast.InfixExpression:
+		node.Left = foldExpression(node.Left)
+		node.Right = foldExpression(node.Right)
+
+		if folded := foldInfix(node); folded != nil {
+			return folded
+		}
+
+	case *ast.IfExpression:
+		node.Condition = foldExpression(node.Condition)
+		FoldConstants(node.Consequence)
+		if node.Alternative != nil {
+			FoldConstants(node.Alternative)
+		}
+
+	case *ast.IndexExpression:
+		node.Left = foldExpression(node.Left)
+		node.Index = foldExpression(node.Index)
+
+	case *ast.SliceExpression:
+		node.Left = foldExpression(node.Left)
+		if node.Start != nil {
+			node.Start = foldExpression(node.Start)
+		}
+		if node.End != nil {
+			node.End = foldExpression(node.End)
+		}
+
+	case *ast.FieldExpression:
+		node.Left = foldExpression(node.Left)
+
+	case *ast.WithExpression:
+		node.Left = foldExpression(node.Left)
+		for i := range node.Fields {
+			node.Fields[i].Value = foldExpression(node.Fields[i].Value)
+		}
+
+	case *ast.ArrayLiteral:
+		for i, el := range node.Elements {
+			node.Elements[i] = foldExpression(el)
+		}
+
+	case *ast.HashLiteral:
+		for i, pair := range node.Pairs {
+			node.Pairs[i].Key = foldExpression(pair.Key)
+			node.Pairs[i].Value = foldExpression(pair.Value)
+		}
+
+	case *ast.FunctionLiteral:
+		FoldConstants(node.Body)
+
+	case *ast.CallExpression:
+		node.Function = foldExpression(node.Function)
+		for i, arg := range node.Arguments {
+			node.Arguments[i] = foldExpression(arg)
+		}
+	}
+
+	return node
+}
+
+func foldStatements(statements []ast.Statement) {
+	for _, s := range statements {
+		FoldConstants(s)
+	}
+}
+
+func foldExpression(exp ast.Expression) ast.Expression {
+	return FoldConstants(exp).(ast.Expression)
+}
+
+func foldPrefix(node *ast.PrefixExpression) ast.Expression {
+	right, ok := literalValue(node.Right)
+	if !ok {
+		return nil
+	}
+
+	switch node.Operator {
+	case "!":
+		// Everything but false and null is truthy, and there is no null
+		// literal.
+		return booleanLiteral(right == object.FALSE, node.Span)
+	case "-":
+		if object.IsInteger(right) {
+			return integerLiteral(object.NegateInteger(right), node.Span)
+		}
+	}
+
+	return nil
+}
+
+func foldInfix(node *ast.InfixExpression) ast.Expression {
+	left, ok := literalValue(node.Left)
+	if !ok {
+		return nil
+	}
+	right, ok := literalValue(node.Right)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case object.IsInteger(left) && object.IsInteger(right):
+		switch node.Operator {
+		case "+", "-", "*", "/":
+			result, err := object.IntegerArithmetic(node.Operator, left, right)
+			if err != nil {
+				return nil
+			}
+			return integerLiteral(result, node.Span)
+		case "<":
+			return booleanLiteral(object.CompareIntegers(left, right) < 0, node.Span)
+		case ">":
+			return booleanLiteral(object.CompareIntegers(left, right) > 0, node.Span)
+		case "==":
+			return booleanLiteral(object.CompareIntegers(left, right) == 0, node.Span)
+		case "!=":
+			return booleanLiteral(object.CompareIntegers(left, right) != 0, node.Span)
+		}
+
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		if node.Operator == "+" {
+			value := left.(*object.String).Value + right.(*object.String).Value
+			return &ast.StringLiteral{
+				Span:  node.Span,
+				Token: token.Token{Type: token.STRING, Literal: value},
+				Value: value,
+			}
+		}
+
+	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
+		switch node.Operator {
+		case "==":
+			return booleanLiteral(left == right, node.Span)
+		case "!=":
+			return booleanLiteral(left != right, node.Span)
+		}
+	}
+
+	return nil
+}
+
+// literalValue is the value of a literal the folder knows how to combine.
+func literalValue(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			return &object.BigInteger{Value: exp.Big}, true
+		}
+		return &object.Integer{Value: exp.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}, true
+	case *ast.Boolean:
+		return object.NativeBool(exp.Value), true
+	}
+
+	return nil, false
+}
+
+func integerLiteral(value object.Object, span ast.Span) *ast.IntegerLiteral {
+	literal := &ast.IntegerLiteral{
+		Span:  span,
+		Token: token.Token{Type: token.INT, Literal: value.Inspect()},
+	}
+
+	switch value := value.(type) {
+	case *object.Integer:
+		literal.Value = value.Value
+	case *object.BigInteger:
+		literal.Big = value.Value
+	}
+
+	return literal
+}
+
+func booleanLiteral(value bool, span ast.Span) *ast.Boolean {
+	tok := token.Token{Type: token.FALSE, Literal: "false"}
+	if value {
+		tok = token.Token{Type: token.TRUE, Literal: "true"}
+	}
+
+	return &ast.Boolean{Span: span, Token: tok, Value: value}
+}
@@ -0,0 +1,358 @@
+package compiler
+
+import (
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+)
+
+// Passes switches optimization passes on. The zero value leaves the code
+// as the compiler emits it.
+type Passes struct {
+	// ConstantFolding runs FoldConstants on the AST before compiling it.
+	ConstantFolding bool `json:"constantFolding"`
+	// Peephole rewrites short runs of instructions into shorter ones.
+	Peephole bool `json:"peephole"`
+	// JumpThreading points jumps that land on another jump at its target.
+	JumpThreading bool `json:"jumpThreading"`
+}
+
+func AllPasses() Passes {
+	return Passes{ConstantFolding: true, Peephole: true, JumpThreading: true}
+}
+
+// Stage is the bytecode as it stood after a pass. The first stage, "none",
+// is the code before any pass.
+type Stage struct {
+	Pass     string    `json:"pass"`
+	Bytecode *Bytecode `json:"bytecode"`
+}
+
+// Optimize compiles program and runs the passes that are switched on, in
+// the order they appear in Passes. It returns the bytecode after every
+// pass, ending with the optimized one. Constant folding rewrites program.
+func Optimize(program *ast.Program, stdlib *object.Stdlib, passes Passes) ([]Stage, error) {
+	comp := NewWithStdlib(stdlib)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+
+	stages := []Stage{{Pass: "none", Bytecode: comp.Bytecode()}}
+
+	if passes.ConstantFolding {
+		comp := NewWithStdlib(stdlib)
+		if err := comp.Compile(FoldConstants(program)); err != nil {
+			return nil, err
+		}
+
+		stages = append(stages, Stage{Pass: "constantFolding", Bytecode: comp.Bytecode()})
+	}
+
+	last := stages[len(stages)-1].Bytecode
+	if passes.Peephole {
+		last = optimizeBytecode(last, peephole)
+		stages = append(stages, Stage{Pass: "peephole", Bytecode: last})
+	}
+	if passes.JumpThreading {
+		last = optimizeBytecode(last, threadJumps)
+		stages = append(stages, Stage{Pass: "jumpThreading", Bytecode: last})
+	}
+
+	return stages, nil
+}
+
+// instruction is a decoded instruction. Jumps point at the index of their
+// target rather than its offset, so instructions can be added and removed
+// before encoding them again.
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	// The index of the instruction a jump goes to. One past the last
+	// instruction stands for the end of the code.
+	target int
+	// Where the instruction was compiled from, nil when unknown.
+	source *code.SourceMapping
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy
+}
+
+// optimizeBytecode runs pass over the main program and every compiled
+// function. The functions are copied, leaving bytecode as it was.
+func optimizeBytecode(bytecode *Bytecode, pass func([]instruction) []instruction) *Bytecode {
+	instructions, sourceMap := optimizeInstructions(bytecode.Instructions, bytecode.SourceMap, pass)
+
+	constants := make([]object.Object, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			constants[i] = constant
+			continue
+		}
+
+		optimized := *fn
+		optimized.Instructions, optimized.SourceMap = optimizeInstructions(fn.Instructions, fn.SourceMap, pass)
+		constants[i] = &optimized
+	}
+
+	return &Bytecode{
+		Instructions: instructions,
+		Constants:    constants,
+		SourceMap:    sourceMap,
+		Symbols:      bytecode.Symbols,
+	}
+}
+
+// optimizeInstructions leaves code it can't decode alone.
+func optimizeInstructions(
+	ins code.Instructions,
+	sourceMap code.SourceMap,
+	pass func([]instruction) []instruction,
+) (code.Instructions, code.SourceMap) {
+	list, ok := decode(ins, sourceMap)
+	if !ok {
+		return ins, sourceMap
+	}
+
+	return encode(pass(list))
+}
+
+func decode(ins code.Instructions, sourceMap code.SourceMap) ([]instruction, bool) {
+	sources := map[int]code.SourceMapping{}
+	for _, mapping := range sourceMap {
+		sources[mapping.Offset] = mapping
+	}
+
+	list := []instruction{}
+	// The index of the instruction at every offset, plus the end.
+	indexes := map[int]int{}
+
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return nil, false
+		}
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+
+		in := instruction{op: code.Opcode(ins[offset]), operands: operands}
+		if mapping, ok := sources[offset]; ok {
+			in.source = &mapping
+		}
+
+		indexes[offset] = len(list)
+		list = append(list, in)
+
+		offset += 1 + read
+	}
+	indexes[len(ins)] = len(list)
+
+	for i := range list {
+		if isJump(list[i].op) {
+			target, ok := indexes[list[i].operands[0]]
+			if !ok {
+				return nil, false
+			}
+			list[i].target = target
+		}
+	}
+
+	return list, true
+}
+
+func encode(list []instruction) (code.Instructions, code.SourceMap) {
+	offsets := make([]int, len(list)+1)
+	for i, in := range list {
+		offsets[i+1] = offsets[i] + len(code.Make(in.op, in.operands...))
+	}
+
+	ins := code.Instructions{}
+	var sourceMap code.SourceMap
+
+	for i, in := range list {
+		if isJump(in.op) {
+			in.operands = []int{offsets[in.target]}
+		}
+		if in.source != nil {
+			mapping := *in.source
+			mapping.Offset = offsets[i]
+			sourceMap = append(sourceMap, mapping)
+		}
+
+		ins = append(ins, code.Make(in.op, in.operands...)...)
+	}
+
+	return ins, sourceMap
+}
+
+// A peephole rule looks at the code from index i on. When it applies, it
+// returns how many instructions it replaces and what with.
+type peepholeRule func(list []instruction, i int, targets map[int]bool) ([]instruction, int, bool)
+
+var peepholeRules = []peepholeRule{
+	foldBang,
+	foldConstantJump,
+	removeJumpToNext,
+	removeUnreachable,
+}
+
+// peephole applies the rules until none of them applies anymore.
+func peephole(list []instruction) []instruction {
+	for {
+		changed := false
+		for _, rule := range peepholeRules {
+			var ok bool
+			list, ok = rewrite(list, rule)
+			changed = changed || ok
+		}
+
+		if !changed {
+			return list
+		}
+	}
+}
+
+// rewrite applies rule at every index it can, and retargets the jumps to
+// what is left. A rule may not swallow an instruction some jump lands on,
+// other than the first one it replaces.
+func rewrite(list []instruction, rule peepholeRule) ([]instruction, bool) {
+	targets := jumpTargets(list)
+
+	out := []instruction{}
+	// Where each old instruction, and the end, went in out.
+	indexes := make([]int, len(list)+1)
+	changed := false
+
+	for i := 0; i < len(list); {
+		replacement, n, ok := rule(list, i, targets)
+		if ok {
+			for j := i + 1; j < i+n; j++ {
+				ok = ok && !targets[j]
+			}
+		}
+		if !ok {
+			replacement, n = list[i:i+1], 1
+		} else {
+			changed = true
+		}
+
+		for j := i; j < i+n; j++ {
+			indexes[j] = len(out)
+		}
+		out = append(out, replacement...)
+		i += n
+	}
+	indexes[len(list)] = len(out)
+
+	for i := range out {
+		if isJump(out[i].op) {
+			out[i].target = indexes[out[i].target]
+		}
+	}
+
+	return out, changed
+}
+
+func jumpTargets(list []instruction) map[int]bool {
+	targets := map[int]bool{}
+	for _, in := range list {
+		if isJump(in.op) {
+			targets[in.target] = true
+		}
+	}
+
+	return targets
+}
+
+// foldBang turns `OpTrue OpBang` into `OpFalse`, and `OpFalse OpBang` or
+// `OpNull OpBang` into `OpTrue`.
+func foldBang(list []instruction, i int, targets map[int]bool) ([]instruction, int, bool) {
+	if i+1 >= len(list) || list[i+1].op != code.OpBang {
+		return nil, 0, false
+	}
+
+	in := list[i]
+	switch in.op {
+	case code.OpTrue:
+		in.op = code.OpFalse
+	case code.OpFalse, code.OpNull:
+		in.op = code.OpTrue
+	default:
+		return nil, 0, false
+	}
+
+	return []instruction{in}, 2, true
+}
+
+// foldConstantJump drops an `OpJumpNotTruthy` that always falls through,
+// with the `OpTrue` before it, and turns one that always jumps into an
+// `OpJump`.
+func foldConstantJump(list []instruction, i int, targets map[int]bool) ([]instruction, int, bool) {
+	if i+1 >= len(list) || list[i+1].op != code.OpJumpNotTruthy {
+		return nil, 0, false
+	}
+
+	switch list[i].op {
+	case code.OpTrue:
+		return []instruction{}, 2, true
+	case code.OpFalse, code.OpNull:
+		jump := list[i+1]
+		jump.op = code.OpJump
+		jump.source = list[i].source
+		return []instruction{jump}, 2, true
+	}
+
+	return nil, 0, false
+}
+
+// removeJumpToNext drops an `OpJump` to the instruction right after it.
+func removeJumpToNext(list []instruction, i int, targets map[int]bool) ([]instruction, int, bool) {
+	if list[i].op != code.OpJump || list[i].target != i+1 {
+		return nil, 0, false
+	}
+
+	return []instruction{}, 1, true
+}
+
+// removeUnreachable drops what follows an `OpJump` or a return up to the
+// next instruction a jump lands on.
+func removeUnreachable(list []instruction, i int, targets map[int]bool) ([]instruction, int, bool) {
+	switch list[i].op {
+	case code.OpJump, code.OpReturnValue, code.OpReturn:
+	default:
+		return nil, 0, false
+	}
+
+	n := 1
+	for i+n < len(list) && !targets[i+n] {
+		n++
+	}
+	if n == 1 {
+		return nil, 0, false
+	}
+
+	return list[i : i+1], n, true
+}
+
+// threadJumps points every jump that lands on an `OpJump` at where that
+// one goes, following chains of them.
+func threadJumps(list []instruction) []instruction {
+	out := make([]instruction, len(list))
+	copy(out, list)
+
+	for i := range out {
+		if !isJump(out[i].op) {
+			continue
+		}
+
+		seen := map[int]bool{}
+		target := out[i].target
+		for target < len(list) && list[target].op == code.OpJump && !seen[target] {
+			seen[target] = true
+			target = list[target].target
+		}
+		out[i].target = target
+	}
+
+	return out
+}
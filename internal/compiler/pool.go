@@ -0,0 +1,87 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+)
+
+// MainFunction stands for the main program where an instruction is placed
+// by the constant pool index of its compiled function.
+const MainFunction = -1
+
+// Constant describes an entry of the constant pool.
+type Constant struct {
+	Index int               `json:"index"`
+	Type  object.ObjectType `json:"type"`
+	Value string            `json:"value"`
+	// References lists the instructions that load the constant, in the
+	// main program first and then by function.
+	References []Reference `json:"references"`
+}
+
+// Reference is an instruction using a constant. Function is the constant
+// pool index of the compiled function it is in, or MainFunction.
+type Reference struct {
+	Function int `json:"function"`
+	Offset   int `json:"offset"`
+}
+
+// ConstantPool describes every constant and the instructions that use it.
+func (b *Bytecode) ConstantPool() []Constant {
+	pool := make([]Constant, len(b.Constants))
+	for i, constant := range b.Constants {
+		pool[i] = Constant{
+			Index:      i,
+			Type:       constant.Type(),
+			Value:      constantValue(constant),
+			References: []Reference{},
+		}
+	}
+
+	addReferences := func(function int, ins code.Instructions) {
+		for offset := 0; offset < len(ins); {
+			def, err := code.Lookup(ins[offset])
+			if err != nil {
+				return
+			}
+			operands, read := code.ReadOperands(def, ins[offset+1:])
+
+			switch code.Opcode(ins[offset]) {
+			case code.OpConstant, code.OpConstantWide, code.OpClosure, code.OpGetField:
+				if i := operands[0]; i < len(pool) {
+					pool[i].References = append(pool[i].References,
+						Reference{Function: function, Offset: offset})
+				}
+			}
+
+			offset += 1 + read
+		}
+	}
+
+	addReferences(MainFunction, b.Instructions)
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			addReferences(i, fn.Instructions)
+		}
+	}
+
+	return pool
+}
+
+// constantValue is how a constant reads in the pool. Functions go by name,
+// since they have no literal form.
+func constantValue(obj object.Object) string {
+	fn, ok := obj.(*object.CompiledFunction)
+	if !ok {
+		return obj.Inspect()
+	}
+
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	return fmt.Sprintf("fn %s(%d parameters)", name, fn.NumParameters)
+}
@@ -91,3 +91,21 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 
 	return symbol, ok
 }
+
+// names lists the names of the symbols of scope defined in the table by
+// index, up to n of them. A name defined again leaves its first index
+// unnamed.
+func (s *SymbolTable) names(scope SymbolScope, n int) []string {
+	if n == 0 {
+		return nil
+	}
+
+	names := make([]string, n)
+	for _, symbol := range s.store {
+		if symbol.Scope == scope && symbol.Index < n {
+			names[symbol.Index] = symbol.Name
+		}
+	}
+
+	return names
+}
@@ -1,43 +1,195 @@
 package evaluator
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
+// Evaluator walks the AST. A tracer can be attached to observe every node it
+// visits.
+type Evaluator struct {
+	tracer Tracer
+	stdlib *object.Stdlib
+	// The locale error messages are worded in, English when nil.
+	locale *token.Locale
+	// Handed to builtins when they are called.
+	runtime *object.Runtime
+
+	// Set while running under EvalContext.
+	meter *object.Meter
+	// The reason evaluation was aborted, if it was.
+	err error
+}
+
+func New() *Evaluator {
+	return NewWithStdlib(object.DefaultStdlib())
+}
+
+// NewWithStdlib creates an evaluator that resolves builtins against stdlib.
+func NewWithStdlib(stdlib *object.Stdlib) *Evaluator {
+	e := &Evaluator{stdlib: stdlib}
+	e.runtime = &object.Runtime{IO: object.StdIO(), Caller: e}
+
+	return e
+}
+
+func NewWithTracer(tracer Tracer) *Evaluator {
+	e := New()
+	e.tracer = tracer
+
+	return e
+}
+
+// SetTracer attaches a tracer that is told about every step from now on.
+func (e *Evaluator) SetTracer(tracer Tracer) {
+	e.tracer = tracer
+}
+
+// SetIO redirects the input and output of builtins such as `puts`.
+func (e *Evaluator) SetIO(io object.IO) {
+	e.runtime.IO = io
+}
+
+// SetLocale words the error messages of the evaluator and its builtins in
+// locale.
+func (e *Evaluator) SetLocale(locale *token.Locale) {
+	e.locale = locale
+}
+
+// Eval evaluates node with an untraced evaluator.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+// EvalContext evaluates node with an untraced evaluator, within budget and
+// until ctx is done.
+func EvalContext(
+	ctx context.Context,
+	node ast.Node,
+	env *object.Environment,
+	budget object.Budget,
+) (object.Object, error) {
+	return New().EvalContext(ctx, node, env, budget)
+}
+
+// EvalContext evaluates node like Eval, but gives up with an error matching
+// object.ErrBudgetExceeded once the budget runs out, or with the context's
+// error once it is done.
+func (e *Evaluator) EvalContext(
+	ctx context.Context,
+	node ast.Node,
+	env *object.Environment,
+	budget object.Budget,
+) (object.Object, error) {
+	e.meter = object.NewMeter(ctx, budget)
+	e.err = nil
+	defer func() { e.meter = nil }()
+
+	result := e.Eval(node, env)
+	if e.err != nil {
+		return nil, object.Localized(e.err, e.locale)
+	}
+
+	return result, nil
+}
+
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	if e.meter != nil {
+		if e.err != nil {
+			return newError("%s", e.err)
+		}
+		if err := e.meter.Step(); err != nil {
+			return e.abort(err)
+		}
+	}
+
+	// Error objects are worded in the locale as they first come out, and
+	// unwind the rest of the way as they are.
+	if e.tracer == nil {
+		return object.LocalizeObject(e.charge(node, e.eval(node, env)), e.locale)
+	}
+
+	e.tracer.Enter(node, env)
+	result := object.LocalizeObject(e.charge(node, e.eval(node, env)), e.locale)
+	e.tracer.Exit(node, result)
+
+	return result
+}
+
+// abort stops the evaluation. The returned error object unwinds the tree
+// like any Monkey error, and every Eval after it fails straight away.
+func (e *Evaluator) abort(err error) object.Object {
+	e.err = err
+	return newError("%s", err)
+}
+
+// charge bills the budget for the objects created by evaluating node.
+func (e *Evaluator) charge(node ast.Node, result object.Object) object.Object {
+	switch node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.FunctionLiteral,
+		*ast.ArrayLiteral, *ast.HashLiteral, *ast.SliceExpression, *ast.WithExpression,
+		*ast.PrefixExpression, *ast.InfixExpression:
+		return e.alloc(result)
+	}
+
+	return result
+}
+
+func (e *Evaluator) alloc(obj object.Object) object.Object {
+	if e.meter == nil || e.err != nil {
+		return obj
+	}
+
+	if err := e.meter.Alloc(obj); err != nil {
+		return e.abort(err)
+	}
+
+	return obj
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.Eval(node.Expression, env)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return e.evalIdentifier(node, env)
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := e.Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.StructStatement:
+		def := &object.StructType{Name: node.Name.Value}
+		for _, field := range node.Fields {
+			def.Fields = append(def.Fields, field.Value)
+		}
+		env.Set(node.Name.Value, def)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := e.Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	// Literals
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInteger{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
@@ -52,97 +204,143 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			Env:        env,
 		}
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := e.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{
-			Elements: elements,
-		}
+		return object.NewArray(elements)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.evalHashLiteral(node, env)
 	// Expressions
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
 
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := e.Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
+		args := e.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return e.applyFunction(function, args)
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := e.Eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+
+	case *ast.SliceExpression:
+		left := e.Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		start := e.evalSliceBound(node.Start, env)
+		if isError(start) {
+			return start
+		}
+		end := e.evalSliceBound(node.End, env)
+		if isError(end) {
+			return end
+		}
+		return evalSliceExpression(left, start, end)
+
+	case *ast.FieldExpression:
+		left := e.Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		value, err := object.GetField(left, node.Field.Value)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return value
+
+	case *ast.WithExpression:
+		return e.evalWithExpression(node, env)
 	}
 
 	return nil
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := map[object.HashKey]object.HashPair{}
+func (e *Evaluator) evalWithExpression(node *ast.WithExpression, env *object.Environment) object.Object {
+	left := e.Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	names := make([]string, len(node.Fields))
+	values := make([]object.Object, len(node.Fields))
+	for i, field := range node.Fields {
+		value := e.Eval(field.Value, env)
+		if isError(value) {
+			return value
+		}
+		names[i], values[i] = field.Name.Value, value
+	}
+
+	result, err := object.With(left, names, values)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	return result
+}
+
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, pair := range node.Pairs {
+		key := e.Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
+		if !object.IsHashable(key) {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
-		if isError(key) {
-			return key
+		value := e.Eval(pair.Value, env)
+		if isError(value) {
+			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{
-			Key:   key,
-			Value: value,
-		}
+		hash.Set(key, value)
 	}
 
-	return &object.Hash{
-		Pairs: pairs,
-	}
+	return hash
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return object.IndexString(left.(*object.String), index.(*object.Integer).Value)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -150,44 +348,93 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// evalSliceBound evaluates a bound of a slice, which is NULL when left out.
+func (e *Evaluator) evalSliceBound(bound ast.Expression, env *object.Environment) object.Object {
+	if bound == nil {
+		return NULL
+	}
+
+	return e.Eval(bound, env)
+}
+
+func evalSliceExpression(left, start, end object.Object) object.Object {
+	result, err := object.Slice(left, start, end)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	return result
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
+	if !object.IsHashable(index) {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(index)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
 
-	if idx < 0 || idx > max {
+	idx, ok := object.ResolveIndex(index.(*object.Integer).Value, arrayObject.Len())
+	if !ok {
 		return NULL
 	}
 
-	return arrayObject.Elements[idx]
+	return arrayObject.At(idx)
+}
+
+// Call applies fn on behalf of a builtin.
+func (e *Evaluator) Call(fn object.Object, args ...object.Object) object.Object {
+	return e.applyFunction(fn, args)
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch function := fn.(type) {
 
 	case *object.Function:
+		if len(args) != len(function.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d",
+				len(function.Parameters), len(args))
+		}
+
+		if e.meter != nil {
+			if err := e.meter.Call(); err != nil {
+				return e.abort(err)
+			}
+			defer e.meter.Return()
+		}
+
 		extendedEnv := extendFunctionEnv(function, args)
-		evaluated := Eval(function.Body, extendedEnv)
+		if e.tracer != nil {
+			e.tracer.Call(function, args, extendedEnv)
+		}
+		evaluated := e.Eval(function.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return function.Fn(args...)
+		result := function.Call(e.runtime, args...)
+		if result == nil {
+			return NULL
+		}
+
+		return e.alloc(result)
+
+	case *object.StructType:
+		instance, err := function.New(args)
+		if err != nil {
+			return newError("%s", err)
+		}
+
+		return e.alloc(instance)
 
 	default:
 		return newError("not a function: %s", fn.Type())
@@ -212,11 +459,11 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
-	for _, e := range exps {
-		evaluated := Eval(e, env)
+	for _, exp := range exps {
+		evaluated := e.Eval(exp, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -226,23 +473,23 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+func (e *Evaluator) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := e.stdlib.Lookup(node.Value); ok {
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError("identifier not found: %s", node.Value)
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range program.Statements {
-		result = Eval(stmt, env)
+		result = e.Eval(stmt, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -255,11 +502,11 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env)
+		result = e.Eval(stmt, env)
 
 		if result != nil {
 			rt := result.Type()
@@ -272,17 +519,17 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
-func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(node.Condition, env)
+func (e *Evaluator) evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
+	condition := e.Eval(node.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(node.Consequence, env)
+		return e.Eval(node.Consequence, env)
 	}
 	if node.Alternative.Statements != nil {
-		return Eval(node.Alternative, env)
+		return e.Eval(node.Alternative, env)
 	}
 
 	return NULL
@@ -303,7 +550,7 @@ func isTruthy(obj object.Object) bool {
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
@@ -335,29 +582,26 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	}
 }
 
+// Integers may be Integers or BigIntegers, which the object package mixes
+// freely.
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-
 	switch operator {
 	// Additive & Multiplicative
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "+", "-", "*", "/":
+		result, err := object.IntegerArithmetic(operator, left, right)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return result
 	// Relational
 	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
 	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
 	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) == 0)
 	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) != 0)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -388,14 +632,11 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	if !object.IsInteger(right) {
 		return newError("unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{
-		Value: -value,
-	}
+	return object.NegateInteger(right)
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -406,9 +647,7 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 }
 
 func newError(format string, a ...any) *object.Error {
-	return &object.Error{
-		Message: fmt.Sprintf(format, a...),
-	}
+	return object.NewError(format, a...)
 }
 
 func isError(obj object.Object) bool {
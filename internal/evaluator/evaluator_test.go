@@ -1,11 +1,18 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/lexer"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/parser"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -137,13 +144,13 @@ func TestArrayLiteral(t *testing.T) {
 		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	if len(result.Elements) != 3 {
-		t.Fatalf("array has wrong number of elemnents. got=%d", len(result.Elements))
+	if result.Len() != 3 {
+		t.Fatalf("array has wrong number of elemnents. got=%d", result.Len())
 	}
 
-	testIntegerObject(t, result.Elements[0], 1)
-	testIntegerObject(t, result.Elements[1], 4)
-	testIntegerObject(t, result.Elements[2], 6)
+	testIntegerObject(t, result.At(0), 1)
+	testIntegerObject(t, result.At(1), 4)
+	testIntegerObject(t, result.At(2), 6)
 }
 
 func TestArrayIndexExpresssions(t *testing.T) {
@@ -189,6 +196,14 @@ func TestArrayIndexExpresssions(t *testing.T) {
 		},
 		{
 			"[1, 2, 3][-1]",
+			3,
+		},
+		{
+			"[1, 2, 3][-3]",
+			1,
+		},
+		{
+			"[1, 2, 3][-4]",
 			nil,
 		},
 	}
@@ -222,26 +237,27 @@ func TestHashLiteral(t *testing.T) {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := map[object.Object]int64{
+		&object.String{Value: "one"}:   1,
+		&object.String{Value: "two"}:   2,
+		&object.String{Value: "three"}: 3,
+		&object.Integer{Value: 4}:      4,
+		TRUE:                           5,
+		FALSE:                          6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		value, ok := result.Get(expectedKey)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
+			continue
 		}
 
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, value, expectedValue)
 	}
 }
 
@@ -472,6 +488,247 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestOperatorDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"infixl 45 <+> = fn(a, b) { a * 10 + b }; 1 <+> 2 <+> 3", 123},
+		{"infixr 45 <+> = fn(a, b) { a * 10 + b }; 1 <+> 2 <+> 3", 33},
+		{"infixl 45 <+> = fn(a, b) { a * 10 + b }; 1 + 2 <+> 3 * 2", 27},
+		{"infixl 45 %% = fn(a, b) { if (a < b) { a } else { (a - b) %% b } }; 17 %% 5", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	input := `let make = fn(x) { fn(y) { x + y } };
+make(1)(2);`
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	trace := NewTrace(DefaultTraceLimit)
+
+	evaluated := NewWithTracer(trace).Eval(program, object.NewEnvironment())
+	testIntegerObject(t, evaluated, 3)
+
+	events := trace.Events
+	if first := events[0]; first.Kind != TraceEnter || first.Node != "Program" || first.Env != 1 {
+		t.Fatalf("first event wrong. got=%+v", first)
+	}
+	if last := events[len(events)-1]; last.Kind != TraceExit || last.Node != "Program" ||
+		last.Value != "3" || last.Depth != 0 {
+		t.Fatalf("last event wrong. got=%+v", last)
+	}
+
+	var calls []TraceEvent
+	var closure TraceEvent
+	depth := 0
+	for i, event := range events {
+		if event.Step != i {
+			t.Fatalf("events[%d] has step %d", i, event.Step)
+		}
+
+		switch event.Kind {
+		case TraceEnter:
+			depth++
+		case TraceExit:
+			depth--
+			if event.Node == "FunctionLiteral" && event.Source == "fn(y)(x + y)" {
+				closure = event
+			}
+		case TraceCall:
+			calls = append(calls, event)
+		}
+	}
+	if depth != 0 {
+		t.Fatalf("enter and exit events don't balance, depth=%d", depth)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("wrong number of calls. want=2, got=%d", len(calls))
+	}
+
+	inner := calls[1]
+	if len(inner.Args) != 1 || inner.Args[0] != "2" {
+		t.Fatalf("inner call has wrong args. got=%v", inner.Args)
+	}
+	if len(inner.Chain) != 3 {
+		t.Fatalf("inner call has wrong env chain. got=%+v", inner.Chain)
+	}
+	if inner.Chain[0].Bindings["y"] != "2" || inner.Chain[1].Bindings["x"] != "1" ||
+		inner.Chain[2].Bindings["make"] == "" {
+		t.Fatalf("inner call has wrong bindings. got=%+v", inner.Chain)
+	}
+	if closure.Captures != calls[0].Env || inner.Chain[1].ID != calls[0].Env {
+		t.Fatalf("closure should capture env %d, got=%d", calls[0].Env, closure.Captures)
+	}
+	if span := inner.Span; input[span.Start.Offset:span.End.Offset] != "{ x + y }" {
+		t.Fatalf("inner call has wrong span. got=%+v", span)
+	}
+}
+
+func TestTraceLimit(t *testing.T) {
+	l := lexer.New("let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(100);")
+	p := parser.New(l)
+	program := p.ParseProgram()
+	trace := NewTrace(50)
+
+	evaluated := NewWithTracer(trace).Eval(program, object.NewEnvironment())
+	testIntegerObject(t, evaluated, 0)
+
+	if len(trace.Events) != 50 || !trace.Truncated {
+		t.Fatalf("trace should be truncated at 50 events. got=%d, truncated=%t",
+			len(trace.Events), trace.Truncated)
+	}
+
+	// Nothing is looked at past the limit, like the environments of the
+	// calls the trace has no room for.
+	seen := map[int]bool{}
+	for _, event := range trace.Events {
+		seen[event.Env] = true
+		for _, env := range event.Chain {
+			seen[env.ID] = true
+		}
+	}
+	if len(trace.envIDs) != len(seen)-1 {
+		t.Errorf("trace numbered %d environments, its events show %d",
+			len(trace.envIDs), len(seen)-1)
+	}
+}
+
+func TestTraceBytesLimit(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&input, "let g%c%c = %d; ", 'a'+i/26, 'a'+i%26, i)
+	}
+	input.WriteString("let a = range(0, 1000); map(range(0, 6000), fn(x) { a }); 1")
+
+	program := parser.New(lexer.New(input.String())).ParseProgram()
+	trace := NewTrace(1_000_000)
+
+	evaluated := NewWithTracer(trace).Eval(program, object.NewEnvironment())
+	testIntegerObject(t, evaluated, 1)
+
+	if !trace.Truncated {
+		t.Errorf("trace not marked truncated")
+	}
+
+	total := 0
+	keep := func(s string) {
+		if len(s) > MaxTraceValue {
+			t.Fatalf("string of %d bytes kept: %.20s...", len(s), s)
+		}
+		total += len(s)
+	}
+	calls := 0
+	for _, event := range trace.Events {
+		keep(event.Source)
+		keep(event.Value)
+		for _, arg := range event.Args {
+			keep(arg)
+		}
+		for _, env := range event.Chain {
+			if len(env.Bindings) > MaxTraceBindings {
+				t.Fatalf("env %d shows %d bindings", env.ID, len(env.Bindings))
+			}
+			for _, value := range env.Bindings {
+				keep(value)
+			}
+		}
+		if event.Kind == TraceCall {
+			calls++
+			if global := event.Chain[len(event.Chain)-1]; global.Omitted != 31-MaxTraceBindings {
+				t.Fatalf("wrong number of omitted globals. got=%d", global.Omitted)
+			}
+		}
+	}
+	if calls == 0 {
+		t.Fatalf("no calls traced")
+	}
+	// One event may go over before the trace notices.
+	if total > MaxTraceBytes+MaxTraceValue*(3+MaxTraceBindings*2) {
+		t.Errorf("trace kept %d bytes", total)
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	loop := "let loop = fn(n) { loop(n + 1) }; loop(0);"
+	grow := `let grow = fn(xs) { grow(push(xs, len(xs))) }; grow([]);`
+	double := `let double = fn(s) { double(s + s) }; double("ab");`
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		budget   object.Budget
+		expected error
+		limit    string
+	}{
+		{"1 + 2", context.Background(), object.Budget{Steps: 100, Depth: 10, Allocs: 10}, nil, ""},
+		{loop, context.Background(), object.Budget{Depth: 100}, object.ErrBudgetExceeded, "depth"},
+		{loop, context.Background(), object.Budget{Steps: 1_000}, object.ErrBudgetExceeded, "steps"},
+		{grow, context.Background(), object.Budget{Depth: 900, Allocs: 1_500}, object.ErrBudgetExceeded, "allocs"},
+		{double, context.Background(), object.Budget{Depth: 900, Allocs: 5_000}, object.ErrBudgetExceeded, "allocs"},
+		{loop, cancelled, object.Budget{Depth: 900}, context.Canceled, ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		_, err := EvalContext(tt.ctx, program, object.NewEnvironment(), tt.budget)
+		if !errors.Is(err, tt.expected) {
+			t.Fatalf("%q: wrong error. want=%v, got=%v", tt.input, tt.expected, err)
+		}
+
+		var budgetErr *object.BudgetError
+		if errors.As(err, &budgetErr) && budgetErr.Limit != tt.limit {
+			t.Fatalf("%q: wrong limit exceeded. want=%s, got=%s", tt.input, tt.limit, budgetErr.Limit)
+		}
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + verdadero", "tipos incompatibles: INTEGER + BOOLEAN"},
+		{"x", "identificador no encontrado: x"},
+		{"len(1)", "argumento de `len` no soportado, se obtuvo=INTEGER"},
+		{`map([1], funcion(x) { x + "a" })`, "tipos incompatibles: INTEGER + STRING"},
+		{"1 / 0", "división por cero"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.NewWithLocale(tt.input, token.Spanish)).ParseProgram()
+
+		e := New()
+		e.SetLocale(token.Spanish)
+		errObj, ok := e.Eval(program, object.NewEnvironment()).(*object.Error)
+		if !ok || errObj.Message != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, errObj)
+		}
+	}
+
+	program := parser.New(lexer.New("let loop = fn(n) { loop(n + 1) }; loop(0);")).ParseProgram()
+	e := New()
+	e.SetLocale(token.Spanish)
+	_, err := e.EvalContext(context.Background(), program, object.NewEnvironment(), object.Budget{Steps: 100})
+
+	want := "presupuesto de ejecución agotado: se alcanzó el límite de 100 pasos"
+	if !errors.Is(err, object.ErrBudgetExceeded) || err.Error() != want {
+		t.Errorf("wrong budget error. want=%q, got=%v", want, err)
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -533,6 +790,312 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestBuiltinIO(t *testing.T) {
+	input := `let name = gets(); puts("hello", name); puts([1, 2]); gets(); gets()`
+
+	var stdout bytes.Buffer
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	e := New()
+	e.SetIO(object.IO{Stdout: &stdout, Stdin: strings.NewReader("monkey\nlast")})
+
+	evaluated := e.Eval(program, object.NewEnvironment())
+	testNullObject(t, evaluated)
+
+	expected := "hello\nmonkey\n[1, 2]\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, stdout.String())
+	}
+}
+
+func TestCustomStdlib(t *testing.T) {
+	stdlib := object.DefaultStdlib().Without("puts")
+	stdlib.Register(&object.Builtin{
+		Name:   "double",
+		Params: []object.Param{{Name: "n", Types: []object.ObjectType{object.INTEGER_OBJ}}},
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		},
+	})
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`double(21)`, 42},
+		{`double("a")`, "argument to `double` must be INTEGER, got=STRING"},
+		{`puts("hi")`, "identifier not found: puts"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		evaluated := NewWithStdlib(stdlib).Eval(program, object.NewEnvironment())
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []inspectTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`map(["a", "bb"], len)`, "[1, 2]"},
+		{`map([[1, 2], [3]], fn(xs) { reduce(xs, 0, fn(a, b) { a + b }) })`, "[3, 3]"},
+		{`len(map(range(0, 5000), fn(x) { x }))`, "5000"},
+		{`filter(range(0, 10), fn(x) { x > 6 })`, "[7, 8, 9]"},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, "10"},
+		{`reduce([], "empty", fn(acc, x) { x })`, "empty"},
+		{`sort_by([3, 1, 2], fn(x) { -x })`, "[3, 2, 1]"},
+		{`sort_by(["bb", "a", "cc"], fn(s) { len(s) })`, "[a, bb, cc]"},
+		{`sort_by([1, "a"], fn(x) { x })`, "ERROR: keys of `sort_by` must be all INTEGER or all STRING, got=STRING"},
+		{`each([1, 2], fn(x) { x })`, "null"},
+		{`range(0, 3)`, "[0, 1, 2]"},
+		{`range(3, 0)`, "[]"},
+		{`map(1, fn(x) { x })`, "ERROR: argument to `map` must be ARRAY, got=INTEGER"},
+		{`map([1], 1)`, "ERROR: argument to `map` not supported, got=INTEGER"},
+		{`map([1], fn(x) { x + "a" })`, "ERROR: type mismatch: INTEGER + STRING"},
+	}
+
+	runInspectTests(t, tests)
+}
+
+// A callback that fails hands the builtin an ERROR value, as in the VM,
+// which is tested against the same table.
+func TestHigherOrderBuiltinErrors(t *testing.T) {
+	tests := []inspectTestCase{
+		{`reduce([1], 0, fn(x) { x })`, "ERROR: wrong number of arguments: want=1, got=2"},
+		{`map([1], fn(x) { first(x) })`, "ERROR: argument to `first` must be ARRAY, got=INTEGER"},
+		{
+			`map([[1]], fn(xs) { map(xs, fn(x) { reduce([x], 0, fn(a) { a }) }) })`,
+			"ERROR: wrong number of arguments: want=1, got=2",
+		},
+	}
+
+	runInspectTests(t, tests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []inspectTestCase{
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"héllo"[5]`, "null"},
+		{`"héllo"[-1]`, "o"},
+		{`"héllo"[-6]`, "null"},
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`split("añb", "")`, "[a, ñ, b]"},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join([], "-")`, ""},
+		{`join(["a", 1], "-")`, "ERROR: argument to `join` must be ARRAY of STRING, got=INTEGER at index 1"},
+		{`trim("  hi  ")`, "hi"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ÀB")`, "àb"},
+		{`contains("monkey", "key")`, "true"},
+		{`if (contains("monkey", "cat")) { 1 } else { 2 }`, "2"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`index_of("héllo", "l")`, "2"},
+		{`index_of("héllo", "z")`, "-1"},
+		{`substr("héllo", 1, 3)`, "éll"},
+		{`substr("héllo", 3, 10)`, "lo"},
+		{`substr("héllo", -2, 2)`, "hé"},
+		{`substr("héllo", 9, 2)`, ""},
+		{`chars("añ")`, "[a, ñ]"},
+		{`format("{} + {} = {}", 1, 2, "three")`, "1 + 2 = three"},
+		{`format("{{}} {}", [1])`, "{} [1]"},
+		{`format("{} {}", 1)`, "ERROR: not enough values for `format`: got=1"},
+		{`format("{}", 1, 2)`, "ERROR: too many values for `format`: want=1, got=2"},
+		{`format()`, "ERROR: wrong number of arguments. got=0, want at least 1"},
+		{`to_int(" 42 ") + 1`, "43"},
+		{`to_int("4x2")`, "null"},
+		{`to_int(7)`, "7"},
+		{`to_string(12) + "!"`, "12!"},
+		{`to_string([1, "a"])`, "[1, a]"},
+		{`upper(1)`, "ERROR: argument to `upper` must be STRING, got=INTEGER"},
+	}
+
+	runInspectTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []inspectTestCase{
+		{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+		{"[1, 2, 3, 4][:2]", "[1, 2]"},
+		{"[1, 2, 3, 4][2:]", "[3, 4]"},
+		{"[1, 2, 3, 4][:]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4][-2:]", "[3, 4]"},
+		{"[1, 2, 3, 4][:-1]", "[1, 2, 3]"},
+		{"[1, 2, 3, 4][-10:10]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4][3:1]", "[]"},
+		{"[1, 2, 3, 4][9:]", "[]"},
+		{"let xs = [1, 2]; let ys = xs[:]; push(ys, 3); xs", "[1, 2]"},
+		{`"héllo"[1:4]`, "éll"},
+		{`"héllo"[-3:]`, "llo"},
+		{`"héllo"[5:]`, ""},
+		{`[1, 2]["a":]`, "ERROR: slice bound must be INTEGER, got=STRING"},
+		{`{1: 2}[0:1]`, "ERROR: slice operator not supported: HASH"},
+	}
+
+	runInspectTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []inspectTestCase{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`keys({"b": 1, "a": 2, 3: 3, true: 4})`, "[b, a, 3, true]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`entries({"b": 1, "a": 2})`, "[[b, 1], [a, 2]]"},
+		{`{"x": 1, "y": 2, "x": 3}`, "{x: 3, y: 2}"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`has({"a": 1}, [len])`, "ERROR: unusable as hash key: ARRAY"},
+		{`has({[1, 2]: "a"}, [1, 2])`, "true"},
+		{`{[1, [2]]: "nested"}[[1, [2]]]`, "nested"},
+		{`{[1, 2]: "a", [2, 1]: "b"}[[2, 1]]`, "b"},
+		{`{{"x": 1, "y": 2}: "point"}[{"y": 2, "x": 1}]`, "point"},
+		{`{{"x": 1}: "point"}[{"x": 2}]`, "null"},
+		{`delete({[1]: 1, [2]: 2}, [1])`, "{[2]: 2}"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+		{`delete({"a": 1}, "z")`, "{a: 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+		{`merge({"a": 1, "b": 2}, {"c": 3, "a": 4})`, "{a: 4, b: 2, c: 3}"},
+		{`let h = {"a": 1}; merge(h, {"b": 2}); h`, "{a: 1}"},
+		{`map(entries({"a": 1, "b": 2}), fn(e) { e[0] + to_string(e[1]) })`, "[a1, b2]"},
+		{`keys([])`, "ERROR: argument to `keys` must be HASH, got=ARRAY"},
+	}
+
+	runInspectTests(t, tests)
+}
+
+// Programs that build or take apart a collection one element at a time. With
+// persistent arrays and hashes each step is cheap, so the time and memory a
+// run takes should grow about linearly with n, and ns/elem stay flat.
+var collectionBenchmarks = []struct {
+	name    string
+	program string
+}{
+	{"push", `len(reduce(range(0, %d), [], fn(xs, x) { push(xs, x) }))`},
+	{"rest", `let xs = range(0, %d); len(reduce(xs, xs, fn(acc, x) { rest(acc) }))`},
+	{"hash_insert", `len(keys(reduce(range(0, %d), {}, fn(h, x) { merge(h, {x: x}) })))`},
+}
+
+func BenchmarkCollections(b *testing.B) {
+	for _, bm := range collectionBenchmarks {
+		for _, n := range []int{1_000, 4_000, 16_000, 64_000} {
+			b.Run(fmt.Sprintf("%s/%d", bm.name, n), func(b *testing.B) {
+				program := parser.New(lexer.New(fmt.Sprintf(bm.program, n))).ParseProgram()
+
+				for i := 0; i < b.N; i++ {
+					if result := Eval(program, object.NewEnvironment()); isError(result) {
+						b.Fatal(result.Inspect())
+					}
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/elem")
+			})
+		}
+	}
+}
+
+// Copying the collection on every step would make a run on 8 times as many
+// elements allocate about 64 times as much.
+func TestCollectionsScale(t *testing.T) {
+	for _, bm := range collectionBenchmarks {
+		small := allocatedBytes(t, fmt.Sprintf(bm.program, 2_000))
+		large := allocatedBytes(t, fmt.Sprintf(bm.program, 16_000))
+
+		if large > 16*small {
+			t.Errorf("%s: allocations don't grow linearly. 2000 elements took %d bytes, 16000 took %d",
+				bm.name, small, large)
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []inspectTestCase{
+		{`let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(30)`, "265252859812191058636308480000000"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 1 - 1", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"99999999999999999999 / 3", "33333333333333333333"},
+		{"-99999999999999999999 / 7", "-14285714285714285714"},
+		{"99999999999999999999 * 0", "0"},
+		{"99999999999999999999 > 1", "true"},
+		{"1 < 99999999999999999999", "true"},
+		{"-99999999999999999999 < -1", "true"},
+		{"99999999999999999999 == 99999999999999999998 + 1", "true"},
+		{"99999999999999999999 != 99999999999999999999", "false"},
+		{`{9223372036854775807: "max"}[9223372036854775808 - 1]`, "max"},
+		{`{99999999999999999999: "big"}[99999999999999999998 + 1]`, "big"},
+		{`to_int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{`sort_by([99999999999999999999, 1, -99999999999999999999], fn(x) { x })`, "[-99999999999999999999, 1, 99999999999999999999]"},
+		{`range(0, 99999999999999999999)`, "ERROR: argument to `range` must be INTEGER, got=BIG_INTEGER"},
+		{"1 / 0", "ERROR: division by zero"},
+		{"99999999999999999999 / 0", "ERROR: division by zero"},
+		{"let sq = fn(x, n) { if (n == 0) { x } else { sq(x * x, n - 1) } }; len(to_string(sq(2, 15)))", "9865"},
+		{"let sq = fn(x, n) { if (n == 0) { x } else { sq(x * x, n - 1) } }; sq(3, 26)", "ERROR: integer too large: more than 65536 bits"},
+	}
+
+	runInspectTests(t, tests)
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []inspectTestCase{
+		{`json_encode({"a": [1, true, first([])], "b": "x"})`, `{"a":[1,true,null],"b":"x"}`},
+		{`json_encode(9223372036854775807 + 1)`, "9223372036854775808"},
+		{`json_encode("<a & b>")`, `"<a & b>"`},
+		{`json_decode("[1, 2, null, true]")`, "[1, 2, null, true]"},
+		{`json_decode(json_encode({"b": 1, "a": [2, {}]}))`, "{b: 1, a: [2, {}]}"},
+		{`json_decode("123456789012345678901234567890") - 1`, "123456789012345678901234567889"},
+		{`json_decode("1.5")`, "ERROR: json_decode: number 1.5 is not an integer"},
+		{`json_decode("[1,")`, "ERROR: json_decode: unexpected end of JSON input"},
+		{`json_decode("1 2")`, "ERROR: json_decode: unexpected data after JSON value"},
+		{`json_encode([len])`, "ERROR: json_encode: no JSON form for BUILTIN"},
+		{`json_encode({1: 2})`, "ERROR: json_encode: unusable as JSON key: INTEGER"},
+		{`json_decode(1)`, "ERROR: argument to `json_decode` must be STRING, got=INTEGER"},
+	}
+
+	runInspectTests(t, tests)
+}
+
+func TestStructs(t *testing.T) {
+	tests := []inspectTestCase{
+		{"struct Point { x, y }; Point(1, 2)", "Point{x: 1, y: 2}"},
+		{"struct Point { x, y }; Point", "struct Point { x, y }"},
+		{"struct Point { x, y }; let p = Point(1, 2); p.x + p.y", "3"},
+		{"struct Point { x, y }; let p = Point(1, 2); p with { x: 5 }", "Point{x: 5, y: 2}"},
+		{"struct Point { x, y }; let p = Point(1, 2); let q = p with { y: 7 }; [p.y, q.y]", "[2, 7]"},
+		{"struct Point { x, y }; Point(1, 2) with { y: 3, x: 4, y: 5 }", "Point{x: 4, y: 5}"},
+		{"struct Line { from, to }; struct Point { x, y }; Line(Point(0, 0), Point(3, 4)).to.y", "4"},
+		{"struct Unit {}; Unit()", "Unit{}"},
+		{"struct Pair { a, b }; map([1, 2], fn(x) { Pair(x, x * 2) })", "[Pair{a: 1, b: 2}, Pair{a: 2, b: 4}]"},
+		{"let mk = fn(x) { struct Box { value }; Box(x) }; mk(5).value", "5"},
+		{"struct Point { x, y }; {Point(1, 2): \"a\"}[Point(1, 2)]", "a"},
+		{"struct Point { x, y }; json_encode(Point(1, [2]))", `{"x":1,"y":[2]}`},
+		{`struct Point { x, y }; Point(1)`, `ERROR: wrong number of arguments: want=2, got=1`},
+		{`struct Point { x, y }; Point(1, 2).z`, "ERROR: Point has no field `z`"},
+		{`struct Point { x, y }; Point(1, 2) with { z: 1 }`, "ERROR: Point has no field `z`"},
+		{`{"x": 1}.x`, `ERROR: field access not supported: HASH`},
+		{`1 with { x: 1 }`, "ERROR: `with` not supported: INTEGER"},
+	}
+
+	runInspectTests(t, tests)
+}
+
 // Helpers
 
 func testNullObject(t *testing.T, obj object.Object) bool {
@@ -552,3 +1115,38 @@ func testEval(input string) object.Object {
 
 	return Eval(program, env)
 }
+
+// allocatedBytes evaluates a program and returns how many bytes it allocated.
+func allocatedBytes(t *testing.T, input string) uint64 {
+	t.Helper()
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if result := Eval(program, object.NewEnvironment()); isError(result) {
+		t.Fatalf("%s: %s", input, result.Inspect())
+	}
+	runtime.ReadMemStats(&after)
+
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+type inspectTestCase struct {
+	input    string
+	expected string
+}
+
+// runInspectTests evaluates each program and compares the value it gives, as
+// Inspect prints it.
+func runInspectTests(t *testing.T, tests []inspectTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
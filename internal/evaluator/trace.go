@@ -0,0 +1,242 @@
+package evaluator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+)
+
+// Tracer observes an Evaluator. Enter and Exit bracket every node it visits,
+// Call fires when a Monkey function is applied, with the environment its body
+// runs in.
+type Tracer interface {
+	Enter(node ast.Node, env *object.Environment)
+	Exit(node ast.Node, result object.Object)
+	Call(fn *object.Function, args []object.Object, env *object.Environment)
+}
+
+type TraceEventKind string
+
+const (
+	TraceEnter TraceEventKind = "enter"
+	TraceExit  TraceEventKind = "exit"
+	TraceCall  TraceEventKind = "call"
+)
+
+// DefaultTraceLimit caps how many events a trace keeps, so a long running
+// program doesn't eat all the memory.
+const DefaultTraceLimit = 10_000
+
+const (
+	// MaxTraceValue caps the bytes kept of each value or source an event
+	// shows, the rest of it being cut and marked with "...".
+	MaxTraceValue = 200
+	// MaxTraceBytes caps the bytes of values and sources a whole trace
+	// keeps, as the same large value shown over and over would still add up.
+	MaxTraceBytes = 1 << 20
+	// MaxTraceBindings caps the bindings a call shows of each environment
+	// in its chain, so a call doesn't copy every global.
+	MaxTraceBindings = 20
+)
+
+type TraceEvent struct {
+	Step   int            `json:"step"`
+	Kind   TraceEventKind `json:"kind"`
+	Depth  int            `json:"depth"`
+	Node   string         `json:"node"` // e.g. "InfixExpression"
+	Source string         `json:"source"`
+	Span   ast.Span       `json:"span"`
+	// Env is the environment the node is evaluated in, or for calls the one
+	// the function body runs in.
+	Env int `json:"env,omitempty"`
+
+	Value string            `json:"value,omitempty"`
+	Type  object.ObjectType `json:"type,omitempty"`
+	// Captures is the environment a function value closes over.
+	Captures int `json:"captures,omitempty"`
+
+	Args []string `json:"args,omitempty"`
+	// Chain lists the environments visible to a call, innermost first.
+	Chain []TraceEnv `json:"chain,omitempty"`
+}
+
+type TraceEnv struct {
+	ID       int               `json:"id"`
+	Bindings map[string]string `json:"bindings"`
+	// Omitted counts the bindings left out past MaxTraceBindings, the first
+	// ones by name being kept.
+	Omitted int `json:"omitted,omitempty"`
+}
+
+// Trace is a Tracer that records events in the order they happen.
+type Trace struct {
+	Events []TraceEvent `json:"events"`
+	// Truncated is set once events were dropped for going over the limit or
+	// MaxTraceBytes.
+	Truncated bool `json:"truncated"`
+
+	limit int
+	depth int
+	// The bytes of values and sources kept so far.
+	bytes  int
+	envIDs map[*object.Environment]int
+	// The clipped source of every node seen, as loops show the same nodes
+	// over and over.
+	sources map[ast.Node]string
+}
+
+func NewTrace(limit int) *Trace {
+	return &Trace{
+		Events:  []TraceEvent{},
+		limit:   limit,
+		envIDs:  map[*object.Environment]int{},
+		sources: map[ast.Node]string{},
+	}
+}
+
+func (t *Trace) Enter(node ast.Node, env *object.Environment) {
+	if !t.full() {
+		t.record(TraceEvent{
+			Kind:   TraceEnter,
+			Node:   nodeName(node),
+			Source: t.source(node),
+			Span:   node.NodeSpan(),
+			Env:    t.envID(env),
+		})
+	}
+	t.depth++
+}
+
+func (t *Trace) Exit(node ast.Node, result object.Object) {
+	t.depth--
+	if t.full() {
+		return
+	}
+
+	event := TraceEvent{
+		Kind:   TraceExit,
+		Node:   nodeName(node),
+		Source: t.source(node),
+		Span:   node.NodeSpan(),
+	}
+	if result != nil {
+		event.Value = t.inspect(result)
+		event.Type = result.Type()
+	}
+	if fn, ok := result.(*object.Function); ok {
+		event.Captures = t.envID(fn.Env)
+	}
+
+	t.record(event)
+}
+
+func (t *Trace) Call(fn *object.Function, args []object.Object, env *object.Environment) {
+	if t.full() {
+		return
+	}
+
+	event := TraceEvent{
+		Kind:   TraceCall,
+		Node:   nodeName(fn.Body),
+		Source: t.inspect(fn),
+		Span:   fn.Body.NodeSpan(),
+		Env:    t.envID(env),
+		Args:   []string{},
+	}
+
+	for _, arg := range args {
+		event.Args = append(event.Args, t.inspect(arg))
+	}
+
+	for e := env; e != nil; e = e.Outer() {
+		event.Chain = append(event.Chain, t.traceEnv(e))
+	}
+
+	t.record(event)
+}
+
+// traceEnv shows the first MaxTraceBindings bindings of env by name.
+func (t *Trace) traceEnv(env *object.Environment) TraceEnv {
+	bindings := env.Bindings()
+
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	frame := TraceEnv{ID: t.envID(env), Bindings: map[string]string{}}
+	if len(names) > MaxTraceBindings {
+		frame.Omitted = len(names) - MaxTraceBindings
+		names = names[:MaxTraceBindings]
+	}
+	for _, name := range names {
+		frame.Bindings[name] = t.inspect(bindings[name])
+	}
+
+	return frame
+}
+
+// inspect shows obj in at most MaxTraceValue bytes, counting them against
+// MaxTraceBytes.
+func (t *Trace) inspect(obj object.Object) string {
+	s := object.Preview(obj, MaxTraceValue)
+	t.bytes += len(s)
+
+	return s
+}
+
+// source shows the source of node in at most MaxTraceValue bytes, counting
+// them against MaxTraceBytes.
+func (t *Trace) source(node ast.Node) string {
+	s, ok := t.sources[node]
+	if !ok {
+		s = node.String()
+		if len(s) > MaxTraceValue {
+			s = strings.ToValidUTF8(s[:MaxTraceValue-3], "") + "..."
+		}
+		t.sources[node] = s
+	}
+	t.bytes += len(s)
+
+	return s
+}
+
+// full reports whether the trace has reached its limit or MaxTraceBytes,
+// marking it truncated. The hooks check it first, so nothing is built for
+// events that would be dropped.
+func (t *Trace) full() bool {
+	if len(t.Events) >= t.limit || t.bytes >= MaxTraceBytes {
+		t.Truncated = true
+	}
+
+	return t.Truncated
+}
+
+func (t *Trace) record(event TraceEvent) {
+	event.Step = len(t.Events)
+	event.Depth = t.depth
+	t.Events = append(t.Events, event)
+}
+
+// envID numbers environments in the order the trace first sees them.
+func (t *Trace) envID(env *object.Environment) int {
+	if env == nil {
+		return 0
+	}
+
+	id, ok := t.envIDs[env]
+	if !ok {
+		id = len(t.envIDs) + 1
+		t.envIDs[env] = id
+	}
+
+	return id
+}
+
+func nodeName(node ast.Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
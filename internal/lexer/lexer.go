@@ -1,7 +1,7 @@
 package lexer
 
 import (
-	"errors"
+	"strings"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
 )
@@ -11,21 +11,73 @@ type Lexer struct {
 	position     int  // Current position in input
 	readPosition int  // Current reading position in input (after current char)
 	ch           byte // current char under examination
+	line         int  // Line of the current char
+	column       int  // Column of the current char
+
+	// Operators declared with `infixl`/`infixr` so far. They are matched
+	// greedily before the built-in single and double char operators.
+	operators map[string]bool
+	// Length of the longest declared operator, the furthest a match can go.
+	longestOperator int
+	// Set right after an `infixl`/`infixr` keyword, so the next run of
+	// operator chars is read as a whole.
+	declaring bool
+
+	locale *token.Locale
+	// A `// locale: es` pragma is only honored before the first token.
+	seenToken bool
+	// Error raised while skipping comments, reported as the next token.
+	pragmaErr string
+
+	// Every token read so far, kept once Record is called.
+	recording bool
+	tokens    []token.Token
 }
 
 func New(input string) *Lexer {
+	return NewWithLocale(input, token.English)
+}
+
+// NewWithLocale creates a lexer that reads keywords spelled in the given
+// locale. A `// locale: <name>` comment at the top of the input overrides it.
+func NewWithLocale(input string, locale *token.Locale) *Lexer {
 	l := &Lexer{
 		input:        input,
 		position:     0,
 		readPosition: 0,
 		ch:           0,
+		line:         1,
+		operators:    map[string]bool{},
+		locale:       locale,
 	}
 	l.readChar()
 
 	return l
 }
 
+// DeclareOperator makes the lexer read symbol as a single token from now on.
+// The parser calls it once it accepts an `infixl`/`infixr` declaration, so a
+// rejected one doesn't change how the rest of the input is read.
+func (l *Lexer) DeclareOperator(symbol string) {
+	l.operators[symbol] = true
+	l.longestOperator = max(l.longestOperator, len(symbol))
+}
+
+// Locale returns the locale currently in use, which a pragma may have
+// changed since the lexer was created.
+func (l *Lexer) Locale() *token.Locale {
+	return l.locale
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	if l.readPosition <= len(l.input) {
+		l.column++
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -44,14 +96,59 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPosition]
 }
 
-func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+// pos returns the position of the current char.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Offset: min(l.position, len(l.input)),
+		Line:   l.line,
+		Column: l.column,
+	}
+}
 
+func (l *Lexer) NextToken() token.Token {
 	l.skipWhitespace()
-	if l.ch == '/' && l.peekChar() == '/' {
+	for l.ch == '/' && l.peekChar() == '/' {
 		l.skipComment()
 	}
 
+	start := l.pos()
+	if l.pragmaErr != "" {
+		tok := token.Token{Type: token.ILLEGAL, Literal: l.pragmaErr, Start: start, End: start}
+		l.pragmaErr = ""
+		return tok
+	}
+	l.seenToken = true
+
+	tok := l.readToken()
+	tok.Start, tok.End = start, l.pos()
+
+	if l.recording {
+		l.tokens = append(l.tokens, tok)
+	}
+
+	return tok
+}
+
+// Record makes the lexer keep every token it reads from now on, so a caller
+// can see the tokens a parser was given.
+func (l *Lexer) Record() {
+	l.recording = true
+}
+
+// Tokens returns the tokens read since Record was called.
+func (l *Lexer) Tokens() []token.Token {
+	return l.tokens
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
+	if token.IsOperatorStart(l.ch) {
+		if tok, ok := l.readOperator(); ok {
+			return tok
+		}
+	}
+
 	switch l.ch {
 
 	case '=':
@@ -69,10 +166,10 @@ func (l *Lexer) NextToken() token.Token {
 
 	// Identifiers + literals
 	case '"':
-		str, err := l.readString()
-		if err != nil {
+		str, ok := l.readString()
+		if !ok {
 			tok.Type = token.ILLEGAL
-			tok.Literal = err.Error()
+			tok.Literal = l.locale.Sprintf("unterminated string")
 		} else {
 			tok.Type = token.STRING
 			tok.Literal = str
@@ -111,6 +208,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.COLON, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -131,7 +230,8 @@ func (l *Lexer) NextToken() token.Token {
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
-			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Type = l.locale.LookupIdent(tok.Literal)
+			l.declaring = tok.Type == token.INFIXL || tok.Type == token.INFIXR
 			return tok
 		}
 
@@ -144,10 +244,47 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.ILLEGAL, l.ch)
 	}
 
+	l.declaring = false
 	l.readChar()
 	return tok
 }
 
+// readOperator reads a user-defined operator. While declaring, the whole run
+// of operator chars is the operator being declared; otherwise the longest
+// declared operator prefix of the run wins. Custom operator tokens use their
+// own symbol as token type, just like the built-in ones do.
+func (l *Lexer) readOperator() (token.Token, bool) {
+	if !l.declaring && len(l.operators) == 0 {
+		return token.Token{}, false
+	}
+
+	end := l.position
+	for end < len(l.input) && token.IsOperatorChar(l.input[end]) {
+		if !l.declaring && end-l.position == l.longestOperator {
+			break
+		}
+		end++
+	}
+	run := l.input[l.position:end]
+
+	if l.declaring {
+		l.declaring = false
+	} else {
+		for len(run) > 0 && !l.operators[run] {
+			run = run[:len(run)-1]
+		}
+		if run == "" {
+			return token.Token{}, false
+		}
+	}
+
+	for range run {
+		l.readChar()
+	}
+
+	return token.Token{Type: token.TokenType(run), Literal: run}, true
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
@@ -155,12 +292,34 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) skipComment() {
-	for l.ch != '\n' || l.ch == 0 {
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
+
+	if !l.seenToken {
+		l.readPragma(l.input[position+2 : l.position])
+	}
+
 	l.skipWhitespace()
 }
 
+// readPragma switches locale when a leading comment reads `locale: <name>`.
+func (l *Lexer) readPragma(comment string) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(comment), "locale:")
+	if !ok {
+		return
+	}
+
+	name = strings.TrimSpace(name)
+	if locale, ok := token.LookupLocale(name); ok {
+		l.locale = locale
+		return
+	}
+
+	l.pragmaErr = l.locale.Sprintf("unknown locale %q", name)
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) {
@@ -174,7 +333,7 @@ func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
-func (l *Lexer) readString() (string, error) {
+func (l *Lexer) readString() (string, bool) {
 	position := l.position + 1
 	for {
 		l.readChar()
@@ -183,11 +342,11 @@ func (l *Lexer) readString() (string, error) {
 		}
 
 		if l.ch == 0 {
-			return "", errors.New("unterminated string")
+			return "", false
 		}
 	}
 
-	return l.input[position:l.position], nil
+	return l.input[position:l.position], true
 }
 
 func (l *Lexer) readNumber() string {
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
@@ -162,3 +163,265 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestOperatorDeclarations(t *testing.T) {
+	input := `infixl 45 <+> = add;
+	a <+> b;
+	a <+>> b;
+	a <+ b;
+	{"a":-1};`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INFIXL, "infixl"},
+		{token.INT, "45"},
+		{"<+>", "<+>"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "add"},
+		{token.SEMICOLON, ";"},
+
+		{token.IDENT, "a"},
+		{"<+>", "<+>"},
+		{token.IDENT, "b"},
+		{token.SEMICOLON, ";"},
+
+		{token.IDENT, "a"},
+		{"<+>", "<+>"},
+		{token.RT, ">"},
+		{token.IDENT, "b"},
+		{token.SEMICOLON, ";"},
+
+		{token.IDENT, "a"},
+		{token.LT, "<"},
+		{token.PLUS, "+"},
+		{token.IDENT, "b"},
+		{token.SEMICOLON, ";"},
+
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - TokenType wrong. Expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong. Expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		// The parser declares the operator once it accepts the declaration.
+		if i == 2 {
+			l.DeclareOperator(tok.Literal)
+		}
+	}
+}
+
+func TestUndeclaredOperators(t *testing.T) {
+	l := New(strings.Repeat("-", 100_000) + " infixl 45 <+> = add; a <+> b")
+
+	for i := 0; i < 100_000; i++ {
+		if tok := l.NextToken(); tok.Type != token.MINUS {
+			t.Fatalf("tests[%d] - TokenType wrong. Expected=%q, got=%q", i, token.MINUS, tok.Type)
+		}
+	}
+
+	expected := []token.TokenType{
+		token.INFIXL, token.INT, "<+>", token.ASSIGN, token.IDENT, token.SEMICOLON,
+		token.IDENT, token.LT, token.PLUS, token.RT, token.IDENT, token.EOF,
+	}
+	for i, tt := range expected {
+		if tok := l.NextToken(); tok.Type != tt {
+			t.Fatalf("tests[%d] - TokenType wrong. Expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
+
+func TestStructTokens(t *testing.T) {
+	input := `struct Point { x, y }
+	p.x with { y: 1 };`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRUCT, "struct"},
+		{token.IDENT, "Point"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "x"},
+		{token.COMMA, ","},
+		{token.IDENT, "y"},
+		{token.RBRACE, "}"},
+
+		{token.IDENT, "p"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.WITH, "with"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "y"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - TokenType wrong. Expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong. Expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestLocaleKeywords(t *testing.T) {
+	tests := []struct {
+		input    string
+		locale   *token.Locale
+		expected []token.Token
+	}{
+		{
+			`sea x = funcion(a) { si (a) { retornar verdadero } sino { falso } };`,
+			token.Spanish,
+			[]token.Token{
+				{Type: token.LET, Literal: "sea"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.FUNCTION, Literal: "funcion"},
+				{Type: token.LPAREN, Literal: "("},
+				{Type: token.IDENT, Literal: "a"},
+				{Type: token.RPAREN, Literal: ")"},
+				{Type: token.LBRACE, Literal: "{"},
+				{Type: token.IF, Literal: "si"},
+				{Type: token.LPAREN, Literal: "("},
+				{Type: token.IDENT, Literal: "a"},
+				{Type: token.RPAREN, Literal: ")"},
+				{Type: token.LBRACE, Literal: "{"},
+				{Type: token.RETURN, Literal: "retornar"},
+				{Type: token.TRUE, Literal: "verdadero"},
+				{Type: token.RBRACE, Literal: "}"},
+				{Type: token.ELSE, Literal: "sino"},
+				{Type: token.LBRACE, Literal: "{"},
+				{Type: token.FALSE, Literal: "falso"},
+				{Type: token.RBRACE, Literal: "}"},
+				{Type: token.RBRACE, Literal: "}"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			`let si = fn`,
+			token.English,
+			[]token.Token{
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "si"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.FUNCTION, Literal: "fn"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			"// Programa de ejemplo\n// locale: es\nsea let",
+			token.English,
+			[]token.Token{
+				{Type: token.LET, Literal: "sea"},
+				{Type: token.IDENT, Literal: "let"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			"let x;\n// locale: es\nsea",
+			token.English,
+			[]token.Token{
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.IDENT, Literal: "sea"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			"// locale: xx\nlet",
+			token.Spanish,
+			[]token.Token{
+				{Type: token.ILLEGAL, Literal: `idioma desconocido "xx"`},
+				{Type: token.IDENT, Literal: "let"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := NewWithLocale(tt.input, tt.locale)
+
+		for i, expected := range tt.expected {
+			tok := l.NextToken()
+
+			if tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Fatalf("%q[%d] - wrong token. Expected=%+v, got=%+v",
+					tt.input, i, expected, tok)
+			}
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"hi\" <= y\n// done\n"
+
+	tests := []struct {
+		expectedLiteral string
+		start, end      token.Position
+	}{
+		{"let", token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{"x", token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{"=", token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{"5", token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 9, Line: 1, Column: 10}},
+		{";", token.Position{Offset: 9, Line: 1, Column: 10}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{"hi", token.Position{Offset: 13, Line: 2, Column: 3}, token.Position{Offset: 17, Line: 2, Column: 7}},
+		{"<", token.Position{Offset: 18, Line: 2, Column: 8}, token.Position{Offset: 19, Line: 2, Column: 9}},
+		{"=", token.Position{Offset: 19, Line: 2, Column: 9}, token.Position{Offset: 20, Line: 2, Column: 10}},
+		{"y", token.Position{Offset: 21, Line: 2, Column: 11}, token.Position{Offset: 22, Line: 2, Column: 12}},
+		{"", token.Position{Offset: 31, Line: 4, Column: 1}, token.Position{Offset: 31, Line: 4, Column: 1}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong. Expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Start != tt.start || tok.End != tt.end {
+			t.Fatalf("tests[%d] - %q position wrong. Expected=%+v-%+v, got=%+v-%+v",
+				i, tok.Literal, tt.start, tt.end, tok.Start, tok.End)
+		}
+	}
+}
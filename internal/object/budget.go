@@ -0,0 +1,125 @@
+package object
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// Budget bounds how much work a single run of either engine may do. A zero
+// field means no limit.
+type Budget struct {
+	// Steps counts AST nodes evaluated or VM instructions executed.
+	Steps int `json:"steps"`
+	// Depth counts nested function calls.
+	Depth int `json:"depth"`
+	// Allocs counts objects created. Arrays and hashes also count one per
+	// element they store rather than share with the value they came from, and
+	// strings one per byte, so growing values can't dodge it.
+	Allocs int `json:"allocs"`
+}
+
+var ErrBudgetExceeded = Errorf("execution budget exceeded")
+
+// BudgetError reports which limit of a Budget a run went over. It matches
+// ErrBudgetExceeded with errors.Is.
+type BudgetError struct {
+	Limit string // "steps", "depth" or "allocs"
+	Max   int
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s: %s limit of %d reached", ErrBudgetExceeded, e.Limit, e.Max)
+}
+
+// Localize words the error in locale, the limit included.
+func (e *BudgetError) Localize(locale *token.Locale) string {
+	return locale.Sprintf("%s: %s limit of %d reached",
+		Localize(ErrBudgetExceeded, locale), locale.Sprintf(e.Limit), e.Max)
+}
+
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// How many steps go by between checks of the context, which are too slow to
+// do on every step.
+const cancelCheckInterval = 1024
+
+// Meter charges the work of a run against a Budget and watches a context for
+// cancellation.
+type Meter struct {
+	ctx    context.Context
+	budget Budget
+
+	steps  int
+	depth  int
+	allocs int
+}
+
+func NewMeter(ctx context.Context, budget Budget) *Meter {
+	return &Meter{ctx: ctx, budget: budget}
+}
+
+// Step charges one step, failing once the budget runs out or the context is
+// done.
+func (m *Meter) Step() error {
+	m.steps++
+	if m.budget.Steps > 0 && m.steps > m.budget.Steps {
+		return &BudgetError{Limit: "steps", Max: m.budget.Steps}
+	}
+
+	if m.steps%cancelCheckInterval == 0 {
+		if err := m.ctx.Err(); err != nil {
+			return Errorf("execution cancelled: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Call enters a function call, which Return leaves again.
+func (m *Meter) Call() error {
+	m.depth++
+	if m.budget.Depth > 0 && m.depth > m.budget.Depth {
+		return &BudgetError{Limit: "depth", Max: m.budget.Depth}
+	}
+
+	return nil
+}
+
+func (m *Meter) Return() {
+	m.depth--
+}
+
+// Alloc charges for a newly created object.
+func (m *Meter) Alloc(obj Object) error {
+	m.allocs += allocCost(obj)
+	if m.budget.Allocs > 0 && m.allocs > m.budget.Allocs {
+		return &BudgetError{Limit: "allocs", Max: m.budget.Allocs}
+	}
+
+	return nil
+}
+
+func allocCost(obj Object) int {
+	switch obj := obj.(type) {
+	case nil, *Null, *Boolean, *Error:
+		return 0
+	case *String:
+		return 1 + len(obj.Value)
+	case *BigInteger:
+		return 1 + len(obj.Value.Bits())
+	case *Array:
+		return 1 + obj.fresh
+	case *Hash:
+		return 1 + obj.fresh
+	case *Closure:
+		return 1 + len(obj.Free)
+	case *Struct:
+		return 1 + len(obj.values)
+	default:
+		return 1
+	}
+}
@@ -2,186 +2,503 @@ package object
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"unicode/utf8"
 )
 
-type BuiltinsFns struct {
-	Name    string
-	Builtin *Builtin
+// Types of the values a builtin can call back into.
+var callableTypes = []ObjectType{FUNCTION_OBJ, CLOSURE_OBJ, BUILTIN_OBJ, STRUCT_TYPE_OBJ}
+
+// MaxRangeLength bounds the arrays `range` builds.
+const MaxRangeLength = 1_000_000
+
+// Param describes one parameter of a builtin.
+type Param struct {
+	Name string `json:"name"`
+	// Types the argument may have. Empty accepts anything.
+	Types []ObjectType `json:"types,omitempty"`
 }
 
-// The built-in functions / standard-library methods are stored here.
-var Builtins = []BuiltinsFns{}
+// Call runs the builtin after checking the arguments against its params.
+func (b *Builtin) Call(rt *Runtime, args ...Object) Object {
+	if err := b.checkArgs(args); err != nil {
+		return err
+	}
 
-// init registers built-in functions to the "standard library" map.
-func init() {
-	RegisterBuiltin("len", _lenFn)
-	RegisterBuiltin("unicodeLen", _unicodeLenFn)
-	RegisterBuiltin("first", _firstFn)
-	RegisterBuiltin("last", _lastFn)
-	RegisterBuiltin("rest", _restFn)
-	RegisterBuiltin("push", _pushFn)
-	RegisterBuiltin("puts", _putsFn)
+	return b.Fn(rt, args...)
 }
 
-// Utils
+func (b *Builtin) checkArgs(args []Object) *Error {
+	if !b.Variadic && len(args) != len(b.Params) {
+		return NewError("wrong number of arguments. got=%d, want=%d",
+			len(args), len(b.Params))
+	}
 
-// RegisterBuiltin registers a built-in function.
-func RegisterBuiltin(name string, fn BuiltinFunction) {
-	Builtins = append(Builtins, BuiltinsFns{
-		Name:    name,
-		Builtin: &Builtin{Fn: fn},
-	})
+	// The variadic param may be left out, but the ones before it may not.
+	if b.Variadic && len(args) < len(b.Params)-1 {
+		return NewError("wrong number of arguments. got=%d, want at least %d",
+			len(args), len(b.Params)-1)
+	}
+
+	if len(b.Params) == 0 {
+		return nil
+	}
+
+	for i, arg := range args {
+		param := b.Params[min(i, len(b.Params)-1)]
+		if len(param.Types) == 0 || acceptsType(param.Types, arg.Type()) {
+			continue
+		}
+
+		if len(param.Types) == 1 {
+			return NewError("argument to `%s` must be %s, got=%s",
+				b.Name, param.Types[0], arg.Type())
+		}
+
+		return NewError("argument to `%s` not supported, got=%s", b.Name, arg.Type())
+	}
+
+	return nil
 }
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, def := range Builtins {
-		if def.Name == name {
-			return def.Builtin
+func acceptsType(types []ObjectType, t ObjectType) bool {
+	for _, accepted := range types {
+		if accepted == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Signature renders the builtin like a Monkey call, e.g. `push(array, value)`.
+func (b *Builtin) Signature() string {
+	params := []string{}
+	for _, p := range b.Params {
+		params = append(params, p.Name)
+	}
+
+	variadic := ""
+	if b.Variadic {
+		variadic = "..."
+	}
+
+	return fmt.Sprintf("%s(%s%s)", b.Name, strings.Join(params, ", "), variadic)
+}
+
+// Stdlib is an ordered set of builtins. The position of a builtin is the
+// operand of OpGetBuiltin, so bytecode must run on a VM with the same Stdlib
+// it was compiled with.
+type Stdlib struct {
+	builtins []*Builtin
+	index    map[string]int
+}
+
+// MaxBuiltins bounds the builtins of a Stdlib, as the operand of
+// OpGetBuiltin is a single byte.
+const MaxBuiltins = 256
+
+// NewStdlib creates a Stdlib of builtins, panicking if there are more than
+// MaxBuiltins of them.
+func NewStdlib(builtins ...*Builtin) *Stdlib {
+	s := &Stdlib{
+		builtins: []*Builtin{},
+		index:    map[string]int{},
+	}
+
+	for _, b := range builtins {
+		if err := s.Register(b); err != nil {
+			panic(err)
 		}
 	}
 
+	return s
+}
+
+// DefaultStdlib returns a new copy of the standard library, so hosts can
+// extend or trim it without affecting anyone else.
+func DefaultStdlib() *Stdlib {
+	stdlib := NewStdlib(
+		&Builtin{
+			Name:   "len",
+			Params: []Param{{"value", []ObjectType{STRING_OBJ, ARRAY_OBJ}}},
+			Fn:     _lenFn,
+		},
+		&Builtin{
+			Name:   "unicodeLen",
+			Params: []Param{{"string", nil}},
+			Fn:     _unicodeLenFn,
+		},
+		&Builtin{
+			Name:   "first",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}},
+			Fn:     _firstFn,
+		},
+		&Builtin{
+			Name:   "last",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}},
+			Fn:     _lastFn,
+		},
+		&Builtin{
+			Name:   "rest",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}},
+			Fn:     _restFn,
+		},
+		&Builtin{
+			Name:   "push",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}, {"value", nil}},
+			Fn:     _pushFn,
+		},
+		&Builtin{
+			Name:     "puts",
+			Params:   []Param{{"values", nil}},
+			Variadic: true,
+			Fn:       _putsFn,
+		},
+		&Builtin{
+			Name:   "gets",
+			Params: []Param{},
+			Fn:     _getsFn,
+		},
+		&Builtin{
+			Name:   "map",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}, {"fn", callableTypes}},
+			Fn:     _mapFn,
+		},
+		&Builtin{
+			Name:   "filter",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}, {"fn", callableTypes}},
+			Fn:     _filterFn,
+		},
+		&Builtin{
+			Name: "reduce",
+			Params: []Param{
+				{"array", []ObjectType{ARRAY_OBJ}}, {"initial", nil}, {"fn", callableTypes},
+			},
+			Fn: _reduceFn,
+		},
+		&Builtin{
+			Name:   "sort_by",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}, {"fn", callableTypes}},
+			Fn:     _sortByFn,
+		},
+		&Builtin{
+			Name:   "each",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}, {"fn", callableTypes}},
+			Fn:     _eachFn,
+		},
+		&Builtin{
+			Name: "range",
+			Params: []Param{
+				{"start", []ObjectType{INTEGER_OBJ}}, {"end", []ObjectType{INTEGER_OBJ}},
+			},
+			Fn: _rangeFn,
+		},
+	)
+
+	for _, b := range slices.Concat(stringBuiltins(), hashBuiltins(), jsonBuiltins()) {
+		if err := stdlib.Register(b); err != nil {
+			panic(err)
+		}
+	}
+
+	return stdlib
+}
+
+// Register adds a builtin. One with the same name is replaced in place, so
+// it keeps its index. It fails once the Stdlib holds MaxBuiltins builtins.
+func (s *Stdlib) Register(b *Builtin) error {
+	if i, ok := s.index[b.Name]; ok {
+		s.builtins[i] = b
+		return nil
+	}
+
+	if len(s.builtins) >= MaxBuiltins {
+		return fmt.Errorf("can't register %s: a stdlib holds at most %d builtins", b.Name, MaxBuiltins)
+	}
+
+	s.index[b.Name] = len(s.builtins)
+	s.builtins = append(s.builtins, b)
+
 	return nil
 }
 
-// Utility function to create a new error object.
-func newError(format string, a ...any) *Error {
-	return &Error{
-		Message: fmt.Sprintf(format, a...),
+func (s *Stdlib) Lookup(name string) (*Builtin, bool) {
+	i, ok := s.index[name]
+	if !ok {
+		return nil, false
+	}
+
+	return s.builtins[i], true
+}
+
+// Index returns the index of the builtin called name.
+func (s *Stdlib) Index(name string) (int, bool) {
+	i, ok := s.index[name]
+
+	return i, ok
+}
+
+// At returns the builtin at the given index, as used by OpGetBuiltin.
+func (s *Stdlib) At(index int) (*Builtin, bool) {
+	if index < 0 || index >= len(s.builtins) {
+		return nil, false
 	}
+
+	return s.builtins[index], true
+}
+
+// Builtins lists every builtin in index order.
+func (s *Stdlib) Builtins() []*Builtin {
+	return append([]*Builtin{}, s.builtins...)
 }
 
-// Utility function to check if an object is an error.
-func isError(obj Object) bool {
-	if obj != nil {
-		return obj.Type() == ERROR_OBJ
+func (s *Stdlib) Names() []string {
+	names := []string{}
+	for _, b := range s.builtins {
+		names = append(names, b.Name)
 	}
-	return false
+
+	return names
 }
 
-// Internal Functions
+// Only returns a new Stdlib with just the named builtins, in their current
+// order.
+func (s *Stdlib) Only(names ...string) *Stdlib {
+	keep := map[string]bool{}
+	for _, name := range names {
+		keep[name] = true
+	}
 
-// length of item in runes
-func _lenFn(args ...Object) Object {
-	if len(args) != 1 {
-		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	only := NewStdlib()
+	for _, b := range s.builtins {
+		if keep[b.Name] {
+			only.Register(b)
+		}
+	}
+
+	return only
+}
+
+// Without returns a new Stdlib lacking the named builtins, e.g. a sandbox
+// without `puts`.
+func (s *Stdlib) Without(names ...string) *Stdlib {
+	drop := map[string]bool{}
+	for _, name := range names {
+		drop[name] = true
 	}
+
+	without := NewStdlib()
+	for _, b := range s.builtins {
+		if !drop[b.Name] {
+			without.Register(b)
+		}
+	}
+
+	return without
+}
+
+// Internal Functions. Their arguments are checked against the params they
+// are registered with before they run.
+
+// length of item in runes
+func _lenFn(rt *Runtime, args ...Object) Object {
 	switch arg := args[0].(type) {
 	case *String:
 		return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
-	case *Array:
-		return &Integer{Value: int64(len(arg.Elements))}
 	default:
-		return newError("argument to `len` not supported, got=%s", args[0].Type())
+		return &Integer{Value: int64(arg.(*Array).Len())}
 	}
 }
 
 // length of item but counting bytes individually
-func _unicodeLenFn(args ...Object) Object {
-	if len(args) != 1 {
-		return newError("wrong number of arguments. got=%d, want=1", len(args))
-	}
+func _unicodeLenFn(rt *Runtime, args ...Object) Object {
 	switch arg := args[0].(type) {
 	case *String:
 		return &Integer{Value: int64(len(arg.Value))}
 	default:
-		return newError("argument to `unicodeLen` not supported, got=%s", args[0].Type())
+		return NewError("argument to `unicodeLen` not supported, got=%s", args[0].Type())
 	}
 }
 
 // Return the first element of the given array.
-func _firstFn(args ...Object) Object {
-	if len(args) != 1 {
-		return newError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-	if args[0].Type() != ARRAY_OBJ {
-		return newError(
-			"argument to `first` must be ARRAY, got=%s",
-			args[0].Type(),
-		)
-	}
-
+func _firstFn(rt *Runtime, args ...Object) Object {
 	arr := args[0].(*Array)
-	if len(arr.Elements) > 0 {
-		return arr.Elements[0]
+	if arr.Len() > 0 {
+		return arr.At(0)
 	}
 
 	return nil
 }
 
 // Return the last element of the given array.
-func _lastFn(args ...Object) Object {
-	if len(args) != 1 {
-		return newError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-	if args[0].Type() != ARRAY_OBJ {
-		return newError(
-			"argument to `last` must be ARRAY, got=%s",
-			args[0].Type(),
-		)
+func _lastFn(rt *Runtime, args ...Object) Object {
+	arr := args[0].(*Array)
+	if length := arr.Len(); length > 0 {
+		return arr.At(length - 1)
 	}
 
+	return nil
+}
+
+// All but the first element, sharing them with the given array.
+func _restFn(rt *Runtime, args ...Object) Object {
 	arr := args[0].(*Array)
-	length := len(arr.Elements)
-	if length > 0 {
-		return arr.Elements[length-1]
+	if length := arr.Len(); length > 0 {
+		return arr.Slice(1, length)
 	}
 
 	return nil
 }
 
-func _restFn(args ...Object) Object {
-	if len(args) != 1 {
-		return newError("wrong number of arguments. got=%d, want=1",
-			len(args))
+func _pushFn(rt *Runtime, args ...Object) Object {
+	return args[0].(*Array).Push(args[1])
+}
+
+func _putsFn(rt *Runtime, args ...Object) Object {
+	for _, arg := range args {
+		fmt.Fprintln(rt.Stdout, arg.Inspect())
 	}
-	if args[0].Type() != ARRAY_OBJ {
-		return newError(
-			"argument to `rest` must be ARRAY, got=%s",
-			args[0].Type(),
-		)
+
+	return nil
+}
+
+// Read the next line of input, without its newline, or null once the input
+// is exhausted.
+func _getsFn(rt *Runtime, args ...Object) Object {
+	line, ok := rt.ReadLine()
+	if !ok {
+		return nil
 	}
 
-	arr := args[0].(*Array)
-	length := len(arr.Elements)
-	if length > 0 {
-		newElements := make([]Object, length-1)
-		copy(newElements, arr.Elements[1:length])
+	return &String{Value: line}
+}
+
+// Higher order functions. They apply Monkey functions through the runtime's
+// Caller and stop at the first error one returns.
+
+func _mapFn(rt *Runtime, args ...Object) Object {
+	arr, fn := args[0].(*Array), args[1]
 
-		return &Array{
-			Elements: newElements,
+	elements := make([]Object, 0, arr.Len())
+	for _, element := range arr.Elements() {
+		result := rt.Call(fn, element)
+		if err, ok := result.(*Error); ok {
+			return err
 		}
+		elements = append(elements, result)
 	}
 
-	return nil
+	return NewArray(elements)
+}
+
+func _filterFn(rt *Runtime, args ...Object) Object {
+	arr, fn := args[0].(*Array), args[1]
+
+	elements := []Object{}
+	for _, element := range arr.Elements() {
+		result := rt.Call(fn, element)
+		if err, ok := result.(*Error); ok {
+			return err
+		}
+		if isTruthy(result) {
+			elements = append(elements, element)
+		}
+	}
+
+	return NewArray(elements)
+}
+
+// Fold the array from the left: fn(fn(initial, first), second)...
+func _reduceFn(rt *Runtime, args ...Object) Object {
+	arr, acc, fn := args[0].(*Array), args[1], args[2]
+
+	for _, element := range arr.Elements() {
+		acc = rt.Call(fn, acc, element)
+		if err, ok := acc.(*Error); ok {
+			return err
+		}
+	}
+
+	return acc
 }
 
-func _pushFn(args ...Object) Object {
-	if len(args) != 2 {
-		return newError("wrong number of arguments. got=%d, want=2",
-			len(args))
+// Sort a copy of the array by the key fn returns for each element. Keys must
+// be all integers or all strings; equal keys keep their order.
+func _sortByFn(rt *Runtime, args ...Object) Object {
+	arr, fn := args[0].(*Array), args[1]
+
+	keys := make([]Object, arr.Len())
+	for i, element := range arr.Elements() {
+		key := rt.Call(fn, element)
+		if err, ok := key.(*Error); ok {
+			return err
+		}
+		if !IsInteger(key) && key.Type() != STRING_OBJ ||
+			i > 0 && IsInteger(key) != IsInteger(keys[0]) {
+			return NewError("keys of `sort_by` must be all INTEGER or all STRING, got=%s", key.Type())
+		}
+		keys[i] = key
 	}
-	if args[0].Type() != ARRAY_OBJ {
-		return newError("argument to `push` must be ARRAY, got=%s",
-			args[0].Type())
+
+	order := make([]int, arr.Len())
+	for i := range order {
+		order[i] = i
 	}
 
-	arr := args[0].(*Array)
-	length := len(arr.Elements)
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := keys[order[i]], keys[order[j]]
+		if IsInteger(a) {
+			return CompareIntegers(a, b) < 0
+		}
 
-	newElements := make([]Object, length+1)
-	copy(newElements, arr.Elements)
-	newElements[length] = args[1]
+		return a.(*String).Value < b.(*String).Value
+	})
+
+	elements := make([]Object, len(order))
+	for i, index := range order {
+		elements[i] = arr.At(index)
+	}
 
-	return &Array{Elements: newElements}
+	return NewArray(elements)
 }
 
-func _putsFn(args ...Object) Object {
-	for _, arg := range args {
-		fmt.Println(arg.Inspect())
+func _eachFn(rt *Runtime, args ...Object) Object {
+	arr, fn := args[0].(*Array), args[1]
+
+	for _, element := range arr.Elements() {
+		if err, ok := rt.Call(fn, element).(*Error); ok {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// The integers from start up to, but not including, end.
+func _rangeFn(rt *Runtime, args ...Object) Object {
+	start, end := args[0].(*Integer).Value, args[1].(*Integer).Value
+	if end > start && uint64(end-start) > MaxRangeLength {
+		return NewError("range of more than %d elements", MaxRangeLength)
+	}
+
+	elements := []Object{}
+	for i := start; i < end; i++ {
+		elements = append(elements, &Integer{Value: i})
+	}
+
+	return NewArray(elements)
+}
+
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case nil, *Null:
+		return false
+	case *Boolean:
+		return obj.Value
+	default:
+		return true
+	}
+}
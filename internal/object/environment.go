@@ -31,3 +31,19 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Outer returns the enclosing environment, or nil for the global one.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Bindings returns a copy of the names bound directly in this environment,
+// not including the enclosing ones.
+func (e *Environment) Bindings() map[string]Object {
+	bindings := make(map[string]Object, len(e.store))
+	for name, val := range e.store {
+		bindings[name] = val
+	}
+
+	return bindings
+}
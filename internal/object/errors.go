@@ -0,0 +1,105 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// Runtime errors, both error objects and Go errors, keep the English format
+// they were worded with and its arguments, so they can be shown in the
+// locale a program is written in.
+
+// NewError builds an error object from a format, like fmt.Sprintf.
+func NewError(format string, a ...any) *Error {
+	return &Error{
+		Message: fmt.Sprintf(format, a...),
+		format:  format,
+		args:    a,
+	}
+}
+
+// Localize words the error in locale.
+func (o *Error) Localize(locale *token.Locale) string {
+	if o.format == "" || locale == nil {
+		return o.Message
+	}
+
+	return localizef(locale, o.format, o.args)
+}
+
+// LocalizeObject words obj in locale if it is an error object, and returns
+// anything else as it is.
+func LocalizeObject(obj Object, locale *token.Locale) Object {
+	errObj, ok := obj.(*Error)
+	if !ok || locale == nil || locale == token.English {
+		return obj
+	}
+
+	message := errObj.Localize(locale)
+	if message == errObj.Message {
+		return obj
+	}
+
+	return &Error{Message: message}
+}
+
+// Errorf builds an error from a format, like fmt.Errorf with %w and all.
+func Errorf(format string, a ...any) error {
+	return &formatError{err: fmt.Errorf(format, a...), format: format, args: a}
+}
+
+type formatError struct {
+	err    error
+	format string
+	args   []any
+}
+
+func (e *formatError) Error() string { return e.err.Error() }
+func (e *formatError) Unwrap() error { return errors.Unwrap(e.err) }
+func (e *formatError) Localize(locale *token.Locale) string {
+	return localizef(locale, e.format, e.args)
+}
+
+// Localize words err in locale. Errors from Errorf, and others that know
+// how to word themselves, are translated; the rest are shown as they are.
+func Localize(err error, locale *token.Locale) string {
+	if l, ok := err.(interface{ Localize(*token.Locale) string }); ok && locale != nil {
+		return l.Localize(locale)
+	}
+
+	return err.Error()
+}
+
+// Localized wraps err so it is shown in locale, while errors.Is and
+// errors.As still see err itself.
+func Localized(err error, locale *token.Locale) error {
+	if err == nil || locale == nil || locale == token.English {
+		return err
+	}
+
+	return &localizedError{err: err, locale: locale}
+}
+
+type localizedError struct {
+	err    error
+	locale *token.Locale
+}
+
+func (e *localizedError) Error() string { return Localize(e.err, e.locale) }
+func (e *localizedError) Unwrap() error { return e.err }
+
+// localizef formats a message in locale, wording the errors among its
+// arguments in it too.
+func localizef(locale *token.Locale, format string, args []any) string {
+	localized := make([]any, len(args))
+	for i, arg := range args {
+		if err, ok := arg.(error); ok {
+			arg = Localize(err, locale)
+		}
+		localized[i] = arg
+	}
+
+	return locale.Sprintf(format, localized...)
+}
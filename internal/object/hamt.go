@@ -0,0 +1,175 @@
+package object
+
+import "math/bits"
+
+// hamtNode is a node of a persistent hash array mapped trie, which indexes
+// the pairs of a Hash. Each level takes the next 5 bits of a key's hash to
+// pick a slot, and the bitmap records which of the 32 slots are in use, so
+// only those are stored. Inserting or removing copies one path of nodes and
+// shares everything else.
+type hamtNode struct {
+	bitmap uint32
+	slots  []hamtSlot
+}
+
+// A hamtSlot holds either a child node or a leaf. It's kept to two pointers
+// as every insert copies a node's slots.
+type hamtSlot struct {
+	node *hamtNode
+	leaf *hamtLeaf
+}
+
+// A hamtLeaf holds the entries for one hash. More than one entry means
+// distinct keys whose hashes collide.
+type hamtLeaf struct {
+	hash    uint64
+	entries []hamtEntry
+}
+
+// hamtEntry points a key at the position of its pair in the Hash.
+type hamtEntry struct {
+	key   Object
+	index int
+}
+
+const (
+	hamtBits = 5
+	hamtMask = 1<<hamtBits - 1
+)
+
+var emptyHAMT = &hamtNode{}
+
+// hamtHash folds the type of a HashKey into its value, so an integer and a
+// boolean with the same value fall in different slots.
+func hamtHash(key HashKey) uint64 {
+	// FNV-1a, written out as it runs on every lookup.
+	seed := uint64(14695981039346656037)
+	for i := 0; i < len(key.Type); i++ {
+		seed ^= uint64(key.Type[i])
+		seed *= 1099511628211
+	}
+
+	return key.Value ^ seed
+}
+
+func (n *hamtNode) slot(hash uint64, shift uint) (uint32, int) {
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *hamtNode) find(hash uint64, shift uint, key Object) (int, bool) {
+	bit, pos := n.slot(hash, shift)
+	if n.bitmap&bit == 0 {
+		return 0, false
+	}
+
+	slot := n.slots[pos]
+	if slot.node != nil {
+		return slot.node.find(hash, shift+hamtBits, key)
+	}
+
+	if slot.leaf.hash == hash {
+		for _, entry := range slot.leaf.entries {
+			if Equal(entry.key, key) {
+				return entry.index, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// insert returns a node with key pointing at index, replacing the entry of an
+// equal key if there is one.
+func (n *hamtNode) insert(hash uint64, shift uint, key Object, index int) *hamtNode {
+	bit, pos := n.slot(hash, shift)
+	if n.bitmap&bit == 0 {
+		slots := make([]hamtSlot, len(n.slots)+1)
+		copy(slots, n.slots[:pos])
+		slots[pos] = hamtSlot{leaf: &hamtLeaf{hash: hash, entries: []hamtEntry{{key, index}}}}
+		copy(slots[pos+1:], n.slots[pos:])
+
+		return &hamtNode{bitmap: n.bitmap | bit, slots: slots}
+	}
+
+	slot := n.slots[pos]
+	switch {
+	case slot.node != nil:
+		slot = hamtSlot{node: slot.node.insert(hash, shift+hamtBits, key, index)}
+
+	case slot.leaf.hash == hash:
+		entries := make([]hamtEntry, 0, len(slot.leaf.entries)+1)
+		for _, entry := range slot.leaf.entries {
+			if !Equal(entry.key, key) {
+				entries = append(entries, entry)
+			}
+		}
+		slot = hamtSlot{leaf: &hamtLeaf{hash: hash, entries: append(entries, hamtEntry{key, index})}}
+
+	default:
+		// Two hashes share the bits so far, so push the old one a level down
+		// and try again there. They differ somewhere, so this ends.
+		child := &hamtNode{}
+		childBit, _ := child.slot(slot.leaf.hash, shift+hamtBits)
+		child = &hamtNode{bitmap: childBit, slots: []hamtSlot{slot}}
+		slot = hamtSlot{node: child.insert(hash, shift+hamtBits, key, index)}
+	}
+
+	slots := make([]hamtSlot, len(n.slots))
+	copy(slots, n.slots)
+	slots[pos] = slot
+
+	return &hamtNode{bitmap: n.bitmap, slots: slots}
+}
+
+// remove returns a node without key, or n itself when key isn't there.
+func (n *hamtNode) remove(hash uint64, shift uint, key Object) *hamtNode {
+	bit, pos := n.slot(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n
+	}
+
+	slot := n.slots[pos]
+	switch {
+	case slot.node != nil:
+		child := slot.node.remove(hash, shift+hamtBits, key)
+		if child == slot.node {
+			return n
+		}
+		if child.bitmap != 0 {
+			return n.replace(pos, hamtSlot{node: child})
+		}
+
+	case slot.leaf.hash == hash:
+		entries := make([]hamtEntry, 0, len(slot.leaf.entries))
+		for _, entry := range slot.leaf.entries {
+			if !Equal(entry.key, key) {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == len(slot.leaf.entries) {
+			return n
+		}
+		if len(entries) > 0 {
+			return n.replace(pos, hamtSlot{leaf: &hamtLeaf{hash: hash, entries: entries}})
+		}
+
+	default:
+		return n
+	}
+
+	// The slot is empty now, so drop it.
+	slots := make([]hamtSlot, 0, len(n.slots)-1)
+	slots = append(slots, n.slots[:pos]...)
+	slots = append(slots, n.slots[pos+1:]...)
+
+	return &hamtNode{bitmap: n.bitmap &^ bit, slots: slots}
+}
+
+func (n *hamtNode) replace(pos int, slot hamtSlot) *hamtNode {
+	slots := make([]hamtSlot, len(n.slots))
+	copy(slots, n.slots)
+	slots[pos] = slot
+
+	return &hamtNode{bitmap: n.bitmap, slots: slots}
+}
@@ -0,0 +1,105 @@
+package object
+
+func hashBuiltins() []*Builtin {
+	hash := []ObjectType{HASH_OBJ}
+
+	return []*Builtin{
+		{
+			Name:   "keys",
+			Params: []Param{{"hash", hash}},
+			Fn:     _keysFn,
+		},
+		{
+			Name:   "values",
+			Params: []Param{{"hash", hash}},
+			Fn:     _valuesFn,
+		},
+		{
+			Name:   "entries",
+			Params: []Param{{"hash", hash}},
+			Fn:     _entriesFn,
+		},
+		{
+			Name:   "has",
+			Params: []Param{{"hash", hash}, {"key", nil}},
+			Fn:     _hasFn,
+		},
+		{
+			Name:   "delete",
+			Params: []Param{{"hash", hash}, {"key", nil}},
+			Fn:     _deleteFn,
+		},
+		{
+			Name:   "merge",
+			Params: []Param{{"hash", hash}, {"other", hash}},
+			Fn:     _mergeFn,
+		},
+	}
+}
+
+// The keys in insertion order.
+func _keysFn(rt *Runtime, args ...Object) Object {
+	pairs := args[0].(*Hash).Ordered()
+
+	elements := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = pair.Key
+	}
+
+	return NewArray(elements)
+}
+
+func _valuesFn(rt *Runtime, args ...Object) Object {
+	pairs := args[0].(*Hash).Ordered()
+
+	elements := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = pair.Value
+	}
+
+	return NewArray(elements)
+}
+
+// The pairs as [key, value] arrays.
+func _entriesFn(rt *Runtime, args ...Object) Object {
+	pairs := args[0].(*Hash).Ordered()
+
+	elements := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = NewArray([]Object{pair.Key, pair.Value})
+	}
+
+	return NewArray(elements)
+}
+
+func _hasFn(rt *Runtime, args ...Object) Object {
+	if !IsHashable(args[1]) {
+		return NewError("unusable as hash key: %s", args[1].Type())
+	}
+
+	_, exists := args[0].(*Hash).Get(args[1])
+	return NativeBool(exists)
+}
+
+// A copy of the hash without key. Like push, the original is left alone.
+func _deleteFn(rt *Runtime, args ...Object) Object {
+	if !IsHashable(args[1]) {
+		return NewError("unusable as hash key: %s", args[1].Type())
+	}
+
+	hash := args[0].(*Hash).Copy()
+	hash.Delete(args[1])
+
+	return hash
+}
+
+// A new hash with the pairs of both. Values from other win, keys new to it
+// go after the ones of hash.
+func _mergeFn(rt *Runtime, args ...Object) Object {
+	hash := args[0].(*Hash).Copy()
+	for _, pair := range args[1].(*Hash).Ordered() {
+		hash.Set(pair.Key, pair.Value)
+	}
+
+	return hash
+}
@@ -0,0 +1,142 @@
+package object
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// KeyOf works out the HashKey of a value usable as a hash key: an integer,
+// string or boolean, or an array, hash or struct made only of such values.
+// Those can't change once built, so they are safe as keys.
+func KeyOf(obj Object) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey(), true
+
+	case *Array:
+		h := fnv.New64a()
+		for _, element := range obj.Elements() {
+			key, ok := KeyOf(element)
+			if !ok {
+				return HashKey{}, false
+			}
+			writeKey(h, key)
+		}
+
+		return HashKey{Type: ARRAY_OBJ, Value: h.Sum64()}, true
+
+	case *Hash:
+		// Hashes with the same pairs are equal in any order, so their pairs
+		// are summed rather than hashed in sequence.
+		var sum uint64
+		for _, pair := range obj.Ordered() {
+			key, ok := KeyOf(pair.Key)
+			if !ok {
+				return HashKey{}, false
+			}
+			value, ok := KeyOf(pair.Value)
+			if !ok {
+				return HashKey{}, false
+			}
+
+			h := fnv.New64a()
+			writeKey(h, key)
+			writeKey(h, value)
+			sum += h.Sum64()
+		}
+
+		return HashKey{Type: HASH_OBJ, Value: sum}, true
+
+	case *Struct:
+		h := fnv.New64a()
+		h.Write([]byte(obj.Def.Name))
+		for _, value := range obj.values {
+			key, ok := KeyOf(value)
+			if !ok {
+				return HashKey{}, false
+			}
+			writeKey(h, key)
+		}
+
+		return HashKey{Type: STRUCT_OBJ, Value: h.Sum64()}, true
+
+	default:
+		return HashKey{}, false
+	}
+}
+
+func IsHashable(obj Object) bool {
+	_, ok := KeyOf(obj)
+	return ok
+}
+
+func writeKey(h interface{ Write([]byte) (int, error) }, key HashKey) {
+	h.Write([]byte(key.Type))
+	h.Write(binary.LittleEndian.AppendUint64(nil, key.Value))
+}
+
+// Equal reports whether two values are the same key. Integers, strings and
+// booleans compare by value, arrays, hashes and structs by their contents,
+// and anything else only equals itself.
+func Equal(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value == b.(*Integer).Value
+
+	case *BigInteger:
+		return a.Value.Cmp(b.(*BigInteger).Value) == 0
+
+	case *String:
+		return a.Value == b.(*String).Value
+
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+
+	case *Array:
+		other := b.(*Array)
+		if a.Len() != other.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !Equal(a.At(i), other.At(i)) {
+				return false
+			}
+		}
+
+		return true
+
+	case *Hash:
+		other := b.(*Hash)
+		if a.Len() != other.Len() {
+			return false
+		}
+		for _, pair := range a.Ordered() {
+			value, ok := other.Get(pair.Key)
+			if !ok || !Equal(pair.Value, value) {
+				return false
+			}
+		}
+
+		return true
+
+	case *Struct:
+		other := b.(*Struct)
+		if a.Def != other.Def {
+			return false
+		}
+		for i, value := range a.values {
+			if !Equal(value, other.values[i]) {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return a == b
+	}
+}
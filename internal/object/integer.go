@@ -0,0 +1,152 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// Integer arithmetic shared by both engines. Integers are kept as an Integer
+// while they fit in an int64 and promoted to a BigInteger when they don't, so
+// results never overflow. Results that fit again come back as an Integer, so
+// each value has exactly one representation.
+
+var ErrDivisionByZero = Errorf("division by zero")
+
+// MaxIntegerBits is how large an integer can grow. A few squarings would
+// otherwise build numbers too large to multiply within any time limit, long
+// before an allocation budget sees the result.
+const MaxIntegerBits = 1 << 16
+
+var ErrIntegerTooLarge = Errorf("integer too large: more than %d bits", MaxIntegerBits)
+
+// IsInteger reports whether obj is an Integer or a BigInteger.
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInteger:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewInteger returns v as an Integer if it fits, else as a BigInteger.
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+
+	return &BigInteger{Value: v}
+}
+
+func bigValue(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	default:
+		return obj.(*BigInteger).Value
+	}
+}
+
+// IntegerArithmetic applies +, -, * or / to two integers. Division truncates
+// toward zero, and results over MaxIntegerBits are an error.
+func IntegerArithmetic(operator string, left, right Object) (Object, error) {
+	a, aSmall := left.(*Integer)
+	b, bSmall := right.(*Integer)
+
+	if aSmall && bSmall {
+		if result, ok := smallArithmetic(operator, a.Value, b.Value); ok {
+			return &Integer{Value: result}, nil
+		}
+	}
+
+	x, y := bigValue(left), bigValue(right)
+
+	// Check the size of the result before computing it, from the most bits
+	// it can have.
+	bits := max(x.BitLen(), y.BitLen()) + 1
+	if operator == "*" {
+		bits = x.BitLen() + y.BitLen()
+	}
+	if operator != "/" && bits > MaxIntegerBits {
+		return nil, ErrIntegerTooLarge
+	}
+
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "/":
+		if y.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		result.Quo(x, y)
+	default:
+		return nil, Errorf("unknown integer operator: %s", operator)
+	}
+
+	return NewInteger(result), nil
+}
+
+// smallArithmetic works on int64s, reporting false when the result would
+// overflow or needs the slow path for some other reason.
+func smallArithmetic(operator string, a, b int64) (int64, bool) {
+	switch operator {
+	case "+":
+		sum := a + b
+		return sum, (a >= 0) != (b >= 0) || (sum >= 0) == (a >= 0)
+
+	case "-":
+		diff := a - b
+		return diff, (a >= 0) == (b >= 0) || (diff >= 0) == (a >= 0)
+
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		product := a * b
+		return product, product/b == a && !(a == -1 && b == math.MinInt64) &&
+			!(b == -1 && a == math.MinInt64)
+
+	case "/":
+		if b == 0 || a == math.MinInt64 && b == -1 {
+			return 0, false
+		}
+		return a / b, true
+
+	default:
+		return 0, false
+	}
+}
+
+// CompareIntegers returns -1, 0 or +1 as left is less than, equal to or
+// greater than right.
+func CompareIntegers(left, right Object) int {
+	a, aSmall := left.(*Integer)
+	b, bSmall := right.(*Integer)
+
+	if aSmall && bSmall {
+		switch {
+		case a.Value < b.Value:
+			return -1
+		case a.Value > b.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return bigValue(left).Cmp(bigValue(right))
+}
+
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+
+	return NewInteger(new(big.Int).Neg(bigValue(obj)))
+}
@@ -0,0 +1,57 @@
+package object
+
+import (
+	"io"
+	"os"
+)
+
+// IO is where builtins read their input from and write their output to.
+// Hosts swap it to capture what a program prints.
+type IO struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+}
+
+// StdIO is the standard streams of the process.
+func StdIO() IO {
+	return IO{Stdout: os.Stdout, Stderr: os.Stderr, Stdin: os.Stdin}
+}
+
+// Caller lets a builtin call back into the engine running it, to apply a
+// Monkey function or another builtin. A failed call returns an *Error, which
+// the builtin should hand back as its own result.
+type Caller interface {
+	Call(fn Object, args ...Object) Object
+}
+
+// Runtime is what the running engine lends a builtin when calling it.
+type Runtime struct {
+	IO
+	Caller
+}
+
+// ReadLine reads Stdin up to the next newline, one byte at a time so nothing
+// past the line is consumed. It reports false at the end of the input.
+func (i IO) ReadLine() (string, bool) {
+	if i.Stdin == nil {
+		return "", false
+	}
+
+	var line []byte
+	buf := make([]byte, 1)
+
+	for {
+		n, err := i.Stdin.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				return string(line), true
+			}
+			line = append(line, buf[0])
+		}
+
+		if err != nil {
+			return string(line), len(line) > 0
+		}
+	}
+}
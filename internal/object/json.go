@@ -0,0 +1,253 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// The mapping between Monkey values and JSON: null, booleans, integers of
+// any size, strings and arrays map onto their JSON counterparts, and a hash
+// with string keys maps onto an object with its keys in insertion order.
+// A struct is written as an object of its fields, which decodes as a hash.
+// Nothing else has a JSON form.
+
+// EncodeJSON renders obj as JSON, failing on values without a JSON form.
+func EncodeJSON(obj Object) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, obj, false); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeJSON parses a single JSON value. Numbers must be integers, as Monkey
+// has no others.
+func DecodeJSON(data []byte) (Object, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	obj, err := readJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, Errorf("unexpected data after JSON value")
+	}
+
+	return obj, nil
+}
+
+// JSONValue marshals an Object with the JSON mapping, so API results come out
+// as structured values. Unlike EncodeJSON it never fails: values without a
+// JSON form, such as functions or errors, are written as their Inspect
+// string, and so are hash keys that aren't strings.
+type JSONValue struct {
+	Object Object
+}
+
+func (v JSONValue) MarshalJSON() ([]byte, error) {
+	if v.Object == nil {
+		return []byte("null"), nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, v.Object, true); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, obj Object, lenient bool) error {
+	switch obj := obj.(type) {
+	case *Null:
+		buf.WriteString("null")
+
+	case *Boolean:
+		fmt.Fprintf(buf, "%t", obj.Value)
+
+	case *Integer:
+		fmt.Fprintf(buf, "%d", obj.Value)
+
+	case *BigInteger:
+		buf.WriteString(obj.Value.String())
+
+	case *String:
+		writeJSONString(buf, obj.Value)
+
+	case *Array:
+		buf.WriteByte('[')
+		for i := 0; i < obj.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, obj.At(i), lenient); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	case *Hash:
+		buf.WriteByte('{')
+		for i, pair := range obj.Ordered() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			key, ok := pair.Key.(*String)
+			switch {
+			case ok:
+				writeJSONString(buf, key.Value)
+			case lenient:
+				writeJSONString(buf, pair.Key.Inspect())
+			default:
+				return Errorf("unusable as JSON key: %s", pair.Key.Type())
+			}
+
+			buf.WriteByte(':')
+			if err := writeJSON(buf, pair.Value, lenient); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case *Struct:
+		buf.WriteByte('{')
+		for i, name := range obj.Def.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			writeJSONString(buf, name)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, obj.values[i], lenient); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	default:
+		if !lenient {
+			return Errorf("no JSON form for %s", obj.Type())
+		}
+		writeJSONString(buf, obj.Inspect())
+	}
+
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	// Only strings go through encoding/json, which can't fail on them. HTML
+	// escaping is left to whoever embeds the result.
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+
+	// Encode ends every value with a newline.
+	buf.Truncate(buf.Len() - 1)
+}
+
+// readJSON reads one value token by token, as decoding into a map would lose
+// the order of an object's keys.
+func readJSON(dec *json.Decoder) (Object, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, Errorf("unexpected end of JSON input")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case nil:
+		return NULL, nil
+
+	case bool:
+		return NativeBool(tok), nil
+
+	case json.Number:
+		n, ok := new(big.Int).SetString(tok.String(), 10)
+		if !ok {
+			return nil, Errorf("number %s is not an integer", tok)
+		}
+		return NewInteger(n), nil
+
+	case string:
+		return &String{Value: tok}, nil
+
+	case json.Delim:
+		if tok == '[' {
+			elements := []Object{}
+			for dec.More() {
+				element, err := readJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, element)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+
+			return NewArray(elements), nil
+		}
+
+		hash := NewHash()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&String{Value: key.(string)}, value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		return hash, nil
+	}
+
+	return nil, Errorf("unexpected JSON token %v", tok)
+}
+
+func jsonBuiltins() []*Builtin {
+	return []*Builtin{
+		{
+			Name:   "json_encode",
+			Params: []Param{{"value", nil}},
+			Fn:     _jsonEncodeFn,
+		},
+		{
+			Name:   "json_decode",
+			Params: []Param{{"string", []ObjectType{STRING_OBJ}}},
+			Fn:     _jsonDecodeFn,
+		},
+	}
+}
+
+func _jsonEncodeFn(rt *Runtime, args ...Object) Object {
+	data, err := EncodeJSON(args[0])
+	if err != nil {
+		return NewError("json_encode: %s", err)
+	}
+
+	return &String{Value: string(data)}
+}
+
+func _jsonDecodeFn(rt *Runtime, args ...Object) Object {
+	obj, err := DecodeJSON([]byte(args[0].(*String).Value))
+	if err != nil {
+		return NewError("json_decode: %s", err)
+	}
+
+	return obj
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math/big"
 	"strings"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
@@ -14,6 +15,7 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           ObjectType = "INTEGER"
+	BIG_INTEGER_OBJ       ObjectType = "BIG_INTEGER"
 	STRING_OBJ            ObjectType = "STRING"
 	BOOLEAN_OBJ           ObjectType = "BOOLEAN"
 	RETURN_VALUE_OBJ      ObjectType = "RETURN_VALUE"
@@ -25,6 +27,8 @@ const (
 	COMPILED_FUNCTION_OBJ ObjectType = "COMPILED_FUNCTION"
 	ARRAY_OBJ             ObjectType = "ARRAY"
 	HASH_OBJ              ObjectType = "HASH"
+	STRUCT_TYPE_OBJ       ObjectType = "STRUCT_TYPE"
+	STRUCT_OBJ            ObjectType = "STRUCT"
 )
 
 type Object interface {
@@ -41,6 +45,23 @@ type Hashable interface {
 	HashKey() HashKey
 }
 
+// The null and boolean values are singletons shared by both engines and the
+// builtins, so they can be compared by identity.
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+// NativeBool returns the Boolean singleton for b.
+func NativeBool(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+
+	return FALSE
+}
+
 type Null struct {
 	Value bool
 }
@@ -61,19 +82,47 @@ func (o *Integer) HashKey() HashKey {
 	}
 }
 
+// BigInteger holds an integer too large for an Integer. Arithmetic makes one
+// on overflow and goes back to an Integer once the value fits again.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (o *BigInteger) Type() ObjectType { return BIG_INTEGER_OBJ }
+func (o *BigInteger) Inspect() string  { return o.Value.String() }
+func (o *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	if o.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(o.Value.Bytes())
+
+	return HashKey{
+		Type:  o.Type(),
+		Value: h.Sum64(),
+	}
+}
+
 type String struct {
 	Value string
+
+	// The hash of Value, worked out the first time it's needed.
+	hash   uint64
+	hashed bool
 }
 
 func (o *String) Type() ObjectType { return STRING_OBJ }
 func (o *String) Inspect() string  { return o.Value }
 func (o *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(o.Value))
+	if !o.hashed {
+		h := fnv.New64a()
+		h.Write([]byte(o.Value))
+		o.hash, o.hashed = h.Sum64(), true
+	}
 
 	return HashKey{
 		Type:  o.Type(),
-		Value: h.Sum64(),
+		Value: o.hash,
 	}
 }
 
@@ -107,6 +156,9 @@ func (o *ReturnValue) Inspect() string  { return o.Value.Inspect() }
 
 type Error struct {
 	Message string
+	// What NewError formatted Message from, for Localize.
+	format string
+	args   []any
 }
 
 func (o *Error) Type() ObjectType { return ERROR_OBJ }
@@ -137,10 +189,14 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(rt *Runtime, args ...Object) Object
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Name   string  `json:"name"`
+	Params []Param `json:"params"`
+	// Variadic builtins take any number of arguments of their last param.
+	Variadic bool            `json:"variadic"`
+	Fn       BuiltinFunction `json:"-"`
 }
 
 func (o *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -157,9 +213,17 @@ func (o *Closure) Inspect() string {
 }
 
 type CompiledFunction struct {
+	// Name is empty for anonymous functions.
+	Name          string
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	// SourceMap ties the instructions to the source of the function.
+	SourceMap code.SourceMap
+	// The names of the locals and free variables by index, for showing the
+	// code. Either may be shorter than the function uses, or have gaps.
+	LocalNames []string
+	FreeNames  []string
 }
 
 func (o *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -167,8 +231,86 @@ func (o *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", o)
 }
 
+// Array is an immutable sequence of elements. It is a window onto a
+// persistent vector, so push, rest and slices share the elements they keep
+// with the array they came from instead of copying them.
 type Array struct {
-	Elements []Object
+	elements *vector[Object]
+	offset   int
+	length   int
+
+	// fresh counts the elements this array stored rather than shared, which
+	// is what it costs against an allocation budget.
+	fresh int
+}
+
+// NewArray returns an array of elements, which it doesn't keep.
+func NewArray(elements []Object) *Array {
+	return &Array{
+		elements: newVector(elements),
+		length:   len(elements),
+		fresh:    len(elements),
+	}
+}
+
+func (o *Array) Len() int { return o.length }
+
+// At returns the element at i, which must be in range.
+func (o *Array) At(i int) Object {
+	return o.elements.At(o.offset + i)
+}
+
+// Elements returns a copy of the elements.
+func (o *Array) Elements() []Object {
+	elements := make([]Object, 0, o.length)
+	o.Each(func(_ int, element Object) {
+		elements = append(elements, element)
+	})
+
+	return elements
+}
+
+func (o *Array) Each(fn func(i int, element Object)) {
+	if o.length == 0 {
+		return
+	}
+
+	o.elements.Each(o.offset, o.offset+o.length, func(i int, element Object) {
+		fn(i-o.offset, element)
+	})
+}
+
+// Push returns an array with x added at the end. Arrays that end where
+// their vector does share it; the rest, cut short by a slice, copy.
+func (o *Array) Push(x Object) *Array {
+	if o.elements == nil || o.offset+o.length != o.elements.Len() {
+		return NewArray(append(o.Elements(), x))
+	}
+
+	return &Array{
+		elements: o.elements.Push(x),
+		offset:   o.offset,
+		length:   o.length + 1,
+		fresh:    1,
+	}
+}
+
+// Slice returns the elements from lo up to, but not including, hi, which must
+// satisfy 0 <= lo <= hi <= Len(). A slice shares the vector unless it would
+// use less than half of it, in which case it copies its elements, so it never
+// keeps much more alive than it holds. Taking rest over and over still copies
+// each element only a few times.
+func (o *Array) Slice(lo, hi int) *Array {
+	if o.elements != nil && 2*(hi-lo) < o.elements.Len() {
+		elements := make([]Object, 0, hi-lo)
+		o.elements.Each(o.offset+lo, o.offset+hi, func(_ int, element Object) {
+			elements = append(elements, element)
+		})
+
+		return NewArray(elements)
+	}
+
+	return &Array{elements: o.elements, offset: o.offset + lo, length: hi - lo}
 }
 
 func (o *Array) Type() ObjectType { return ARRAY_OBJ }
@@ -176,9 +318,9 @@ func (o *Array) Inspect() string {
 	var out bytes.Buffer
 
 	elements := []string{}
-	for _, element := range o.Elements {
+	o.Each(func(_ int, element Object) {
 		elements = append(elements, element.Inspect())
-	}
+	})
 
 	out.WriteString("[")
 	out.WriteString(strings.Join(elements, ", "))
@@ -192,8 +334,135 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash is an immutable map that remembers the order its keys were first set
+// in, which is the order it inspects and iterates in. A trie indexes the
+// pairs by HashKey, telling keys whose hashes collide apart with Equal, and
+// the pairs sit in a persistent vector. Both share structure between
+// versions, so Copy is cheap and a copy can be changed with Set and Delete
+// without touching the original.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	index *hamtNode
+	pairs *vector[*HashPair] // nil where a pair was deleted
+	size  int
+
+	// fresh counts the pairs this hash stored rather than shared, like for
+	// an Array.
+	fresh int
+}
+
+func NewHash() *Hash {
+	return &Hash{index: emptyHAMT, pairs: newVector[*HashPair](nil)}
+}
+
+func (h *Hash) Len() int { return h.size }
+
+// Copy returns a hash with the same pairs. Changes to one don't show in the
+// other.
+func (h *Hash) Copy() *Hash {
+	c := *h
+	c.fresh = 0
+
+	return &c
+}
+
+// Get looks up the value for key. It reports false when the key is missing
+// or can't be hashed at all.
+func (h *Hash) Get(key Object) (Object, bool) {
+	hashKey, ok := KeyOf(key)
+	if !ok {
+		return nil, false
+	}
+
+	if pair := h.lookup(hashKey, key); pair != nil {
+		return pair.Value, true
+	}
+
+	return nil, false
+}
+
+// Set adds a pair, or replaces the value of an existing key, which keeps its
+// place. It reports false when key can't be hashed.
+func (h *Hash) Set(key, value Object) bool {
+	hashKey, ok := KeyOf(key)
+	if !ok {
+		return false
+	}
+
+	h.set(hashKey, key, value)
+	return true
+}
+
+func (h *Hash) set(hashKey HashKey, key, value Object) {
+	if h.index == nil {
+		*h = *NewHash()
+	}
+
+	h.fresh++
+	hash := hamtHash(hashKey)
+
+	if i, ok := h.index.find(hash, 0, key); ok {
+		h.pairs = h.pairs.Set(i, &HashPair{Key: h.pairs.At(i).Key, Value: value})
+		return
+	}
+
+	h.index = h.index.insert(hash, 0, key, h.pairs.Len())
+	h.pairs = h.pairs.Push(&HashPair{Key: key, Value: value})
+	h.size++
+}
+
+func (h *Hash) Delete(key Object) {
+	hashKey, ok := KeyOf(key)
+	if !ok || h.index == nil {
+		return
+	}
+
+	hash := hamtHash(hashKey)
+
+	i, ok := h.index.find(hash, 0, key)
+	if !ok {
+		return
+	}
+
+	h.index = h.index.remove(hash, 0, key)
+	h.pairs = h.pairs.Set(i, nil)
+	h.size--
+
+	// Don't let the holes left behind outgrow the pairs still in use.
+	if h.pairs.Len() > 2*h.size+vectorWidth {
+		pairs := h.Ordered()
+		*h = *NewHash()
+		for _, pair := range pairs {
+			h.Set(pair.Key, pair.Value)
+		}
+	}
+}
+
+func (h *Hash) lookup(hashKey HashKey, key Object) *HashPair {
+	if h.index == nil {
+		return nil
+	}
+
+	if i, ok := h.index.find(hamtHash(hashKey), 0, key); ok {
+		return h.pairs.At(i)
+	}
+
+	return nil
+}
+
+// Ordered returns the pairs in insertion order.
+func (h *Hash) Ordered() []HashPair {
+	pairs := make([]HashPair, 0, h.size)
+	if h.pairs == nil {
+		return pairs
+	}
+
+	h.pairs.Each(0, h.pairs.Len(), func(_ int, pair *HashPair) {
+		if pair != nil {
+			pairs = append(pairs, *pair)
+		}
+	})
+
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -201,7 +470,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.Ordered() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
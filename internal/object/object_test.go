@@ -1,6 +1,14 @@
 package object
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -20,3 +28,417 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have different hash keys")
 	}
 }
+
+func TestStdlib(t *testing.T) {
+	stdlib := DefaultStdlib()
+
+	puts, ok := stdlib.Lookup("puts")
+	if !ok {
+		t.Fatalf("puts is not in the default stdlib")
+	}
+	if puts.Signature() != "puts(values...)" {
+		t.Errorf("wrong signature. got=%s", puts.Signature())
+	}
+
+	double := &Builtin{
+		Name:   "len",
+		Params: []Param{{"value", nil}},
+		Fn:     func(rt *Runtime, args ...Object) Object { return &Integer{Value: 2} },
+	}
+	stdlib.Register(double)
+	if b, _ := stdlib.At(0); b != double {
+		t.Errorf("replacing a builtin should keep its index")
+	}
+
+	sandbox := stdlib.Without("puts", "gets")
+	if _, ok := sandbox.Lookup("puts"); ok {
+		t.Errorf("sandbox still has puts")
+	}
+	if _, ok := stdlib.Lookup("puts"); !ok {
+		t.Errorf("Without changed the original stdlib")
+	}
+
+	only := stdlib.Only("push", "first")
+	names := only.Names()
+	if len(names) != 2 || names[0] != "first" || names[1] != "push" {
+		t.Errorf("wrong builtins kept. got=%v", names)
+	}
+	if b, ok := only.At(1); !ok || b.Name != "push" {
+		t.Errorf("builtins should be reindexed. got=%v", b)
+	}
+	if _, ok := only.At(2); ok {
+		t.Errorf("At should fail past the end")
+	}
+
+	full := NewStdlib()
+	for i := range MaxBuiltins {
+		if err := full.Register(&Builtin{Name: fmt.Sprintf("b%d", i)}); err != nil {
+			t.Fatalf("builtin %d: unexpected error: %s", i, err)
+		}
+	}
+	err := full.Register(&Builtin{Name: "one_more"})
+	if err == nil || err.Error() != "can't register one_more: a stdlib holds at most 256 builtins" {
+		t.Errorf("wrong error past MaxBuiltins. got=%v", err)
+	}
+	if err := full.Register(&Builtin{Name: "b0"}); err != nil {
+		t.Errorf("replacing a builtin of a full stdlib: %s", err)
+	}
+}
+
+func TestBuiltinCallChecksArgs(t *testing.T) {
+	stdlib := DefaultStdlib()
+	rt := &Runtime{}
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{"len", []Object{}, "wrong number of arguments. got=0, want=1"},
+		{"len", []Object{&Integer{Value: 1}}, "argument to `len` not supported, got=INTEGER"},
+		{"push", []Object{&Integer{Value: 1}, &Integer{Value: 1}}, "argument to `push` must be ARRAY, got=INTEGER"},
+		{"gets", []Object{&Integer{Value: 1}}, "wrong number of arguments. got=1, want=0"},
+		{"format", []Object{}, "wrong number of arguments. got=0, want at least 1"},
+		{"replace", []Object{&String{Value: "a"}, &String{Value: "b"}}, "wrong number of arguments. got=2, want=3"},
+	}
+
+	for _, tt := range tests {
+		builtin, _ := stdlib.Lookup(tt.name)
+
+		err, ok := builtin.Call(rt, tt.args...).(*Error)
+		if !ok {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+		if err.Message != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err.Message)
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash()
+	for _, key := range []string{"c", "a", "b"} {
+		hash.Set(&String{Value: key}, &Integer{Value: 1})
+	}
+
+	hash.Set(&String{Value: "a"}, &Integer{Value: 2})
+	if got := hash.Inspect(); got != "{c: 1, a: 2, b: 1}" {
+		t.Errorf("replacing a value should keep its place. got=%q", got)
+	}
+
+	hash.Delete(&String{Value: "c"})
+	hash.Set(&String{Value: "c"}, &Integer{Value: 3})
+	if got := hash.Inspect(); got != "{a: 2, b: 1, c: 3}" {
+		t.Errorf("a deleted key should go last when set again. got=%q", got)
+	}
+
+	if hash.Set(NewArray([]Object{&Builtin{}}), NULL) {
+		t.Errorf("arrays of functions should not be usable as keys")
+	}
+}
+
+func TestHashKeyCollisions(t *testing.T) {
+	hash := NewHash()
+	collision := HashKey{Type: STRING_OBJ, Value: 42}
+
+	hash.set(collision, &String{Value: "a"}, &Integer{Value: 1})
+	hash.set(collision, &String{Value: "b"}, &Integer{Value: 2})
+	hash.set(collision, &String{Value: "a"}, &Integer{Value: 3})
+
+	if got := hash.Inspect(); got != "{a: 3, b: 2}" {
+		t.Fatalf("colliding keys should be kept apart. got=%q", got)
+	}
+
+	if pair := hash.lookup(collision, &String{Value: "b"}); pair == nil || pair.Value.Inspect() != "2" {
+		t.Errorf("wrong pair for b. got=%v", pair)
+	}
+	if pair := hash.lookup(collision, &String{Value: "c"}); pair != nil {
+		t.Errorf("c should be missing. got=%v", pair)
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	pair := func(k, v Object) *Hash {
+		h := NewHash()
+		h.Set(k, v)
+		return h
+	}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+
+	tests := []struct {
+		a, b  Object
+		equal bool
+	}{
+		{NewArray([]Object{one, two}), NewArray([]Object{one, two}), true},
+		{NewArray([]Object{one, two}), NewArray([]Object{two, one}), false},
+		{NewArray([]Object{one}), NewArray([]Object{&String{Value: "1"}}), false},
+		{merge(pair(one, two), pair(two, one)), merge(pair(two, one), pair(one, two)), true},
+		{pair(one, two), pair(one, one), false},
+		{NewArray([]Object{pair(one, two)}), NewArray([]Object{pair(one, two)}), true},
+	}
+
+	for i, tt := range tests {
+		keyA, okA := KeyOf(tt.a)
+		keyB, okB := KeyOf(tt.b)
+		if !okA || !okB {
+			t.Fatalf("tests[%d]: keys should be hashable", i)
+		}
+
+		if Equal(tt.a, tt.b) != tt.equal {
+			t.Errorf("tests[%d]: Equal(%s, %s) should be %t", i, tt.a.Inspect(), tt.b.Inspect(), tt.equal)
+		}
+		if tt.equal && keyA != keyB {
+			t.Errorf("tests[%d]: equal keys should hash alike", i)
+		}
+	}
+}
+
+func merge(a, b *Hash) *Hash {
+	return _mergeFn(nil, a, b).(*Hash)
+}
+
+func TestVector(t *testing.T) {
+	for _, n := range []int{0, 1, 31, 32, 33, 1024, 1056, 1057, 33_000} {
+		values := make([]Object, n)
+		for i := range values {
+			values[i] = &Integer{Value: int64(i)}
+		}
+
+		built := newVector(values)
+		pushed := newVector[Object](nil)
+		for _, v := range values {
+			pushed = pushed.Push(v)
+		}
+
+		for _, v := range []*vector[Object]{built, pushed} {
+			if v.Len() != n {
+				t.Fatalf("n=%d: wrong length. got=%d", n, v.Len())
+			}
+			for i := 0; i < n; i++ {
+				if got := v.At(i).(*Integer).Value; got != int64(i) {
+					t.Fatalf("n=%d: wrong value at %d. got=%d", n, i, got)
+				}
+			}
+
+			count := 0
+			v.Each(0, n, func(i int, x Object) {
+				if x.(*Integer).Value != int64(i) {
+					t.Fatalf("n=%d: Each gave %d at %d", n, x.(*Integer).Value, i)
+				}
+				count++
+			})
+			if count != n {
+				t.Fatalf("n=%d: Each visited %d values", n, count)
+			}
+		}
+	}
+}
+
+func TestVectorPersistence(t *testing.T) {
+	v := newVector[Object](nil)
+	for i := 0; i < 2000; i++ {
+		v = v.Push(&Integer{Value: int64(i)})
+	}
+
+	longer := v.Push(&Integer{Value: -1})
+	changed := v.Set(100, &Integer{Value: -2}).Set(1999, &Integer{Value: -3})
+
+	if v.Len() != 2000 || longer.Len() != 2001 {
+		t.Fatalf("Push changed the original vector")
+	}
+	if v.At(100).Inspect() != "100" || v.At(1999).Inspect() != "1999" {
+		t.Errorf("Set changed the original vector")
+	}
+	if changed.At(100).Inspect() != "-2" || changed.At(1999).Inspect() != "-3" {
+		t.Errorf("Set didn't change the new vector")
+	}
+	if longer.At(2000).Inspect() != "-1" {
+		t.Errorf("wrong pushed value. got=%s", longer.At(2000).Inspect())
+	}
+}
+
+func TestArraySharing(t *testing.T) {
+	arr := NewArray([]Object{&Integer{Value: 1}, &Integer{Value: 2}, &Integer{Value: 3}})
+
+	rest := arr.Slice(1, arr.Len())
+	pushed := rest.Push(&Integer{Value: 4})
+	middle := arr.Slice(0, 2).Push(&Integer{Value: 5})
+
+	tests := []struct {
+		arr      *Array
+		expected string
+		fresh    int
+	}{
+		{arr, "[1, 2, 3]", 3},
+		{rest, "[2, 3]", 0},
+		{pushed, "[2, 3, 4]", 1},
+		{middle, "[1, 2, 5]", 3},
+	}
+
+	for _, tt := range tests {
+		if tt.arr.Inspect() != tt.expected {
+			t.Errorf("wrong elements. want=%s, got=%s", tt.expected, tt.arr.Inspect())
+		}
+		if tt.arr.fresh != tt.fresh {
+			t.Errorf("%s: wrong fresh count. want=%d, got=%d", tt.expected, tt.fresh, tt.arr.fresh)
+		}
+	}
+
+	elements := make([]Object, 100)
+	for i := range elements {
+		elements[i] = &Integer{Value: int64(i)}
+	}
+	long := NewArray(elements)
+
+	if half := long.Slice(50, 100); half.elements != long.elements {
+		t.Errorf("a slice of half the array should share it")
+	}
+
+	tail := long.Slice(90, 100)
+	if tail.elements.Len() != 10 || tail.fresh != 10 {
+		t.Errorf("a short slice should copy. got %d of %d elements, %d fresh",
+			tail.Len(), tail.elements.Len(), tail.fresh)
+	}
+	if tail.At(0).Inspect() != "90" || tail.At(9).Inspect() != "99" {
+		t.Errorf("wrong elements. got=%s", tail.Inspect())
+	}
+}
+
+func TestHashPersistence(t *testing.T) {
+	hash := NewHash()
+	for i := 0; i < 5000; i++ {
+		hash.Set(&Integer{Value: int64(i)}, &Integer{Value: int64(i * i)})
+	}
+
+	copied := hash.Copy()
+	for i := 0; i < 5000; i += 2 {
+		copied.Delete(&Integer{Value: int64(i)})
+	}
+	copied.Set(&Integer{Value: 1}, &String{Value: "one"})
+
+	if hash.Len() != 5000 || copied.Len() != 2500 {
+		t.Fatalf("wrong lengths. got=%d and %d", hash.Len(), copied.Len())
+	}
+
+	for i := 0; i < 5000; i++ {
+		key := &Integer{Value: int64(i)}
+
+		value, ok := hash.Get(key)
+		if !ok || value.(*Integer).Value != int64(i*i) {
+			t.Fatalf("original lost %d", i)
+		}
+
+		_, ok = copied.Get(key)
+		if ok != (i%2 == 1) {
+			t.Fatalf("copy has %d: %t", i, ok)
+		}
+	}
+
+	pairs := copied.Ordered()
+	if pairs[0].Value.Inspect() != "one" || pairs[1].Key.Inspect() != "3" {
+		t.Errorf("copy lost its order. got=%s, %s", pairs[0].Value.Inspect(), pairs[1].Key.Inspect())
+	}
+	if copied.pairs.Len() > 2*copied.Len()+vectorWidth {
+		t.Errorf("deleted pairs weren't compacted. %d slots for %d pairs", copied.pairs.Len(), copied.Len())
+	}
+}
+
+func TestJSONValue(t *testing.T) {
+	hash := NewHash()
+	hash.Set(&String{Value: "name"}, &String{Value: "monkey"})
+	hash.Set(&Integer{Value: 1}, NewArray([]Object{&Builtin{}, NULL}))
+
+	tests := []struct {
+		value    Object
+		expected string
+	}{
+		{nil, `null`},
+		{TRUE, `true`},
+		{&Error{Message: "oops"}, `"ERROR: oops"`},
+		{hash, `{"name":"monkey","1":["builtin function",null]}`},
+	}
+
+	for _, tt := range tests {
+		data, err := json.Marshal(JSONValue{Object: tt.value})
+		if err != nil {
+			t.Fatalf("marshal failed: %s", err)
+		}
+		if string(data) != tt.expected {
+			t.Errorf("wrong JSON. want=%s, got=%s", tt.expected, data)
+		}
+	}
+
+	if _, err := EncodeJSON(hash); err == nil {
+		t.Errorf("EncodeJSON should reject INTEGER keys")
+	}
+}
+
+func TestPreview(t *testing.T) {
+	big := make([]Object, 100_000)
+	for i := range big {
+		big[i] = &Integer{Value: int64(i)}
+	}
+	hash := NewHash()
+	hash.Set(&String{Value: "xs"}, NewArray(big))
+	hash.Set(&Integer{Value: 1}, &String{Value: "héllo"})
+	point := &StructType{Name: "Point", Fields: []string{"x", "y"}}
+	p, _ := point.New([]Object{NewArray(big[:3]), &String{Value: "ñññ"}})
+
+	values := []Object{
+		NULL,
+		&Integer{Value: 42},
+		&String{Value: strings.Repeat("ñ", 300)},
+		NewArray(big),
+		NewArray(big[:5]),
+		hash,
+		p,
+		&ReturnValue{Value: NewArray(big[:50])},
+	}
+
+	for _, value := range values {
+		full := value.Inspect()
+		for _, max := range []int{5, 10, 17, 200} {
+			want := full
+			if len(want) > max {
+				want = strings.ToValidUTF8(full[:max-3], "") + "..."
+			}
+
+			if got := Preview(value, max); got != want {
+				t.Errorf("wrong preview of %.20s in %d bytes. want=%q, got=%q", full, max, want, got)
+			}
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{ErrDivisionByZero, "división por cero"},
+		{&BudgetError{Limit: "allocs", Max: 10}, "presupuesto de ejecución agotado: se alcanzó el límite de 10 asignaciones"},
+		{Errorf("vm error: %w", ErrIntegerTooLarge), "error de la vm: entero demasiado grande: más de 65536 bits"},
+		{Errorf("%s has no field `%s`", "Point", "z"), "Point no tiene el campo `z`"},
+		{errors.New("not from Errorf"), "not from Errorf"},
+	}
+
+	for _, tt := range tests {
+		if got := Localize(tt.err, token.Spanish); got != tt.expected {
+			t.Errorf("wrong message. want=%q, got=%q", tt.expected, got)
+		}
+		if got := Localize(tt.err, token.English); got != tt.err.Error() {
+			t.Errorf("English should be left as is. want=%q, got=%q", tt.err.Error(), got)
+		}
+	}
+
+	err := Localized(Errorf("vm error: %w", &BudgetError{Limit: "steps", Max: 5}), token.Spanish)
+	want := "error de la vm: presupuesto de ejecución agotado: se alcanzó el límite de 5 pasos"
+	if !errors.Is(err, ErrBudgetExceeded) || err.Error() != want {
+		t.Errorf("wrong localized error. want=%q, got=%v", want, err)
+	}
+
+	obj := LocalizeObject(NewError("identifier not found: %s", "x"), token.Spanish)
+	if obj.Inspect() != "ERROR: identificador no encontrado: x" {
+		t.Errorf("wrong error object. got=%s", obj.Inspect())
+	}
+}
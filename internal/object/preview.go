@@ -0,0 +1,77 @@
+package object
+
+import "strings"
+
+// Preview shows obj the way Inspect does in at most max bytes, cutting the
+// rest and marking the cut with "...". Arrays, hashes and structs are only
+// formatted as far as the cut, so a preview costs little however large obj
+// is.
+func Preview(obj Object, max int) string {
+	var b strings.Builder
+	writePreview(&b, obj, max+1)
+
+	s := b.String()
+	if len(s) <= max {
+		return s
+	}
+
+	return strings.ToValidUTF8(s[:max-3], "") + "..."
+}
+
+// writePreview writes obj to b until b holds at least limit bytes.
+func writePreview(b *strings.Builder, obj Object, limit int) {
+	if b.Len() >= limit {
+		return
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		b.WriteString("[")
+		for i := 0; i < obj.Len() && b.Len() < limit; i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePreview(b, obj.At(i), limit)
+		}
+		b.WriteString("]")
+
+	case *Hash:
+		b.WriteString("{")
+		for i, pair := range obj.Ordered() {
+			if b.Len() >= limit {
+				break
+			}
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePreview(b, pair.Key, limit)
+			b.WriteString(": ")
+			writePreview(b, pair.Value, limit)
+		}
+		b.WriteString("}")
+
+	case *Struct:
+		b.WriteString(obj.Def.Name)
+		b.WriteString("{")
+		for i, name := range obj.Def.Fields {
+			if b.Len() >= limit {
+				break
+			}
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(name + ": ")
+			writePreview(b, obj.values[i], limit)
+		}
+		b.WriteString("}")
+
+	case *String:
+		b.WriteString(obj.Value[:min(len(obj.Value), limit-b.Len())])
+
+	case *ReturnValue:
+		writePreview(b, obj.Value, limit)
+
+	default:
+		b.WriteString(obj.Inspect())
+	}
+}
@@ -0,0 +1,77 @@
+package object
+
+// ResolveIndex maps idx onto a sequence of the given length. Negative indexes
+// count back from the end, so -1 is the last element. It reports false when
+// idx falls outside the sequence.
+func ResolveIndex(idx int64, length int) (int, bool) {
+	if idx < 0 {
+		idx += int64(length)
+	}
+	if idx < 0 || idx >= int64(length) {
+		return 0, false
+	}
+
+	return int(idx), true
+}
+
+// Slice takes the elements of an array, which it shares, or the runes of a
+// string, from start up to but not including end. A NULL bound stands for the start or end of
+// the sequence. Negative bounds count back from the end, and bounds outside
+// the sequence are clamped to it, so slicing never fails on range; a start
+// past the end gives an empty result.
+func Slice(left, start, end Object) (Object, error) {
+	switch left := left.(type) {
+	case *Array:
+		lo, hi, err := sliceBounds(start, end, left.Len())
+		if err != nil {
+			return nil, err
+		}
+
+		return left.Slice(lo, hi), nil
+
+	case *String:
+		runes := []rune(left.Value)
+
+		lo, hi, err := sliceBounds(start, end, len(runes))
+		if err != nil {
+			return nil, err
+		}
+
+		return &String{Value: string(runes[lo:hi])}, nil
+
+	default:
+		return nil, Errorf("slice operator not supported: %s", left.Type())
+	}
+}
+
+func sliceBounds(start, end Object, length int) (int, int, error) {
+	lo, err := sliceBound(start, 0, length)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	hi, err := sliceBound(end, length, length)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return lo, max(lo, hi), nil
+}
+
+func sliceBound(bound Object, missing, length int) (int, error) {
+	switch bound := bound.(type) {
+	case *Null:
+		return missing, nil
+
+	case *Integer:
+		idx := bound.Value
+		if idx < 0 {
+			idx += int64(length)
+		}
+
+		return int(min(max(idx, 0), int64(length))), nil
+
+	default:
+		return 0, Errorf("slice bound must be INTEGER, got=%s", bound.Type())
+	}
+}
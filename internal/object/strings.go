@@ -0,0 +1,245 @@
+package object
+
+import (
+	"math/big"
+	"strings"
+)
+
+// IndexString returns the rune at idx as a one character string, or NULL when
+// idx is out of range. Both engines index strings through it.
+func IndexString(str *String, idx int64) Object {
+	runes := []rune(str.Value)
+
+	i, ok := ResolveIndex(idx, len(runes))
+	if !ok {
+		return NULL
+	}
+
+	return &String{Value: string(runes[i])}
+}
+
+func stringBuiltins() []*Builtin {
+	str := []ObjectType{STRING_OBJ}
+	integer := []ObjectType{INTEGER_OBJ}
+
+	return []*Builtin{
+		{
+			Name:   "split",
+			Params: []Param{{"string", str}, {"separator", str}},
+			Fn:     _splitFn,
+		},
+		{
+			Name:   "join",
+			Params: []Param{{"array", []ObjectType{ARRAY_OBJ}}, {"separator", str}},
+			Fn:     _joinFn,
+		},
+		{
+			Name:   "trim",
+			Params: []Param{{"string", str}},
+			Fn:     _trimFn,
+		},
+		{
+			Name:   "upper",
+			Params: []Param{{"string", str}},
+			Fn:     _upperFn,
+		},
+		{
+			Name:   "lower",
+			Params: []Param{{"string", str}},
+			Fn:     _lowerFn,
+		},
+		{
+			Name:   "contains",
+			Params: []Param{{"string", str}, {"substring", str}},
+			Fn:     _containsFn,
+		},
+		{
+			Name:   "replace",
+			Params: []Param{{"string", str}, {"old", str}, {"new", str}},
+			Fn:     _replaceFn,
+		},
+		{
+			Name:   "index_of",
+			Params: []Param{{"string", str}, {"substring", str}},
+			Fn:     _indexOfFn,
+		},
+		{
+			Name:   "substr",
+			Params: []Param{{"string", str}, {"start", integer}, {"length", integer}},
+			Fn:     _substrFn,
+		},
+		{
+			Name:   "chars",
+			Params: []Param{{"string", str}},
+			Fn:     _charsFn,
+		},
+		{
+			Name:     "format",
+			Params:   []Param{{"template", str}, {"values", nil}},
+			Variadic: true,
+			Fn:       _formatFn,
+		},
+		{
+			Name:   "to_int",
+			Params: []Param{{"value", []ObjectType{STRING_OBJ, INTEGER_OBJ, BIG_INTEGER_OBJ}}},
+			Fn:     _toIntFn,
+		},
+		{
+			Name:   "to_string",
+			Params: []Param{{"value", nil}},
+			Fn:     _toStringFn,
+		},
+	}
+}
+
+// Split around every separator. An empty separator splits into characters.
+func _splitFn(rt *Runtime, args ...Object) Object {
+	parts := strings.Split(args[0].(*String).Value, args[1].(*String).Value)
+
+	elements := make([]Object, len(parts))
+	for i, part := range parts {
+		elements[i] = &String{Value: part}
+	}
+
+	return NewArray(elements)
+}
+
+func _joinFn(rt *Runtime, args ...Object) Object {
+	arr, sep := args[0].(*Array), args[1].(*String).Value
+
+	parts := make([]string, arr.Len())
+	for i, element := range arr.Elements() {
+		s, ok := element.(*String)
+		if !ok {
+			return NewError("argument to `join` must be ARRAY of STRING, got=%s at index %d",
+				element.Type(), i)
+		}
+		parts[i] = s.Value
+	}
+
+	return &String{Value: strings.Join(parts, sep)}
+}
+
+func _trimFn(rt *Runtime, args ...Object) Object {
+	return &String{Value: strings.TrimSpace(args[0].(*String).Value)}
+}
+
+func _upperFn(rt *Runtime, args ...Object) Object {
+	return &String{Value: strings.ToUpper(args[0].(*String).Value)}
+}
+
+func _lowerFn(rt *Runtime, args ...Object) Object {
+	return &String{Value: strings.ToLower(args[0].(*String).Value)}
+}
+
+func _containsFn(rt *Runtime, args ...Object) Object {
+	return NativeBool(strings.Contains(args[0].(*String).Value, args[1].(*String).Value))
+}
+
+// Replace every occurrence of old.
+func _replaceFn(rt *Runtime, args ...Object) Object {
+	s, old, new := args[0].(*String).Value, args[1].(*String).Value, args[2].(*String).Value
+	return &String{Value: strings.ReplaceAll(s, old, new)}
+}
+
+// Index in runes of the first occurrence of substring, or -1.
+func _indexOfFn(rt *Runtime, args ...Object) Object {
+	s, sub := args[0].(*String).Value, args[1].(*String).Value
+
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return &Integer{Value: -1}
+	}
+
+	return &Integer{Value: int64(len([]rune(s[:i])))}
+}
+
+// Up to length runes starting at rune start. Bounds past either end are
+// clamped, so the result is never longer than the string.
+func _substrFn(rt *Runtime, args ...Object) Object {
+	runes := []rune(args[0].(*String).Value)
+	start, length := args[1].(*Integer).Value, args[2].(*Integer).Value
+	size := int64(len(runes))
+
+	start = min(max(start, 0), size)
+	end := start
+	if length > 0 {
+		end = start + min(length, size-start)
+	}
+
+	return &String{Value: string(runes[start:end])}
+}
+
+func _charsFn(rt *Runtime, args ...Object) Object {
+	runes := []rune(args[0].(*String).Value)
+
+	elements := make([]Object, len(runes))
+	for i, r := range runes {
+		elements[i] = &String{Value: string(r)}
+	}
+
+	return NewArray(elements)
+}
+
+// Fill each {} in the template with the next value. Strings go in as they
+// are, anything else as it inspects. {{ and }} stand for literal braces.
+func _formatFn(rt *Runtime, args ...Object) Object {
+	template, values := args[0].(*String).Value, args[1:]
+
+	var out strings.Builder
+	next := 0
+	for i := 0; i < len(template); i++ {
+		switch {
+		case strings.HasPrefix(template[i:], "{{"), strings.HasPrefix(template[i:], "}}"):
+			out.WriteByte(template[i])
+			i++
+		case strings.HasPrefix(template[i:], "{}"):
+			if next >= len(values) {
+				return NewError("not enough values for `format`: got=%d", len(values))
+			}
+			out.WriteString(stringValue(values[next]))
+			next++
+			i++
+		default:
+			out.WriteByte(template[i])
+		}
+	}
+
+	if next < len(values) {
+		return NewError("too many values for `format`: want=%d, got=%d", next, len(values))
+	}
+
+	return &String{Value: out.String()}
+}
+
+// Parse a base 10 integer, ignoring surrounding whitespace. Returns null when
+// the string isn't one, so input can be checked with an if.
+func _toIntFn(rt *Runtime, args ...Object) Object {
+	s, ok := args[0].(*String)
+	if !ok {
+		return args[0]
+	}
+
+	value, ok := new(big.Int).SetString(strings.TrimSpace(s.Value), 10)
+	if !ok {
+		return NULL
+	}
+
+	return NewInteger(value)
+}
+
+func _toStringFn(rt *Runtime, args ...Object) Object {
+	if s, ok := args[0].(*String); ok {
+		return s
+	}
+
+	return &String{Value: stringValue(args[0])}
+}
+
+func stringValue(obj Object) string {
+	if s, ok := obj.(*String); ok {
+		return s.Value
+	}
+
+	return obj.Inspect()
+}
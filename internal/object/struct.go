@@ -0,0 +1,109 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// StructType is what a `struct Point { x, y }` declaration binds its name
+// to. Calling it with a value for each field, in order, builds a Struct.
+type StructType struct {
+	Name   string
+	Fields []string
+}
+
+func (t *StructType) Type() ObjectType { return STRUCT_TYPE_OBJ }
+func (t *StructType) Inspect() string {
+	return fmt.Sprintf("struct %s { %s }", t.Name, strings.Join(t.Fields, ", "))
+}
+
+// New builds an instance from one value per field.
+func (t *StructType) New(args []Object) (*Struct, error) {
+	if len(args) != len(t.Fields) {
+		return nil, Errorf("wrong number of arguments: want=%d, got=%d",
+			len(t.Fields), len(args))
+	}
+
+	values := make([]Object, len(args))
+	copy(values, args)
+
+	return &Struct{Def: t, values: values}, nil
+}
+
+func (t *StructType) field(name string) (int, bool) {
+	for i, field := range t.Fields {
+		if field == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Struct is an instance of a StructType. Like arrays and hashes it never
+// changes: `with` builds a copy.
+type Struct struct {
+	Def    *StructType
+	values []Object
+}
+
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+func (s *Struct) Inspect() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for i, name := range s.Def.Fields {
+		fields = append(fields, name+": "+s.values[i].Inspect())
+	}
+
+	out.WriteString(s.Def.Name)
+	out.WriteString("{")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Values returns the field values in declaration order.
+func (s *Struct) Values() []Object {
+	values := make([]Object, len(s.values))
+	copy(values, s.values)
+
+	return values
+}
+
+// GetField reads the field name of a struct, which is what `p.x` does.
+func GetField(obj Object, name string) (Object, error) {
+	s, ok := obj.(*Struct)
+	if !ok {
+		return nil, Errorf("field access not supported: %s", obj.Type())
+	}
+
+	i, ok := s.Def.field(name)
+	if !ok {
+		return nil, Errorf("%s has no field `%s`", s.Def.Name, name)
+	}
+
+	return s.values[i], nil
+}
+
+// With returns a copy of a struct with the named fields replaced, which is
+// what `p with { x: 1 }` does.
+func With(obj Object, names []string, values []Object) (Object, error) {
+	s, ok := obj.(*Struct)
+	if !ok {
+		return nil, Errorf("`with` not supported: %s", obj.Type())
+	}
+
+	updated := s.Values()
+	for j, name := range names {
+		i, ok := s.Def.field(name)
+		if !ok {
+			return nil, Errorf("%s has no field `%s`", s.Def.Name, name)
+		}
+		updated[i] = values[j]
+	}
+
+	return &Struct{Def: s.Def, values: updated}, nil
+}
@@ -0,0 +1,171 @@
+package object
+
+// vector is a persistent vector: a trie of 32-way nodes holding all but the
+// last few values, which sit in a separate tail. Pushing or replacing a value
+// copies one path through the trie at most and shares the rest, so older
+// versions stay valid and cheap to keep around.
+type vector[T any] struct {
+	count int
+	shift uint
+	root  *vectorNode[T]
+	tail  []T
+}
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+// A vectorNode is a branch with children or, at the bottom of the trie, a
+// leaf with values.
+type vectorNode[T any] struct {
+	children []*vectorNode[T]
+	values   []T
+}
+
+// newVector builds a vector holding values, which it doesn't keep.
+func newVector[T any](values []T) *vector[T] {
+	v := &vector[T]{shift: vectorBits, root: &vectorNode[T]{}}
+
+	// Fill the trie a whole leaf at a time, leaving at least one value over
+	// for the tail.
+	for len(values) > vectorWidth {
+		leaf := make([]T, vectorWidth)
+		copy(leaf, values)
+		v = v.pushLeaf(leaf)
+		values = values[vectorWidth:]
+	}
+
+	tail := make([]T, len(values))
+	copy(tail, values)
+
+	return &vector[T]{count: v.count + len(tail), shift: v.shift, root: v.root, tail: tail}
+}
+
+func (v *vector[T]) Len() int { return v.count }
+
+// tailOffset is the index of the first value in the tail.
+func (v *vector[T]) tailOffset() int {
+	if v.count < vectorWidth {
+		return 0
+	}
+
+	return ((v.count - 1) >> vectorBits) << vectorBits
+}
+
+func (v *vector[T]) leafFor(i int) []T {
+	if i >= v.tailOffset() {
+		return v.tail
+	}
+
+	node := v.root
+	for level := v.shift; level > 0; level -= vectorBits {
+		node = node.children[(i>>level)&vectorMask]
+	}
+
+	return node.values
+}
+
+// At returns the value at i, which must be in range.
+func (v *vector[T]) At(i int) T {
+	return v.leafFor(i)[i&vectorMask]
+}
+
+// Push returns a vector with x added at the end.
+func (v *vector[T]) Push(x T) *vector[T] {
+	if len(v.tail) < vectorWidth {
+		tail := make([]T, len(v.tail)+1)
+		copy(tail, v.tail)
+		tail[len(v.tail)] = x
+
+		return &vector[T]{count: v.count + 1, shift: v.shift, root: v.root, tail: tail}
+	}
+
+	trie := &vector[T]{count: v.count - len(v.tail), shift: v.shift, root: v.root}
+	full := trie.pushLeaf(v.tail)
+
+	return &vector[T]{count: full.count + 1, shift: full.shift, root: full.root, tail: []T{x}}
+}
+
+// pushLeaf adds a full leaf to the trie, growing it by a level when the root
+// is full. It ignores the tail, so count must only cover the trie.
+func (v *vector[T]) pushLeaf(values []T) *vector[T] {
+	leaf := &vectorNode[T]{values: values}
+	count := v.count + vectorWidth
+
+	if (v.count >> vectorBits) >= 1<<v.shift {
+		root := &vectorNode[T]{children: []*vectorNode[T]{v.root, newVectorPath(v.shift, leaf)}}
+		return &vector[T]{count: count, shift: v.shift + vectorBits, root: root}
+	}
+
+	return &vector[T]{count: count, shift: v.shift, root: v.pushTail(v.shift, v.root, leaf)}
+}
+
+func (v *vector[T]) pushTail(level uint, parent, leaf *vectorNode[T]) *vectorNode[T] {
+	i := (v.count >> level) & vectorMask
+	children := make([]*vectorNode[T], i+1)
+	copy(children, parent.children)
+
+	switch {
+	case level == vectorBits:
+		children[i] = leaf
+	case i < len(parent.children):
+		children[i] = v.pushTail(level-vectorBits, parent.children[i], leaf)
+	default:
+		children[i] = newVectorPath(level-vectorBits, leaf)
+	}
+
+	return &vectorNode[T]{children: children}
+}
+
+func newVectorPath[T any](level uint, leaf *vectorNode[T]) *vectorNode[T] {
+	if level == 0 {
+		return leaf
+	}
+
+	return &vectorNode[T]{children: []*vectorNode[T]{newVectorPath(level-vectorBits, leaf)}}
+}
+
+// Set returns a vector with the value at i, which must be in range, replaced
+// by x.
+func (v *vector[T]) Set(i int, x T) *vector[T] {
+	if i >= v.tailOffset() {
+		tail := make([]T, len(v.tail))
+		copy(tail, v.tail)
+		tail[i&vectorMask] = x
+
+		return &vector[T]{count: v.count, shift: v.shift, root: v.root, tail: tail}
+	}
+
+	return &vector[T]{count: v.count, shift: v.shift, root: setVectorPath(v.root, v.shift, i, x), tail: v.tail}
+}
+
+func setVectorPath[T any](node *vectorNode[T], level uint, i int, x T) *vectorNode[T] {
+	if level == 0 {
+		values := make([]T, len(node.values))
+		copy(values, node.values)
+		values[i&vectorMask] = x
+
+		return &vectorNode[T]{values: values}
+	}
+
+	children := make([]*vectorNode[T], len(node.children))
+	copy(children, node.children)
+	j := (i >> level) & vectorMask
+	children[j] = setVectorPath(children[j], level-vectorBits, i, x)
+
+	return &vectorNode[T]{children: children}
+}
+
+// Each calls fn with the values from lo up to, but not including, hi, a leaf
+// at a time rather than walking the trie for every value.
+func (v *vector[T]) Each(lo, hi int, fn func(i int, x T)) {
+	for i := lo; i < hi; {
+		leaf := v.leafFor(i)
+		for j := i & vectorMask; j < len(leaf) && i < hi; j++ {
+			fn(i, leaf[j])
+			i++
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package parser
 
 import (
-	"fmt"
+	"math/big"
 	"strconv"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
@@ -10,38 +10,84 @@ import (
 
 type BindingPower int
 
+// Binding powers are spaced out so user-declared operators can sit between
+// the built-in levels, e.g. `infixl 45 <+>` binds tighter than `+` and looser
+// than `*`.
 const (
-	_ BindingPower = iota
+	_ BindingPower = iota * 10
 	LOWEST
 	EQUALS       // ==
 	LESS_GREATER // > or <
 	SUM          // +
 	PRODUCT      // *
 	PREFIX       // -X or !X
-	CALL         // myFunction(X)
-	INDEX        // array[index]
+	CALL         // myFunction(X) or p with { x: 1 }
+	INDEX        // array[index] or p.x
 )
 
-var precedences = map[token.TokenType]BindingPower{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESS_GREATER,
-	token.RT:       LESS_GREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+type Associativity string
+
+const (
+	LeftAssoc  Associativity = "left"
+	RightAssoc Associativity = "right"
+)
+
+// Operator describes how tightly an infix operator binds and which way it
+// groups when chained: `a - b - c` is left associative, `(a - b) - c`.
+type Operator struct {
+	Precedence    BindingPower  `json:"precedence"`
+	Associativity Associativity `json:"associativity"`
+}
+
+type OperatorTable map[token.TokenType]Operator
+
+var defaultOperators = OperatorTable{
+	token.EQ:       {EQUALS, LeftAssoc},
+	token.NOT_EQ:   {EQUALS, LeftAssoc},
+	token.LT:       {LESS_GREATER, LeftAssoc},
+	token.RT:       {LESS_GREATER, LeftAssoc},
+	token.PLUS:     {SUM, LeftAssoc},
+	token.MINUS:    {SUM, LeftAssoc},
+	token.SLASH:    {PRODUCT, LeftAssoc},
+	token.ASTERISK: {PRODUCT, LeftAssoc},
+	token.LPAREN:   {CALL, LeftAssoc},
+	token.LBRACKET: {INDEX, LeftAssoc},
+	token.DOT:      {INDEX, LeftAssoc},
+	token.WITH:     {CALL, LeftAssoc},
+}
+
+// DefaultOperators returns a copy of the built-in operator table, ready to
+// be tweaked and handed to NewWithOperators.
+func DefaultOperators() OperatorTable {
+	table := OperatorTable{}
+	for tokenType, op := range defaultOperators {
+		table[tokenType] = op
+	}
+
+	return table
 }
 
 func (p *Parser) parseExpression(precedence BindingPower) ast.Expression {
+	if p.depth == MaxNesting {
+		p.errorf("expressions can't be nested more than %d deep", MaxNesting)
+		// Give up on the rest of the input rather than reporting the same
+		// error for every level left.
+		for !p.peekTokenIs(token.EOF) {
+			p.nextToken()
+		}
+		return nil
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFn(p.curToken.Type)
 		return nil
 	}
+	start := p.curToken.Start
 	leftExp := prefix()
+	p.setSpan(leftExp, start)
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
@@ -52,6 +98,7 @@ func (p *Parser) parseExpression(precedence BindingPower) ast.Expression {
 		p.nextToken()
 
 		leftExp = infix(leftExp)
+		p.setSpan(leftExp, start)
 	}
 
 	return leftExp
@@ -71,13 +118,19 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+	if err == nil {
+		lit.Value = value
+		return lit
+	}
+
+	// Too big for an int64, so it becomes a big integer instead.
+	n, ok := new(big.Int).SetString(p.curToken.Literal, 0)
+	if !ok {
+		p.errorf("could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
-	lit.Value = value
+	lit.Big = n
 
 	return lit
 }
@@ -103,7 +156,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{
 		Token: p.curToken,
-		Pairs: map[ast.Expression]ast.Expression{},
+		Pairs: ast.HashPairs{},
 	}
 
 	for !p.peekTokenIs(token.RBRACE) {
@@ -117,7 +170,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -164,6 +217,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	p.nextToken()
 
 	ident := &ast.Identifier{
+		Span:  tokenSpan(p.curToken),
 		Token: p.curToken,
 		Value: p.curToken.Literal,
 	}
@@ -174,6 +228,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		p.nextToken()
 
 		ident := &ast.Identifier{
+			Span:  tokenSpan(p.curToken),
 			Token: p.curToken,
 			Value: p.curToken.Literal,
 		}
@@ -208,13 +263,28 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 	}
 
-	precedence := p.curPrecedence()
+	precedence := p.rightBindingPower()
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
 	return expression
 }
 
+// parseOperatorCall desugars a user-defined infix operator into a call to the
+// function bound to its symbol: `a <+> b` becomes `<+>(a, b)`.
+func (p *Parser) parseOperatorCall(left ast.Expression) ast.Expression {
+	call := &ast.CallExpression{
+		Token:    p.curToken,
+		Function: &ast.Identifier{Span: tokenSpan(p.curToken), Token: p.curToken, Value: p.curToken.Literal},
+	}
+
+	precedence := p.rightBindingPower()
+	p.nextToken()
+	call.Arguments = []ast.Expression{left, p.parseExpression(precedence)}
+
+	return call
+}
+
 func (p *Parser) parseGroupingExpression() ast.Expression {
 	p.nextToken()
 
@@ -231,6 +301,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		Token:       p.curToken,
 		Condition:   nil,
 		Consequence: &ast.BlockStatement{},
+		Else:        token.Token{Type: token.ELSE, Literal: p.l.Locale().Keyword(token.ELSE)},
 		Alternative: &ast.BlockStatement{},
 	}
 
@@ -253,6 +324,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
+		exp.Else = p.curToken
 
 		if !p.expectPeek(token.LBRACE) {
 			return nil
@@ -264,14 +336,32 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return exp
 }
 
+// parseIndexExpression parses `left[index]`, or a slice `left[start:end]`
+// where either bound may be left out.
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{
-		Token: p.curToken,
-		Left:  left,
+	tok := p.curToken
+
+	var start ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		start = p.parseExpression(LOWEST)
 	}
 
+	if !p.peekTokenIs(token.COLON) {
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+
+		return &ast.IndexExpression{Token: tok, Left: left, Index: start}
+	}
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -280,6 +370,51 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseFieldExpression(left ast.Expression) ast.Expression {
+	exp := &ast.FieldExpression{Token: p.curToken, Left: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	exp.Field = &ast.Identifier{Span: tokenSpan(p.curToken), Token: p.curToken, Value: p.curToken.Literal}
+
+	return exp
+}
+
+// parseWithExpression parses `left with { x: 1, y: 2 }`.
+func (p *Parser) parseWithExpression(left ast.Expression) ast.Expression {
+	exp := &ast.WithExpression{Token: p.curToken, Left: left, Fields: []ast.FieldValue{}}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		name := &ast.Identifier{Span: tokenSpan(p.curToken), Token: p.curToken, Value: p.curToken.Literal}
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		exp.Fields = append(exp.Fields, ast.FieldValue{Name: name, Value: p.parseExpression(LOWEST)})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return exp
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	return &ast.CallExpression{
 		Token:     p.curToken,
@@ -314,22 +449,37 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFn(t token.TokenType) {
-	msg := fmt.Sprintf("No prefix parse function for token %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorf("No prefix parse function for token %s found", t)
 }
 
 func (p *Parser) peekPrecedence() BindingPower {
-	if p, ok := precedences[p.peekToken.Type]; ok {
-		return p
+	if op, ok := p.operators[p.peekToken.Type]; ok {
+		return op.Precedence
 	}
 
 	return LOWEST
 }
 
 func (p *Parser) curPrecedence() BindingPower {
-	if p, ok := precedences[p.curToken.Type]; ok {
-		return p
+	if op, ok := p.operators[p.curToken.Type]; ok {
+		return op.Precedence
 	}
 
 	return LOWEST
 }
+
+// rightBindingPower is the precedence the right operand of the current infix
+// operator is parsed with. Lowering it by one lets an operator of the same
+// level bind first, which makes the operator right associative.
+func (p *Parser) rightBindingPower() BindingPower {
+	op, ok := p.operators[p.curToken.Type]
+	if !ok {
+		return LOWEST
+	}
+
+	if op.Associativity == RightAssoc {
+		return op.Precedence - 1
+	}
+
+	return op.Precedence
+}
@@ -40,4 +40,6 @@ func (p *Parser) createTokenHandlers() {
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseFieldExpression)
+	p.registerInfix(token.WITH, p.parseWithExpression)
 }
@@ -1,8 +1,6 @@
 package parser
 
 import (
-	"fmt"
-
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/lexer"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
@@ -22,9 +20,25 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	operators OperatorTable
+
+	// depth counts the expressions being parsed inside each other.
+	depth int
 }
 
+// MaxNesting is how deep expressions can be nested, which keeps the parser,
+// and everything that walks the tree after it, from running out of stack.
+const MaxNesting = 1000
+
 func New(l *lexer.Lexer) *Parser {
+	return NewWithOperators(l, defaultOperators)
+}
+
+// NewWithOperators creates a parser that uses the given precedence and
+// associativity table instead of the built-in one. The table is copied, so
+// operators declared by the program don't leak into it.
+func NewWithOperators(l *lexer.Lexer, operators OperatorTable) *Parser {
 	p := &Parser{
 		l:              l,
 		errors:         []string{},
@@ -32,6 +46,11 @@ func New(l *lexer.Lexer) *Parser {
 		peekToken:      token.Token{},
 		prefixParseFns: map[token.TokenType]prefixParseFn{},
 		infixParseFns:  map[token.TokenType]infixParseFn{},
+		operators:      OperatorTable{},
+	}
+
+	for tokenType, op := range operators {
+		p.operators[tokenType] = op
 	}
 
 	p.createTokenHandlers()
@@ -56,6 +75,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 		Statements: []ast.Statement{},
 	}
 
+	start := p.curToken.Start
 	for p.curToken.Type != token.EOF {
 		stmt := p.parseStatement()
 		if stmt != nil {
@@ -64,6 +84,8 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.nextToken()
 	}
 
+	program.Span = ast.Span{Start: start, End: p.curToken.End}
+
 	return program
 }
 
@@ -87,7 +109,22 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.errorf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
+}
+
+// setSpan records that node was parsed from start up to the end of the
+// current token.
+func (p *Parser) setSpan(node ast.Node, start token.Position) {
+	if node, ok := node.(interface{ SetNodeSpan(ast.Span) }); ok {
+		node.SetNodeSpan(ast.Span{Start: start, End: p.curToken.End})
+	}
+}
+
+func tokenSpan(tok token.Token) ast.Span {
+	return ast.Span{Start: tok.Start, End: tok.End}
+}
+
+// errorf records a parser error, worded in the lexer's current locale.
+func (p *Parser) errorf(format string, a ...any) {
+	p.errors = append(p.errors, p.l.Locale().Sprintf(format, a...))
 }
@@ -2,10 +2,12 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/lexer"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
 )
 
 func TestLetStatement(t *testing.T) {
@@ -147,6 +149,28 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testLiteralExpression(t, stmt.Expression, 5)
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "123456789012345678901234567890;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.Big == nil || literal.Big.String() != "123456789012345678901234567890" {
+		t.Errorf("literal.Big wrong. got=%v", literal.Big)
+	}
+	if literal.String() != "123456789012345678901234567890" {
+		t.Errorf("literal.String() wrong. got=%q", literal.String())
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 
@@ -287,7 +311,8 @@ func TestParsingHashLiteral(t *testing.T) {
 		"three": 3,
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -323,7 +348,8 @@ func TestParsingHashLiteralIntegerKeys(t *testing.T) {
 		3: 8,
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.IntegerLiteral)
 		if !ok {
 			t.Errorf("key is not ast.IntegerLiteral. got=%T", key)
@@ -358,7 +384,8 @@ func TestParsingHashLiteralBooleanKeys(t *testing.T) {
 		false: 2,
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.Boolean)
 		if !ok {
 			t.Errorf("key is not ast.Boolean. got=%T", key)
@@ -394,7 +421,8 @@ func TestParsingHashLiteralWithExpressions(t *testing.T) {
 		"three": func(e ast.Expression) { testInfixExpression(t, e, 15, "/", 3) },
 	}
 
-	for key, value := range hashmap.Pairs {
+	for _, pair := range hashmap.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -494,6 +522,40 @@ func TestFunctionParameters(t *testing.T) {
 	}
 }
 
+func TestFunctionLiteralName(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedName string
+	}{
+		{"fn(x) { x }", ""},
+		{"let inc = fn(x) { x + 1 }", "inc"},
+		{"infixl 45 <+> = fn(a, b) { a + b }", "<+>"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		var value ast.Expression
+		switch stmt := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			value = stmt.Value
+		case *ast.ExpressionStatement:
+			value = stmt.Expression
+		}
+
+		fn, ok := value.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("value is not ast.FunctionLiteral. got=%T", value)
+		}
+		if fn.Name != tt.expectedName {
+			t.Errorf("function literal name wrong. want=%q, got=%q", tt.expectedName, fn.Name)
+		}
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -556,6 +618,121 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs[1:2]", "(xs[1:2])"},
+		{"xs[:b + 1]", "(xs[:(b + 1)])"},
+		{"xs[-2:]", "(xs[(-2):])"},
+		{"xs[:]", "(xs[:])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.SliceExpression); !ok {
+			t.Fatalf("exp not *ast.SliceExpression. got=%T", stmt.Expression)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestStructStatement(t *testing.T) {
+	input := "struct Point { x, y };"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.StructStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "Point" {
+		t.Errorf("stmt.Name.Value not 'Point'. got=%s", stmt.Name.Value)
+	}
+
+	if len(stmt.Fields) != 2 || stmt.Fields[0].Value != "x" || stmt.Fields[1].Value != "y" {
+		t.Errorf("wrong fields. got=%s", stmt.String())
+	}
+
+	if stmt.String() != "struct Point { x, y }" {
+		t.Errorf("wrong String(). got=%q", stmt.String())
+	}
+}
+
+func TestStructErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"struct Point { x, x }", "duplicate field x in struct Point"},
+		{"struct { x }", "expected next token to be IDENT, got { instead"},
+		{"p.1", "expected next token to be IDENT, got INT instead"},
+		{`p with { "x": 1 }`, "expected next token to be IDENT, got STRING instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestParsingFieldAndWithExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"p.x", "(p.x)"},
+		{"a.b.c", "((a.b).c)"},
+		{"-p.x * 2", "((-(p.x)) * 2)"},
+		{"xs[0].x", "((xs[0]).x)"},
+		{"f(a).x", "(f(a).x)"},
+		{"p with { x: 1 }", "(p with {x: 1})"},
+		{"p with { x: p.x + 1, y: 2 }.y", "((p with {x: ((p.x) + 1), y: 2}).y)"},
+		{"p with { x: 1 } with { y: 2 }", "((p with {x: 1}) with {y: 2})"},
+		{"a + p with {}", "(a + (p with {}))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input        string
@@ -773,6 +950,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a * xs[1:][0]",
+			"(a * ((xs[1:])[0]))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -788,6 +969,251 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestCustomOperatorTable(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a + b * c", "((a + b) * c)"},
+		{"a - b - c", "(a - (b - c))"},
+		{"a * b - c", "((a * b) - c)"},
+		{"a == b + c", "((a == b) + c)"},
+	}
+
+	operators := DefaultOperators()
+	operators[token.PLUS] = Operator{PRODUCT + 5, LeftAssoc}
+	operators[token.MINUS] = Operator{SUM, RightAssoc}
+	operators[token.EQ] = Operator{PRODUCT + 5, LeftAssoc}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := NewWithOperators(l, operators)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	if defaultOperators[token.PLUS].Precedence != SUM {
+		t.Errorf("custom table leaked into the default operators")
+	}
+}
+
+func TestOperatorDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"infixl 45 <+> = fn(a, b) { a + b }; a <+> b * c",
+			"let <+> = fn(a, b)(a + b);<+>(a, (b * c))",
+		},
+		{
+			"infixl 45 <+> = add; a + b <+> c <+> d",
+			"let <+> = add;(a + <+>(<+>(b, c), d))",
+		},
+		{
+			"infixr 45 ^ = pow; a ^ b ^ c * d",
+			"let ^ = pow;^(a, ^(b, (c * d)))",
+		},
+		{
+			"infixl 15 |> = apply; x |> f == y",
+			"let |> = apply;|>(x, (f == y))",
+		},
+		{
+			"infixl 45 <+> = add; !a <+> -b",
+			"let <+> = add;<+>((!a), (-b))",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestOperatorDeclarationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"infixl 5 <+> = add", "operator precedence must be between 11 and 59, got 5"},
+		{"infixl 45 foo = add", "expected an operator symbol, got IDENT instead"},
+		{"infixl 45 + = add", "operator + is already defined"},
+		{`infixl 50 :- = add; {"a":-1}`, `operators can't start with ":"`},
+		{"infixl 50 <++++++++++++++++> = add", "operators can be at most 16 characters long"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+// The lexer only reads `<+>` as one token once the declaration is accepted.
+func TestRejectedOperatorDeclaration(t *testing.T) {
+	p := New(lexer.New("infixl 45 <+> add; a <+> b"))
+	p.ParseProgram()
+
+	expected := []string{
+		"expected next token to be =, got IDENT instead",
+		"No prefix parse function for token + found",
+	}
+	if strings.Join(p.Errors(), "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong errors. want=%q, got=%q", expected, p.Errors())
+	}
+}
+
+func TestNestingLimit(t *testing.T) {
+	p := New(lexer.New(strings.Repeat("-", 1<<20) + "1"))
+	p.ParseProgram()
+
+	expected := fmt.Sprintf("expressions can't be nested more than %d deep", MaxNesting)
+	if len(p.Errors()) != 1 || p.Errors()[0] != expected {
+		t.Errorf("wrong errors. want=%q, got=%q", expected, p.Errors())
+	}
+
+	p = New(lexer.New(strings.Repeat("(", MaxNesting-1) + "1" + strings.Repeat(")", MaxNesting-1)))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+// A long run of operator chars is read a declared operator at a time.
+func TestLongOperatorRuns(t *testing.T) {
+	p := New(lexer.New("infixl 45 -+ = f; 1" + strings.Repeat(" -+ 1", 20_000)))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	p = New(lexer.New("infixl 45 -+ = f; " + strings.Repeat("-+", 50_000)))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected parser errors")
+	}
+}
+
+func TestLocalizedParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		errors   []string
+	}{
+		{
+			input:    "sea x = si (a) { b } sino { c };",
+			expected: "sea x = sia bsino c;",
+		},
+		{
+			input:    "infijoizq 45 <+> = f; a <+> b",
+			expected: "sea <+> = f;<+>(a, b)",
+		},
+		{
+			input: "sea = 5;",
+			errors: []string{
+				"se esperaba que el siguiente token fuera IDENT, se obtuvo =",
+				"no se encontró una función de análisis prefija para el token =",
+			},
+		},
+		{
+			input:    "// locale: en\nlet x = if (a) { b } else { c };",
+			expected: "let x = ifa belse c;",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithLocale(tt.input, token.Spanish)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if tt.errors != nil {
+			if len(p.Errors()) != len(tt.errors) {
+				t.Fatalf("wrong number of errors. want=%q, got=%q",
+					tt.errors, p.Errors())
+			}
+
+			for i, msg := range tt.errors {
+				if p.Errors()[i] != msg {
+					t.Errorf("wrong error. want=%q, got=%q", msg, p.Errors()[i])
+				}
+			}
+
+			continue
+		}
+
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+(add)(1, 2 * -3);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	product := call.Arguments[1].(*ast.InfixExpression)
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{program, input},
+		{let, "let add = fn(a, b) {\n  a + b\n};"},
+		{let.Name, "add"},
+		{fn, "fn(a, b) {\n  a + b\n}"},
+		{fn.Parameters[1], "b"},
+		{fn.Body, "{\n  a + b\n}"},
+		{body.Expression, "a + b"},
+		{call, "(add)(1, 2 * -3)"},
+		{call.Function, "(add)"},
+		{product, "2 * -3"},
+		{product.Right, "-3"},
+	}
+
+	for _, tt := range tests {
+		span := tt.node.NodeSpan()
+		got := input[span.Start.Offset:span.End.Offset]
+		if got != tt.expected {
+			t.Errorf("span of %s wrong. expected=%q, got=%q", tt.node, tt.expected, got)
+		}
+	}
+
+	if span := product.NodeSpan(); span.Start.Line != 4 || span.Start.Column != 10 {
+		t.Errorf("product starts at %s, expected 4:10", span.Start)
+	}
+}
+
 func TestIfExpressions(t *testing.T) {
 	input := "if (x < y) { x }"
 
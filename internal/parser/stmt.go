@@ -1,19 +1,36 @@
 package parser
 
 import (
+	"strconv"
+
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
 )
 
 func (p *Parser) parseStatement() ast.Statement {
+	start := p.curToken.Start
+
+	var stmt ast.Statement
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if s := p.parseLetStatement(); s != nil {
+			stmt = s
+		}
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
+	case token.INFIXL, token.INFIXR:
+		stmt = p.parseOperatorDeclaration()
+	case token.STRUCT:
+		if s := p.parseStructStatement(); s != nil {
+			stmt = s
+		}
 	default:
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
 	}
+
+	p.setSpan(stmt, start)
+
+	return stmt
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
@@ -28,6 +45,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	stmt.Name = &ast.Identifier{
+		Span:  tokenSpan(p.curToken),
 		Token: p.curToken,
 		Value: p.curToken.Literal,
 	}
@@ -39,6 +57,122 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = stmt.Name.Value
+	}
+
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseOperatorDeclaration parses `infixl 45 <+> = fn(a, b) { ... };`. The
+// operator is registered for the rest of the program and the declaration
+// itself desugars into `let <+> = fn(a, b) { ... };`.
+func (p *Parser) parseOperatorDeclaration() ast.Statement {
+	op := Operator{Associativity: LeftAssoc}
+	if p.curTokenIs(token.INFIXR) {
+		op.Associativity = RightAssoc
+	}
+
+	if !p.expectPeek(token.INT) {
+		return nil
+	}
+
+	precedence, err := strconv.Atoi(p.curToken.Literal)
+	if err != nil || precedence <= int(LOWEST) || precedence >= int(PREFIX) {
+		p.errorf("operator precedence must be between %d and %d, got %s",
+			LOWEST+1, PREFIX-1, p.curToken.Literal)
+		return nil
+	}
+	op.Precedence = BindingPower(precedence)
+
+	p.nextToken()
+	symbol := p.curToken
+
+	if symbol.Type == token.COLON {
+		p.errorf("operators can't start with %q", ":")
+		return nil
+	}
+	if symbol.Literal == "" || !token.IsOperatorStart(symbol.Literal[0]) {
+		p.errorf("expected an operator symbol, got %s instead", symbol.Type)
+		return nil
+	}
+	if len(symbol.Literal) > token.MaxOperatorLength {
+		p.errorf("operators can be at most %d characters long", token.MaxOperatorLength)
+		return nil
+	}
+
+	if _, ok := p.infixParseFns[symbol.Type]; ok {
+		p.errorf("operator %s is already defined", symbol.Literal)
+		return nil
+	}
+
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	// Registered before the body is parsed so the operator can be used
+	// recursively.
+	p.operators[symbol.Type] = op
+	p.registerInfix(symbol.Type, p.parseOperatorCall)
+	p.l.DeclareOperator(symbol.Literal)
+
+	p.nextToken()
+
+	stmt := &ast.LetStatement{
+		Token: token.Token{Type: token.LET, Literal: p.l.Locale().Keyword(token.LET)},
+		Name:  &ast.Identifier{Span: tokenSpan(symbol), Token: symbol, Value: symbol.Literal},
+		Value: p.parseExpression(LOWEST),
+	}
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = symbol.Literal
+	}
+
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseStructStatement parses `struct Point { x, y }`.
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	stmt := &ast.StructStatement{Token: p.curToken, Fields: []*ast.Identifier{}}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Span: tokenSpan(p.curToken), Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		field := &ast.Identifier{Span: tokenSpan(p.curToken), Token: p.curToken, Value: p.curToken.Literal}
+		if seen[field.Value] {
+			p.errorf("duplicate field %s in struct %s", field.Value, stmt.Name.Value)
+			return nil
+		}
+		seen[field.Value] = true
+		stmt.Fields = append(stmt.Fields, field)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
 
 	for p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -65,10 +199,8 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{
-		Token:      p.curToken,
-		Expression: p.parseExpression(LOWEST),
-	}
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
 
 	for p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -93,5 +225,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	block.Span = ast.Span{Start: block.Token.Start, End: p.curToken.End}
+
 	return block
 }
@@ -1,7 +1,7 @@
 package repl
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
@@ -28,34 +28,61 @@ const (
 
 type REPL struct {
 	env *object.Environment
+
+	// Operators overrides the parser's precedence table when set.
+	Operators parser.OperatorTable
+	// Locale selects the keyword set programs are written in, and the
+	// language of every error message.
+	Locale *token.Locale
+	// Budget limits every evaluation and VM run. The zero value is unlimited.
+	Budget object.Budget
+	// IO is where builtins such as `puts` read and write.
+	IO object.IO
+	// Stdlib is the set of builtins programs can call.
+	Stdlib *object.Stdlib
+	// Optimize picks the optimization passes compiled programs go through.
+	Optimize compiler.Passes
 }
 
 func New() *REPL {
 	return &REPL{
-		env: object.NewEnvironment(),
+		env:    object.NewEnvironment(),
+		Locale: token.English,
+		IO:     object.StdIO(),
+		Stdlib: object.DefaultStdlib(),
 	}
 }
 
+// ParseTokens returns the tokens of line. The line is parsed as well, since
+// an operator only reads as a single token once the parser accepts its
+// declaration.
 func (r *REPL) ParseTokens(line string) []token.Token {
 	var tokens []token.Token
-	l := lexer.New(line)
-
-	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
-		tokens = append(tokens, tok)
+	l := lexer.NewWithLocale(line, r.Locale)
+	l.Record()
+	r.parserFor(l).ParseProgram()
+
+	for _, tok := range l.Tokens() {
+		if tok.Type != token.EOF {
+			tokens = append(tokens, tok)
+		}
 	}
 
 	return tokens
 }
 
 type ParseResult struct {
-	Program  *ast.Program `json:"program"`
-	Errors   []string     `json:"errors"`
-	Evaluate string       `json:"evaluate"`
+	Program *ast.Program `json:"program"`
+	Errors  []string     `json:"errors"`
+	// Evaluate is the value of the program, nil when it has none. Wrap it in
+	// an object.JSONValue to serialize it.
+	Evaluate object.Object `json:"-"`
+	// Trace is only filled in by TraceLine.
+	Trace *evaluator.Trace `json:"trace,omitempty"`
 }
 
 func (r *REPL) ParseAST(line string) *ParseResult {
-	l := lexer.New(line)
-	p := parser.New(l)
+	p := r.newParser(line)
 
 	program := p.ParseProgram()
 
@@ -67,9 +94,32 @@ func (r *REPL) ParseAST(line string) *ParseResult {
 	return result
 }
 
-func (r *REPL) EvaluateLine(line string) *ParseResult {
-	l := lexer.New(line)
-	p := parser.New(l)
+// EvaluateLine parses and evaluates line. The error is only set when the
+// evaluation was cut short by the budget or the context.
+func (r *REPL) EvaluateLine(ctx context.Context, line string) (*ParseResult, error) {
+	return r.evaluateLine(ctx, line, evaluator.NewWithStdlib(r.Stdlib))
+}
+
+// TraceLine evaluates like EvaluateLine while recording every step the
+// evaluator takes.
+func (r *REPL) TraceLine(ctx context.Context, line string) (*ParseResult, error) {
+	trace := evaluator.NewTrace(evaluator.DefaultTraceLimit)
+
+	e := evaluator.NewWithStdlib(r.Stdlib)
+	e.SetTracer(trace)
+
+	result, err := r.evaluateLine(ctx, line, e)
+	result.Trace = trace
+
+	return result, err
+}
+
+func (r *REPL) evaluateLine(
+	ctx context.Context,
+	line string,
+	e *evaluator.Evaluator,
+) (*ParseResult, error) {
+	p := r.newParser(line)
 
 	program := p.ParseProgram()
 	result := &ParseResult{
@@ -78,56 +128,123 @@ func (r *REPL) EvaluateLine(line string) *ParseResult {
 	}
 
 	if len(result.Errors) != 0 {
-		return result
+		return result, nil
 	}
 
-	evaluated := evaluator.Eval(program, r.env)
-	if evaluated != nil {
-		result.Evaluate = evaluated.Inspect()
+	e.SetIO(r.IO)
+	e.SetLocale(r.Locale)
+	evaluated, err := e.EvalContext(ctx, program, r.env, r.Budget)
+	if err != nil {
+		return result, err
 	}
 
-	return result
+	result.Evaluate = evaluated
+
+	return result, nil
 }
 
 func (r *REPL) CompileToBytecode(line string) (*compiler.Bytecode, error) {
-	l := lexer.New(line)
-	p := parser.New(l)
+	stages, err := r.OptimizeLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	return stages[len(stages)-1].Bytecode, nil
+}
+
+// OptimizeLine compiles line and runs it through the passes in r.Optimize,
+// returning the bytecode as it was after each of them.
+func (r *REPL) OptimizeLine(line string) ([]compiler.Stage, error) {
+	p := r.newParser(line)
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		return nil, fmt.Errorf("parser errors: %v", p.Errors())
+		return nil, r.errorf("parser errors: %v", p.Errors())
 	}
 
-	comp := compiler.New()
-	if err := comp.Compile(program); err != nil {
-		return nil, fmt.Errorf("compiler error: %s", err)
+	stages, err := compiler.Optimize(program, r.Stdlib, r.Optimize)
+	if err != nil {
+		return nil, r.errorf("compiler error: %s", err)
 	}
 
-	return comp.Bytecode(), nil
+	return stages, nil
 }
 
-func (r *REPL) CompileToVM(line string) (object.Object, error) {
-	l := lexer.New(line)
-	p := parser.New(l)
+func (r *REPL) CompileToVM(ctx context.Context, line string) (object.Object, error) {
+	return r.compileToVM(ctx, line, nil)
+}
 
-	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		return nil, fmt.Errorf("parser errors: %v", p.Errors())
-	}
+// TraceVM runs like CompileToVM while recording every instruction the VM
+// runs. The trace is empty when the program didn't compile.
+func (r *REPL) TraceVM(ctx context.Context, line string) (object.Object, *vm.Trace, error) {
+	trace := vm.NewTrace(vm.DefaultTraceLimit)
+
+	result, err := r.compileToVM(ctx, line, trace)
 
-	comp := compiler.New()
-	if err := comp.Compile(program); err != nil {
-		return nil, fmt.Errorf("compiler error: %s", err)
+	return result, trace, err
+}
+
+func (r *REPL) compileToVM(ctx context.Context, line string, trace *vm.Trace) (object.Object, error) {
+	bytecode, err := r.CompileToBytecode(line)
+	if err != nil {
+		return nil, err
 	}
 
-	machine := vm.New(comp.Bytecode())
-	if err := machine.Run(); err != nil {
-		return nil, fmt.Errorf("vm error: %s", err)
+	return r.runBytecode(ctx, bytecode, trace)
+}
+
+// RunBytecode runs bytecode that wasn't compiled from Monkey, such as the
+// output of compiler.Assemble, the way CompileToVM runs a program.
+func (r *REPL) RunBytecode(ctx context.Context, bytecode *compiler.Bytecode) (object.Object, error) {
+	return r.runBytecode(ctx, bytecode, nil)
+}
+
+func (r *REPL) runBytecode(ctx context.Context, bytecode *compiler.Bytecode, trace *vm.Trace) (object.Object, error) {
+	machine := vm.NewWithStdlib(bytecode, r.Stdlib)
+	machine.SetIO(r.IO)
+	machine.SetLocale(r.Locale)
+	if trace != nil {
+		machine.SetTrace(trace)
+	}
+	if err := machine.RunContext(ctx, r.Budget); err != nil {
+		return nil, r.errorf("vm error: %w", err)
 	}
 
 	return machine.LastPoppedStackElem(), nil
 }
 
+// DebugLine compiles line for a debugger, which starts paused before the
+// first instruction.
+func (r *REPL) DebugLine(line string) (*vm.Debugger, error) {
+	bytecode, err := r.CompileToBytecode(line)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := vm.NewWithStdlib(bytecode, r.Stdlib)
+	machine.SetIO(r.IO)
+	machine.SetLocale(r.Locale)
+
+	return vm.NewDebugger(machine, r.Budget), nil
+}
+
+func (r *REPL) newParser(line string) *parser.Parser {
+	return r.parserFor(lexer.NewWithLocale(line, r.Locale))
+}
+
+func (r *REPL) parserFor(l *lexer.Lexer) *parser.Parser {
+	if r.Operators == nil {
+		return parser.New(l)
+	}
+
+	return parser.NewWithOperators(l, r.Operators)
+}
+
+// errorf builds an error worded in r.Locale.
+func (r *REPL) errorf(format string, a ...any) error {
+	return object.Localized(object.Errorf(format, a...), r.Locale)
+}
+
 func applyColor(color, text string) string {
 	return color + text + RESET
 }
@@ -0,0 +1,175 @@
+package token
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Locale is a surface syntax for Monkey: the spelling of every keyword and
+// the wording of error messages. Every locale maps onto the same token
+// types, so everything after the parser is locale agnostic. Runtime errors
+// keep their English format and are worded in a locale when shown.
+type Locale struct {
+	Name     string
+	Keywords map[string]TokenType
+	// Messages translates the English error formats. Missing entries fall
+	// back to English.
+	Messages map[string]string
+}
+
+var English = &Locale{
+	Name:     "en",
+	Keywords: keywords,
+	Messages: map[string]string{},
+}
+
+var Spanish = &Locale{
+	Name: "es",
+	Keywords: map[string]TokenType{
+		"funcion":    FUNCTION,
+		"sea":        LET,
+		"verdadero":  TRUE,
+		"falso":      FALSE,
+		"si":         IF,
+		"sino":       ELSE,
+		"retornar":   RETURN,
+		"infijoizq":  INFIXL,
+		"infijoder":  INFIXR,
+		"estructura": STRUCT,
+		"con":        WITH,
+	},
+	Messages: map[string]string{
+		"unterminated string": "cadena sin terminar",
+		"unknown locale %q":   "idioma desconocido %q",
+
+		"expected next token to be %s, got %s instead":  "se esperaba que el siguiente token fuera %s, se obtuvo %s",
+		"could not parse %q as integer":                 "no se pudo interpretar %q como entero",
+		"No prefix parse function for token %s found":   "no se encontró una función de análisis prefija para el token %s",
+		"expressions can't be nested more than %d deep": "las expresiones no pueden anidarse más de %d niveles",
+
+		"operator precedence must be between %d and %d, got %s": "la precedencia del operador debe estar entre %d y %d, se obtuvo %s",
+		"expected an operator symbol, got %s instead":           "se esperaba un símbolo de operador, se obtuvo %s",
+		"operator %s is already defined":                        "el operador %s ya está definido",
+		"operators can't start with %q":                         "los operadores no pueden empezar con %q",
+		"operators can be at most %d characters long":           "los operadores pueden tener como máximo %d caracteres",
+		"duplicate field %s in struct %s":                       "campo %s duplicado en la estructura %s",
+
+		"parser errors: %v":     "errores del analizador: %v",
+		"compiler error: %s":    "error del compilador: %s",
+		"vm error: %w":          "error de la vm: %w",
+		"undefined variable %s": "variable no definida %s",
+		"unknown operator %s":   "operador desconocido %s",
+		"constant pool too large: %s can't reach constant %d": "pool de constantes demasiado grande: %s no alcanza la constante %d",
+
+		"identifier not found: %s":                      "identificador no encontrado: %s",
+		"not a function: %s":                            "no es una función: %s",
+		"not a function: %+v":                           "no es una función: %+v",
+		"calling non-function and non-built-in":         "se llamó a algo que no es una función",
+		"type mismatch: %s %s %s":                       "tipos incompatibles: %s %s %s",
+		"unknown operator: %s %s %s":                    "operador desconocido: %s %s %s",
+		"unknown operator: %s%s":                        "operador desconocido: %s%s",
+		"unknown operator: -%s":                         "operador desconocido: -%s",
+		"unknown operator: %d":                          "operador desconocido: %d",
+		"unknown operator: %d (%s %s)":                  "operador desconocido: %d (%s %s)",
+		"unknown integer operator: %s":                  "operador de enteros desconocido: %s",
+		"unknown integer operator: %d":                  "operador de enteros desconocido: %d",
+		"unknown string operator: %d":                   "operador de cadenas desconocido: %d",
+		"unsupported types for binary operation: %s %s": "tipos no soportados para la operación binaria: %s %s",
+		"unsupported type for negation: %s":             "tipo no soportado para la negación: %s",
+		"index operator not supported: %s":              "operador de índice no soportado: %s",
+		"slice operator not supported: %s":              "operador de rebanada no soportado: %s",
+		"slice bound must be INTEGER, got=%s":           "el límite de la rebanada debe ser INTEGER, se obtuvo=%s",
+		"unusable as hash key: %s":                      "no se puede usar como clave de hash: %s",
+		"field access not supported: %s":                "acceso a campo no soportado: %s",
+		"field name must be STRING, got=%s":             "el nombre del campo debe ser STRING, se obtuvo=%s",
+		"%s has no field `%s`":                          "%s no tiene el campo `%s`",
+		"`with` not supported: %s":                      "`con` no soportado: %s",
+		"global %d is read before it is set":            "la global %d se lee antes de asignarse",
+		"unknown builtin: %d":                           "función integrada desconocida: %d",
+		"stack overflow":                                "desbordamiento de pila",
+		"stack overflow: more than %d nested calls":     "desbordamiento de pila: más de %d llamadas anidadas",
+		"division by zero":                              "división por cero",
+		"integer too large: more than %d bits":          "entero demasiado grande: más de %d bits",
+
+		"execution budget exceeded":  "presupuesto de ejecución agotado",
+		"execution cancelled: %w":    "ejecución cancelada: %w",
+		"%s: %s limit of %d reached": "%s: se alcanzó el límite de %[3]d %[2]s",
+		"steps":                      "pasos",
+		"depth":                      "de profundidad",
+		"allocs":                     "asignaciones",
+
+		"wrong number of arguments: want=%d, got=%d":                     "número de argumentos incorrecto: se esperaba=%d, se obtuvo=%d",
+		"wrong number of arguments. got=%d, want=%d":                     "número de argumentos incorrecto. se obtuvo=%d, se esperaba=%d",
+		"wrong number of arguments. got=%d, want at least %d":            "número de argumentos incorrecto. se obtuvo=%d, se esperaban al menos %d",
+		"argument to `%s` must be %s, got=%s":                            "el argumento de `%s` debe ser %s, se obtuvo=%s",
+		"argument to `%s` not supported, got=%s":                         "argumento de `%s` no soportado, se obtuvo=%s",
+		"argument to `unicodeLen` not supported, got=%s":                 "argumento de `unicodeLen` no soportado, se obtuvo=%s",
+		"argument to `join` must be ARRAY of STRING, got=%s at index %d": "el argumento de `join` debe ser ARRAY de STRING, se obtuvo=%s en el índice %d",
+		"keys of `sort_by` must be all INTEGER or all STRING, got=%s":    "las claves de `sort_by` deben ser todas INTEGER o todas STRING, se obtuvo=%s",
+		"not enough values for `format`: got=%d":                         "faltan valores para `format`: se obtuvo=%d",
+		"too many values for `format`: want=%d, got=%d":                  "demasiados valores para `format`: se esperaba=%d, se obtuvo=%d",
+		"range of more than %d elements":                                 "rango de más de %d elementos",
+		"unusable as JSON key: %s":                                       "no se puede usar como clave JSON: %s",
+		"no JSON form for %s":                                            "%s no tiene forma JSON",
+		"unexpected data after JSON value":                               "datos inesperados después del valor JSON",
+		"unexpected end of JSON input":                                   "fin inesperado de la entrada JSON",
+		"number %s is not an integer":                                    "el número %s no es un entero",
+		"unexpected JSON token %v":                                       "token JSON inesperado %v",
+	},
+}
+
+var locales = map[string]*Locale{
+	English.Name: English,
+	Spanish.Name: Spanish,
+}
+
+// LookupLocale finds a locale by its name, e.g. "es".
+func LookupLocale(name string) (*Locale, bool) {
+	l, ok := locales[name]
+	return l, ok
+}
+
+// LocaleNames lists the names of every available locale.
+func LocaleNames() []string {
+	names := []string{}
+	for name := range locales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (l *Locale) LookupIdent(ident string) TokenType {
+	if tok, ok := l.Keywords[ident]; ok {
+		return tok
+	}
+
+	return IDENT
+}
+
+// Keyword returns how the locale spells the keyword for the given token type.
+func (l *Locale) Keyword(t TokenType) string {
+	for word, tok := range l.Keywords {
+		if tok == t {
+			return word
+		}
+	}
+
+	if l != English {
+		return English.Keyword(t)
+	}
+
+	return ""
+}
+
+// Sprintf formats an English message in the locale's language. Error
+// formats may wrap with %w, which formats like %v.
+func (l *Locale) Sprintf(format string, a ...any) string {
+	if translated, ok := l.Messages[format]; ok {
+		format = translated
+	}
+
+	return fmt.Sprintf(strings.ReplaceAll(format, "%w", "%v"), a...)
+}
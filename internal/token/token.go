@@ -1,5 +1,10 @@
 package token
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
@@ -26,6 +31,7 @@ const (
 	// Delimiters
 	COMMA     = ","
 	COLON     = ":"
+	DOT       = "."
 	SEMICOLON = ";"
 
 	LPAREN   = "("
@@ -43,13 +49,31 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	INFIXL   = "INFIXL"
+	INFIXR   = "INFIXR"
+	STRUCT   = "STRUCT"
+	WITH     = "WITH"
 )
 
 type TokenType string
 
+// Position is a location in the source. Lines and columns start at 1;
+// columns count bytes.
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 type Token struct {
 	Type    TokenType `json:"tokenType"`
 	Literal string    `json:"literal"`
+	Start   Position  `json:"start"`
+	End     Position  `json:"end"` // Just past the last char of the token
 }
 
 var keywords = map[string]TokenType{
@@ -60,12 +84,29 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"infixl": INFIXL,
+	"infixr": INFIXR,
+	"struct": STRUCT,
+	"with":   WITH,
 }
 
-func LookupIdent(ident string) TokenType {
-	if tok, ok := keywords[ident]; ok {
-		return tok
-	}
+// operatorChars are the characters a user-defined operator can be spelled
+// with, e.g. `<+>` or `|>`.
+const operatorChars = "+-*/<>=!&|^%~?@$:"
 
-	return IDENT
+// MaxOperatorLength is the longest a user-defined operator can be spelled.
+const MaxOperatorLength = 16
+
+func IsOperatorChar(ch byte) bool {
+	return strings.IndexByte(operatorChars, ch) >= 0
+}
+
+// IsOperatorStart reports whether a user-defined operator can start with
+// ch. Not with ':', or declaring `:-` would break hashes like `{"a":-1}`.
+func IsOperatorStart(ch byte) bool {
+	return ch != ':' && IsOperatorChar(ch)
+}
+
+func LookupIdent(ident string) TokenType {
+	return English.LookupIdent(ident)
 }
@@ -0,0 +1,334 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// MainFunction is the Function of a Location in the main program rather
+// than in a compiled function.
+const MainFunction = compiler.MainFunction
+
+// Location is an instruction of the program. Function is the index of its
+// compiled function in the constant pool, or MainFunction.
+type Location struct {
+	Function int `json:"function"`
+	Offset   int `json:"offset"`
+}
+
+// FrameState is what a Debugger shows of a frame.
+type FrameState struct {
+	Function string `json:"function"`
+	// Next is the instruction the frame runs next. For every frame but the
+	// innermost that is once its call returns.
+	Next        Location        `json:"next"`
+	IP          int             `json:"ip"`
+	BasePointer int             `json:"basePointer"`
+	Free        []object.Object `json:"-"`
+	// Position is where the next instruction of the innermost frame, and
+	// the call of every other frame, was compiled from. It is nil when the
+	// function has no source map.
+	Position *token.Position `json:"position,omitempty"`
+}
+
+// Debugger runs a VM an instruction at a time. It starts paused before the
+// first instruction. Calls a builtin makes back into Monkey functions, as
+// `map` does, run in one go.
+type Debugger struct {
+	vm *VM
+
+	// The constant pool index of every compiled function.
+	functions map[*object.CompiledFunction]int
+
+	breakpoints     map[Location]bool
+	lineBreakpoints map[int]bool
+	// The line each frame on the stack last ran an instruction on, by
+	// depth, so a line breakpoint stops on arriving at the line and not at
+	// every instruction of it.
+	lastLines []frameLine
+
+	err *RuntimeError
+}
+
+// NewDebugger debugs vm, which must not have run yet. The budget covers
+// everything run until the program is done, while the context given to
+// each step bounds only that step.
+func NewDebugger(vm *VM, budget object.Budget) *Debugger {
+	vm.meter = object.NewMeter(context.Background(), budget)
+
+	functions := map[*object.CompiledFunction]int{}
+	for i, c := range vm.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			functions[fn] = i
+		}
+	}
+
+	return &Debugger{
+		vm:              vm,
+		functions:       functions,
+		breakpoints:     map[Location]bool{},
+		lineBreakpoints: map[int]bool{},
+		lastLines:       make([]frameLine, MaxFrames),
+	}
+}
+
+// Step runs the next instruction, entering a called function.
+func (d *Debugger) Step(ctx context.Context) error {
+	return d.resume(ctx, func() bool { return true })
+}
+
+// StepOver runs the next instruction, and the whole call if it makes one.
+func (d *Debugger) StepOver(ctx context.Context) error {
+	depth := d.vm.framesIndex
+
+	return d.resume(ctx, func() bool { return d.vm.framesIndex <= depth })
+}
+
+// StepOut runs until the current function returns.
+func (d *Debugger) StepOut(ctx context.Context) error {
+	depth := d.vm.framesIndex
+
+	return d.resume(ctx, func() bool { return d.vm.framesIndex < depth })
+}
+
+// Continue runs until a breakpoint or the end of the program.
+func (d *Debugger) Continue(ctx context.Context) error {
+	return d.resume(ctx, func() bool { return false })
+}
+
+// How many instructions resume runs between checks of its context.
+const debugCancelCheckInterval = 1024
+
+// resume runs at least one instruction, then stops once until holds or a
+// breakpoint is reached. The error is the *RuntimeError that stopped the
+// program, also returned by every later call. The program also stops with
+// an error once ctx is done, as a run would.
+func (d *Debugger) resume(ctx context.Context, until func() bool) error {
+	if d.err != nil {
+		return d.err
+	}
+
+	for steps := 0; !d.vm.halted(); steps++ {
+		if steps%debugCancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				d.err = d.vm.runtimeError(object.Errorf("execution cancelled: %w", err))
+				return d.err
+			}
+		}
+
+		frame := d.vm.currentFrame()
+		if mapping, ok := frame.cl.Fn.SourceMap.Lookup(frame.ip + 1); ok {
+			d.lastLines[d.vm.framesIndex-1] = frameLine{frame, mapping.Start.Line}
+		}
+
+		if err := d.vm.step(); err != nil {
+			d.err = d.vm.runtimeError(err)
+			return d.err
+		}
+
+		if until() || d.atBreakpoint() {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (d *Debugger) atBreakpoint() bool {
+	if d.vm.halted() {
+		return false
+	}
+
+	if d.breakpoints[d.Location()] {
+		return true
+	}
+
+	frame := d.vm.currentFrame()
+	mapping, ok := frame.cl.Fn.SourceMap.Lookup(frame.ip + 1)
+	if !ok || !d.lineBreakpoints[mapping.Start.Line] {
+		return false
+	}
+
+	// Anything else at this depth was left by a call that has returned, so
+	// the frame only just arrived.
+	last := d.lastLines[d.vm.framesIndex-1]
+	return last.frame != frame || last.line != mapping.Start.Line
+}
+
+type frameLine struct {
+	frame *Frame
+	line  int
+}
+
+// Done reports whether the program ran to its end or failed.
+func (d *Debugger) Done() bool {
+	return d.err != nil || d.vm.halted()
+}
+
+// Err is the *RuntimeError that stopped the program, if any.
+func (d *Debugger) Err() error {
+	if d.err == nil {
+		return nil
+	}
+
+	return d.err
+}
+
+// Result is the value of the program once it's done, nil before.
+func (d *Debugger) Result() object.Object {
+	if d.err != nil || !d.vm.halted() {
+		return nil
+	}
+
+	return d.vm.LastPoppedStackElem()
+}
+
+// SetBreakpoint stops Continue and the other steps before the instruction
+// at loc runs.
+func (d *Debugger) SetBreakpoint(loc Location) error {
+	ins, ok := d.instructions(loc.Function)
+	if !ok {
+		return fmt.Errorf("constant %d is not a function", loc.Function)
+	}
+	if loc.Offset < 0 || loc.Offset >= len(ins) || instructionStart(ins, loc.Offset) != loc.Offset {
+		return fmt.Errorf("no instruction at offset %d", loc.Offset)
+	}
+
+	d.breakpoints[loc] = true
+
+	return nil
+}
+
+func (d *Debugger) ClearBreakpoint(loc Location) {
+	delete(d.breakpoints, loc)
+}
+
+// SetLineBreakpoint stops whenever a frame arrives at an instruction
+// compiled from line.
+func (d *Debugger) SetLineBreakpoint(line int) {
+	d.lineBreakpoints[line] = true
+}
+
+func (d *Debugger) ClearLineBreakpoint(line int) {
+	delete(d.lineBreakpoints, line)
+}
+
+// Breakpoints lists the offset breakpoints, ordered by function and offset.
+func (d *Debugger) Breakpoints() []Location {
+	locs := []Location{}
+	for loc := range d.breakpoints {
+		locs = append(locs, loc)
+	}
+	slices.SortFunc(locs, func(a, b Location) int {
+		if a.Function != b.Function {
+			return a.Function - b.Function
+		}
+		return a.Offset - b.Offset
+	})
+
+	return locs
+}
+
+// LineBreakpoints lists the line breakpoints in order.
+func (d *Debugger) LineBreakpoints() []int {
+	lines := []int{}
+	for line := range d.lineBreakpoints {
+		lines = append(lines, line)
+	}
+	slices.Sort(lines)
+
+	return lines
+}
+
+// Location is the instruction that runs next.
+func (d *Debugger) Location() Location {
+	frame := d.vm.currentFrame()
+
+	return Location{Function: d.functionIndex(frame), Offset: frame.ip + 1}
+}
+
+// Frame is the state of the current frame.
+func (d *Debugger) Frame() FrameState {
+	return d.frameState(d.vm.framesIndex - 1)
+}
+
+// Frames is the call stack, innermost frame first.
+func (d *Debugger) Frames() []FrameState {
+	frames := []FrameState{}
+	for i := d.vm.framesIndex - 1; i >= 0; i-- {
+		frames = append(frames, d.frameState(i))
+	}
+
+	return frames
+}
+
+func (d *Debugger) frameState(i int) FrameState {
+	frame := d.vm.frames[i]
+
+	state := FrameState{
+		Function:    traceFrame(frame, i == 0).Function,
+		Next:        Location{Function: d.functionIndex(frame), Offset: frame.ip + 1},
+		IP:          frame.ip,
+		BasePointer: frame.basePointer,
+		Free:        slices.Clone(frame.cl.Free),
+	}
+	at := frame.ip
+	if i == d.vm.framesIndex-1 {
+		at++
+	}
+	if mapping, ok := frame.cl.Fn.SourceMap.Lookup(at); ok {
+		state.Position = &mapping.Start
+	}
+
+	return state
+}
+
+// Stack is the live part of the stack, bottom first.
+func (d *Debugger) Stack() []object.Object {
+	return slices.Clone(d.vm.stack[:d.vm.sp])
+}
+
+// Globals holds the globals up to the last one that was set. Unset ones
+// are nil.
+func (d *Debugger) Globals() []object.Object {
+	end := len(d.vm.globals)
+	for end > 0 && d.vm.globals[end-1] == nil {
+		end--
+	}
+
+	return slices.Clone(d.vm.globals[:end])
+}
+
+func (d *Debugger) Constants() []object.Object {
+	return slices.Clone(d.vm.constants)
+}
+
+func (d *Debugger) functionIndex(frame *Frame) int {
+	if i, ok := d.functions[frame.cl.Fn]; ok {
+		return i
+	}
+
+	return MainFunction
+}
+
+func (d *Debugger) instructions(function int) (code.Instructions, bool) {
+	if function == MainFunction {
+		return d.vm.frames[0].Instructions(), true
+	}
+	if function < 0 || function >= len(d.vm.constants) {
+		return nil, false
+	}
+
+	fn, ok := d.vm.constants[function].(*object.CompiledFunction)
+	if !ok {
+		return nil, false
+	}
+
+	return fn.Instructions, true
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// MaxTraceFrames caps how many frames a RuntimeError keeps. Deep recursion
+// would otherwise put up to MaxFrames of them in every error.
+const MaxTraceFrames = 32
+
+// RuntimeError is an error that stopped the VM, together with the frames
+// that were active when it happened.
+type RuntimeError struct {
+	Err error
+	// Frames starts at the innermost frame, the one that failed.
+	Frames []TraceFrame
+	// Omitted counts the outer frames left out past MaxTraceFrames.
+	Omitted int
+}
+
+// TraceFrame is a function that was running when a RuntimeError happened.
+type TraceFrame struct {
+	Function string `json:"function"`
+	// IP is the offset of the instruction the frame was at: the failing one
+	// for the innermost frame, the call for the others.
+	IP int `json:"ip"`
+	// Position is nil when the function has no source map.
+	Position *token.Position `json:"position,omitempty"`
+}
+
+// Error is the message prefixed with the line and column of the failing
+// instruction, when known.
+func (e *RuntimeError) Error() string {
+	if len(e.Frames) > 0 && e.Frames[0].Position != nil {
+		return fmt.Sprintf("%s: %s", e.Frames[0].Position, e.Err)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// Trace renders the message followed by one line per frame, innermost
+// first:
+//
+//	unsupported types for binary operation: INTEGER STRING
+//	    at add (1:24, ip 3)
+//	    at <main> (1:38, ip 12)
+func (e *RuntimeError) Trace() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Err.Error())
+	for _, f := range e.Frames {
+		fmt.Fprintf(&out, "\n    at %s (", f.Function)
+		if f.Position != nil {
+			fmt.Fprintf(&out, "%s, ", f.Position)
+		}
+		fmt.Fprintf(&out, "ip %d)", f.IP)
+	}
+	if e.Omitted > 0 {
+		fmt.Fprintf(&out, "\n    ... %d more frames", e.Omitted)
+	}
+
+	return out.String()
+}
+
+func (e *RuntimeError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Message string       `json:"message"`
+		Frames  []TraceFrame `json:"frames"`
+		Omitted int          `json:"omitted,omitempty"`
+	}{e.Err.Error(), e.Frames, e.Omitted})
+}
+
+// runtimeError wraps err with the frames on the stack. The frames aren't
+// unwound on error, so the current one is where the error happened.
+func (vm *VM) runtimeError(err error) *RuntimeError {
+	rerr := &RuntimeError{Err: object.Localized(err, vm.locale), Frames: []TraceFrame{}}
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		if len(rerr.Frames) == MaxTraceFrames {
+			rerr.Omitted = i + 1
+			break
+		}
+		rerr.Frames = append(rerr.Frames, traceFrame(vm.frames[i], i == 0))
+	}
+
+	return rerr
+}
+
+func traceFrame(frame *Frame, main bool) TraceFrame {
+	fn := frame.cl.Fn
+
+	tf := TraceFrame{
+		Function: functionName(fn, main),
+		IP:       instructionStart(fn.Instructions, frame.ip),
+	}
+
+	if mapping, ok := fn.SourceMap.Lookup(frame.ip); ok {
+		tf.Position = &mapping.Start
+	}
+
+	return tf
+}
+
+// functionName is how traces and errors name fn.
+func functionName(fn *object.CompiledFunction, main bool) string {
+	switch {
+	case main:
+		return "<main>"
+	case fn.Name == "":
+		return "<anonymous>"
+	default:
+		return fn.Name
+	}
+}
+
+// instructionStart finds the offset of the instruction ip is in. The ip of
+// a frame is left on the last operand it read.
+func instructionStart(ins code.Instructions, ip int) int {
+	start := 0
+	for i := 0; i < len(ins) && i <= ip; {
+		start = i
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return ip
+		}
+		_, read := code.ReadOperands(def, ins[i+1:])
+		i += 1 + read
+	}
+
+	return start
+}
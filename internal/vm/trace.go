@@ -0,0 +1,124 @@
+package vm
+
+import (
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
+)
+
+// DefaultTraceLimit caps how many steps a trace keeps, so a long running
+// program doesn't eat all the memory.
+const DefaultTraceLimit = 10_000
+
+const (
+	// MaxTraceValue caps the bytes kept of each value a step shows, the
+	// rest of it being cut and marked with "...".
+	MaxTraceValue = 200
+	// MaxTraceBytes caps the bytes of values a whole trace keeps, as
+	// values pushed over and over would still add up.
+	MaxTraceBytes = 1 << 20
+)
+
+// TraceStep is one instruction a VM ran. The stack is delta encoded: Pop
+// values came off its top, then Push went on, so replaying the steps in
+// order rebuilds the stack before and after each of them.
+type TraceStep struct {
+	Step     int    `json:"step"`
+	Function string `json:"function"`
+	Offset   int    `json:"offset"`
+	Op       string `json:"op"`
+	Operands []int  `json:"operands"`
+	// Depth counts the frames on the stack when the instruction started.
+	Depth int `json:"depth"`
+
+	Pop  int      `json:"pop,omitempty"`
+	Push []string `json:"push,omitempty"`
+	// Globals holds the globals the instruction set, by index.
+	Globals map[int]string `json:"globals,omitempty"`
+
+	// Position is nil when the function has no source map.
+	Position *token.Position `json:"position,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Trace records the instructions a VM runs, in the order they finish. A
+// call a builtin makes back into Monkey, as `map` does, is finished before
+// the instruction that called the builtin, so its steps come first.
+type Trace struct {
+	Steps []TraceStep `json:"steps"`
+	// Truncated is set once steps were dropped for going over the limit or
+	// MaxTraceBytes.
+	Truncated bool `json:"truncated"`
+
+	limit int
+	// The bytes of values kept so far.
+	bytes int
+	// The stack as of the last step, to diff the next one against.
+	stack []object.Object
+}
+
+func NewTrace(limit int) *Trace {
+	return &Trace{
+		Steps: []TraceStep{},
+		limit: limit,
+	}
+}
+
+func (t *Trace) record(vm *VM, frame *Frame, ip, depth int, err error) {
+	if len(t.Steps) >= t.limit || t.bytes >= MaxTraceBytes {
+		t.Truncated = true
+		return
+	}
+
+	ins := frame.Instructions()
+	def, lookupErr := code.Lookup(ins[ip])
+	if lookupErr != nil {
+		return
+	}
+	operands, _ := code.ReadOperands(def, ins[ip+1:])
+
+	step := TraceStep{
+		Step:     len(t.Steps),
+		Function: functionName(frame.cl.Fn, frame == vm.frames[0]),
+		Offset:   ip,
+		Op:       def.Name,
+		Operands: operands,
+		Depth:    depth,
+	}
+
+	if mapping, ok := frame.cl.Fn.SourceMap.Lookup(ip); ok {
+		step.Position = &mapping.Start
+	}
+	if err != nil {
+		step.Error = object.Localize(err, vm.locale)
+	}
+
+	stack := vm.stack[:vm.sp]
+	same := 0
+	for same < len(stack) && same < len(t.stack) && stack[same] == t.stack[same] {
+		same++
+	}
+	step.Pop = len(t.stack) - same
+	for _, obj := range stack[same:] {
+		step.Push = append(step.Push, t.inspect(obj))
+	}
+	t.stack = append(t.stack[:0], stack...)
+
+	if code.Opcode(ins[ip]) == code.OpSetGlobal {
+		step.Globals = map[int]string{operands[0]: t.inspect(vm.globals[operands[0]])}
+	}
+
+	t.Steps = append(t.Steps, step)
+}
+
+// inspect shows obj in at most MaxTraceValue bytes, counting them against
+// MaxTraceBytes.
+func (t *Trace) inspect(obj object.Object) string {
+	s := "null"
+	if obj != nil {
+		s = object.Preview(obj, MaxTraceValue)
+	}
+	t.bytes += len(s)
+
+	return s
+}
@@ -0,0 +1,360 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+)
+
+// VerifyError is the first problem Verify found.
+type VerifyError struct {
+	Location Location `json:"location"`
+	// Function names the function at Location, as in a trace.
+	Function string `json:"function"`
+	Message  string `json:"message"`
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s at %04d: %s", e.Function, e.Location.Offset, e.Message)
+}
+
+// Verify checks bytecode can run on a VM with stdlib without breaking what
+// the VM takes for granted: that every opcode and operand is valid, jumps
+// land on instructions, constants, locals, free variables and builtins
+// exist, and the stack holds what every instruction pops. Each instruction
+// must see the same stack depth on every path to it, and functions must
+// return rather than run off their end.
+//
+// The compiler's own output always passes, so only bytecode from elsewhere
+// needs verifying. It doesn't follow that the program runs without errors:
+// a global may be read before it is set, or OpWith may be given a field
+// name that isn't a string, which the VM reports when it happens.
+func Verify(bytecode *compiler.Bytecode, stdlib *object.Stdlib) error {
+	v := &verifier{
+		constants:  bytecode.Constants,
+		stdlib:     stdlib,
+		setGlobals: map[int]bool{},
+		freeNeeded: map[int]int{},
+	}
+
+	// Every function is decoded first, as instructions check the functions
+	// and globals others use.
+	blocks := []*verifyBlock{{function: compiler.MainFunction, ins: bytecode.Instructions}}
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			blocks = append(blocks, &verifyBlock{function: i, fn: fn, ins: fn.Instructions})
+		}
+	}
+
+	for _, block := range blocks {
+		if err := v.decode(block); err != nil {
+			return err
+		}
+	}
+	for _, block := range blocks {
+		if err := v.checkOperands(block); err != nil {
+			return err
+		}
+		if err := v.checkStack(block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type verifier struct {
+	constants []object.Object
+	stdlib    *object.Stdlib
+
+	// The globals some instruction sets.
+	setGlobals map[int]bool
+	// How many free variables each function reads, by constant index.
+	freeNeeded map[int]int
+}
+
+// verifyBlock is the main program or a compiled function.
+type verifyBlock struct {
+	// The constant index of the function, or compiler.MainFunction.
+	function int
+	fn       *object.CompiledFunction
+	ins      code.Instructions
+
+	list []verifyInstruction
+	// The index in list of the instruction at every offset.
+	indexes map[int]int
+}
+
+type verifyInstruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+}
+
+func (v *verifier) errorf(block *verifyBlock, offset int, format string, a ...any) error {
+	name := "<main>"
+	if block.fn != nil {
+		name = block.fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+	}
+
+	return &VerifyError{
+		Location: Location{Function: block.function, Offset: offset},
+		Function: name,
+		Message:  fmt.Sprintf(format, a...),
+	}
+}
+
+func (v *verifier) decode(block *verifyBlock) error {
+	block.indexes = map[int]int{}
+
+	for offset := 0; offset < len(block.ins); {
+		def, err := code.Lookup(block.ins[offset])
+		if err != nil {
+			return v.errorf(block, offset, "%s", err)
+		}
+		if offset+def.Width() > len(block.ins) {
+			return v.errorf(block, offset, "%s is cut short", def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, block.ins[offset+1:])
+		op := code.Opcode(block.ins[offset])
+
+		switch op {
+		case code.OpSetGlobal:
+			v.setGlobals[operands[0]] = true
+		case code.OpGetFree:
+			if block.fn != nil {
+				v.freeNeeded[block.function] = max(v.freeNeeded[block.function], operands[0]+1)
+			}
+		}
+
+		block.indexes[offset] = len(block.list)
+		block.list = append(block.list, verifyInstruction{
+			offset:   offset,
+			op:       op,
+			def:      def,
+			operands: operands,
+		})
+
+		offset += 1 + read
+	}
+
+	return nil
+}
+
+func (v *verifier) checkOperands(block *verifyBlock) error {
+	for _, in := range block.list {
+		if err := v.checkInstruction(block, in); err != nil {
+			return v.errorf(block, in.offset, "%s", err)
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) checkInstruction(block *verifyBlock, in verifyInstruction) error {
+	inFunction := block.fn != nil
+
+	switch in.op {
+	case code.OpConstant, code.OpConstantWide:
+		_, err := v.constant(in.operands[0])
+		return err
+
+	case code.OpClosure:
+		constant, err := v.constant(in.operands[0])
+		if err != nil {
+			return err
+		}
+		if _, ok := constant.(*object.CompiledFunction); !ok {
+			return fmt.Errorf("constant %d is not a function", in.operands[0])
+		}
+		if needed := v.freeNeeded[in.operands[0]]; in.operands[1] < needed {
+			return fmt.Errorf("the function reads %d free variables, the closure gets %d",
+				needed, in.operands[1])
+		}
+
+	case code.OpGetField:
+		constant, err := v.constant(in.operands[0])
+		if err != nil {
+			return err
+		}
+		if _, ok := constant.(*object.String); !ok {
+			return fmt.Errorf("constant %d is not a field name", in.operands[0])
+		}
+
+	case code.OpGetGlobal:
+		if !v.setGlobals[in.operands[0]] {
+			return fmt.Errorf("global %d is never set", in.operands[0])
+		}
+
+	case code.OpGetLocal, code.OpSetLocal:
+		if !inFunction {
+			return fmt.Errorf("%s outside a function", in.def.Name)
+		}
+		if in.operands[0] >= block.fn.NumLocals {
+			return fmt.Errorf("local %d out of range, the function has %d",
+				in.operands[0], block.fn.NumLocals)
+		}
+
+	case code.OpGetFree:
+		if !inFunction {
+			return fmt.Errorf("%s outside a function", in.def.Name)
+		}
+
+	case code.OpGetBuiltin:
+		if _, ok := v.stdlib.At(in.operands[0]); !ok {
+			return fmt.Errorf("unknown builtin %d", in.operands[0])
+		}
+
+	case code.OpSetBuiltin:
+		return fmt.Errorf("%s is not run by the VM", in.def.Name)
+
+	case code.OpHash, code.OpWith:
+		if in.operands[0]%2 != 0 {
+			return fmt.Errorf("%s takes pairs, got %d values", in.def.Name, in.operands[0])
+		}
+
+	case code.OpReturnValue, code.OpReturn:
+		if !inFunction {
+			return fmt.Errorf("%s outside a function", in.def.Name)
+		}
+
+	case code.OpJump, code.OpJumpNotTruthy:
+		target := in.operands[0]
+		_, ok := block.indexes[target]
+		if !ok && (inFunction || target != len(block.ins)) {
+			return fmt.Errorf("jump to %04d, which is not an instruction", target)
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) constant(index int) (object.Object, error) {
+	if index >= len(v.constants) {
+		return nil, fmt.Errorf("constant %d out of range, the pool has %d", index, len(v.constants))
+	}
+
+	return v.constants[index], nil
+}
+
+// checkStack follows every path through block, working out the depth of
+// the stack of its frame before each instruction.
+func (v *verifier) checkStack(block *verifyBlock) error {
+	end := len(block.list)
+	depths := make([]int, end+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+
+	work := []int{}
+	reach := func(from verifyInstruction, i, depth int) error {
+		if i == end && block.fn != nil {
+			return v.errorf(block, from.offset, "the function runs off its end without returning")
+		}
+
+		switch depths[i] {
+		case -1:
+			depths[i] = depth
+			work = append(work, i)
+		case depth:
+		default:
+			offset := len(block.ins)
+			if i < end {
+				offset = block.list[i].offset
+			}
+			return v.errorf(block, offset, "the stack holds %d values on one path here and %d on another",
+				depths[i], depth)
+		}
+
+		return nil
+	}
+
+	if end == 0 {
+		if block.fn != nil {
+			return v.errorf(block, 0, "the function is empty")
+		}
+		return nil
+	}
+
+	depths[0] = 0
+	work = append(work, 0)
+
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if i == end {
+			continue
+		}
+
+		in := block.list[i]
+		pop, push := stackEffect(in)
+		if depths[i] < pop {
+			return v.errorf(block, in.offset, "%s pops %d values, the stack holds %d",
+				in.def.Name, pop, depths[i])
+		}
+		depth := depths[i] - pop + push
+		if depth > StackSize {
+			return v.errorf(block, in.offset, "stack overflow")
+		}
+
+		switch in.op {
+		case code.OpReturnValue, code.OpReturn:
+			continue
+		case code.OpJump, code.OpJumpNotTruthy:
+			target := end
+			if index, ok := block.indexes[in.operands[0]]; ok {
+				target = index
+			}
+			if err := reach(in, target, depth); err != nil {
+				return err
+			}
+			if in.op == code.OpJump {
+				continue
+			}
+		}
+
+		if err := reach(in, i+1, depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stackEffect is how many values an instruction pops and then pushes.
+func stackEffect(in verifyInstruction) (int, int) {
+	switch in.op {
+	case code.OpConstant, code.OpConstantWide, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree:
+		return 0, 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpGetField:
+		return 1, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal,
+		code.OpSetBuiltin, code.OpReturnValue:
+		return 1, 0
+	case code.OpSlice:
+		return 3, 1
+	case code.OpCall:
+		// The callee and its arguments.
+		return in.operands[0] + 1, 1
+	case code.OpClosure:
+		return in.operands[1], 1
+	case code.OpArray, code.OpHash:
+		return in.operands[0], 1
+	case code.OpWith:
+		// The struct and its new fields.
+		return in.operands[0] + 1, 1
+	}
+
+	return 0, 0
+}
@@ -1,11 +1,14 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
 )
 
 const (
@@ -14,9 +17,9 @@ const (
 )
 
 var (
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
-	Null  = &object.Null{}
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
 )
 
 type VM struct {
@@ -30,13 +33,34 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	// Set while running under RunContext.
+	meter  *object.Meter
+	stdlib *object.Stdlib
+	// Handed to builtins when they are called.
+	runtime *object.Runtime
+	// Error that stopped a function a builtin called back into. It aborts
+	// the run once the builtin returns.
+	callErr error
+
+	// Records every instruction when set.
+	trace *Trace
+	// The locale error messages are worded in, English when nil.
+	locale *token.Locale
 }
 
 const MaxFrames = 1024
 
 func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithStdlib(bytecode, object.DefaultStdlib())
+}
+
+// NewWithStdlib creates a VM whose OpGetBuiltin loads from stdlib, which
+// must be the one the bytecode was compiled with.
+func NewWithStdlib(bytecode *compiler.Bytecode, stdlib *object.Stdlib) *VM {
 	mainFn := &object.CompiledFunction{
 		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
 	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
@@ -44,7 +68,7 @@ func New(bytecode *compiler.Bytecode) *VM {
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
 
-	return &VM{
+	vm := &VM{
 		constants: bytecode.Constants,
 
 		stack: make([]object.Object, StackSize),
@@ -54,7 +78,12 @@ func New(bytecode *compiler.Bytecode) *VM {
 
 		frames:      frames,
 		framesIndex: 1,
+
+		stdlib: stdlib,
 	}
+	vm.runtime = &object.Runtime{IO: object.StdIO(), Caller: vm}
+
+	return vm
 }
 
 func NewWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
@@ -64,6 +93,21 @@ func NewWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// SetTrace records every instruction run from now on into trace.
+func (vm *VM) SetTrace(trace *Trace) {
+	vm.trace = trace
+}
+
+// SetIO redirects the input and output of builtins such as `puts`.
+func (vm *VM) SetIO(io object.IO) {
+	vm.runtime.IO = io
+}
+
+// SetLocale words the error messages of the VM and its builtins in locale.
+func (vm *VM) SetLocale(locale *token.Locale) {
+	vm.locale = locale
+}
+
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
 		return nil
@@ -87,203 +131,322 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// RunContext runs like Run, but stops with an error matching
+// object.ErrBudgetExceeded once the budget runs out, or with the context's
+// error once it is done.
+func (vm *VM) RunContext(ctx context.Context, budget object.Budget) error {
+	vm.meter = object.NewMeter(ctx, budget)
+	defer func() { vm.meter = nil }()
+
+	return vm.Run()
+}
+
+// Run executes the bytecode. An error is returned as a *RuntimeError,
+// which records where the failing instruction and its callers are.
 func (vm *VM) Run() error {
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
-
-		ip := vm.currentFrame().ip
-		ins := vm.currentFrame().Instructions()
-		op := code.Opcode(ins[ip])
-
-		switch op {
-		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			if err := vm.push(vm.constants[constIndex]); err != nil {
-				return err
-			}
-
-		case code.OpAdd, code.OpMul, code.OpSub, code.OpDiv:
-			if err := vm.executeBinaryOperation(op); err != nil {
-				return err
-			}
-
-		case code.OpPop:
-			vm.pop()
-
-		// Literals
-
-		case code.OpTrue:
-			if err := vm.push(True); err != nil {
-				return err
-			}
-
-		case code.OpFalse:
-			if err := vm.push(False); err != nil {
-				return err
-			}
-
-		case code.OpNull:
-			if err := vm.push(Null); err != nil {
-				return err
-			}
-
-		case code.OpArray:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
-			vm.sp = vm.sp - numElements
-
-			if err := vm.push(array); err != nil {
-				return err
-			}
-
-		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - numElements
-
-			if err := vm.push(hash); err != nil {
-				return err
-			}
-
-		case code.OpIndex:
-			index := vm.pop()
-			left := vm.pop()
-
-			if err := vm.executeIndexExpression(left, index); err != nil {
-				return err
-			}
-
-		// Functions
-		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			if err := vm.executeCall(int(numArgs)); err != nil {
-				return err
-			}
-
-		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			// Manual increment index
-			vm.currentFrame().ip += 3
-
-			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
-				return err
-			}
-
-		case code.OpReturnValue:
-			returnValue := vm.pop()
-
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-
-			if err := vm.push(returnValue); err != nil {
-				return err
-			}
-
-		case code.OpReturn:
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-
-			if err := vm.push(Null); err != nil {
-				return err
-			}
-
-		// Relational
-
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			if err := vm.executeComparison(op); err != nil {
-				return err
-			}
-
-		case code.OpBang:
-			if err := vm.executeBangOperator(); err != nil {
-				return err
-			}
-
-		case code.OpMinus:
-			if err := vm.executeMinusOperator(); err != nil {
-				return err
-			}
-
-		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
+	if err := vm.run(0); err != nil {
+		return vm.runtimeError(err)
+	}
+
+	return nil
+}
+
+// run executes instructions until the main function is done, or until the
+// frames above depth have all returned.
+func (vm *VM) run(depth int) error {
+	for vm.framesIndex > depth && !vm.halted() {
+		if err := vm.step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// halted reports whether the current frame has run out of instructions,
+// which only happens to the main function.
+func (vm *VM) halted() bool {
+	return vm.currentFrame().ip >= len(vm.currentFrame().Instructions())-1
+}
+
+// step executes the next instruction of the current frame.
+func (vm *VM) step() error {
+	if vm.meter != nil {
+		if err := vm.meter.Step(); err != nil {
+			return err
+		}
+	}
+
+	if vm.trace == nil {
+		return vm.execute()
+	}
+
+	frame, depth := vm.currentFrame(), vm.framesIndex
+	ip := frame.ip + 1
+
+	err := vm.execute()
+	vm.trace.record(vm, frame, ip, depth, err)
+
+	return err
+}
+
+func (vm *VM) execute() error {
+	vm.currentFrame().ip++
+
+	ip := vm.currentFrame().ip
+	ins := vm.currentFrame().Instructions()
+	op := code.Opcode(ins[ip])
+
+	switch op {
+	case code.OpConstant:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		if err := vm.push(vm.constants[constIndex]); err != nil {
+			return err
+		}
+
+	case code.OpConstantWide:
+		constIndex := code.ReadUint32(ins[ip+1:])
+		vm.currentFrame().ip += 4
+		if err := vm.push(vm.constants[constIndex]); err != nil {
+			return err
+		}
+
+	case code.OpAdd, code.OpMul, code.OpSub, code.OpDiv:
+		if err := vm.executeBinaryOperation(op); err != nil {
+			return err
+		}
+
+	case code.OpPop:
+		vm.pop()
 
-		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+	// Literals
 
-			condition := vm.pop()
-			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
-			}
+	case code.OpTrue:
+		if err := vm.push(True); err != nil {
+			return err
+		}
+
+	case code.OpFalse:
+		if err := vm.push(False); err != nil {
+			return err
+		}
+
+	case code.OpNull:
+		if err := vm.push(Null); err != nil {
+			return err
+		}
+
+	case code.OpArray:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+
+		array := vm.buildArray(vm.sp-numElements, vm.sp)
+		vm.sp = vm.sp - numElements
+
+		if err := vm.pushNew(array); err != nil {
+			return err
+		}
+
+	case code.OpHash:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+
+		hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+		if err != nil {
+			return err
+		}
+		vm.sp = vm.sp - numElements
 
-		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+		if err := vm.pushNew(hash); err != nil {
+			return err
+		}
+
+	case code.OpIndex:
+		index := vm.pop()
+		left := vm.pop()
+
+		if err := vm.executeIndexExpression(left, index); err != nil {
+			return err
+		}
+
+	case code.OpSlice:
+		end := vm.pop()
+		start := vm.pop()
+		left := vm.pop()
 
-			vm.globals[globalIndex] = vm.pop()
+		result, err := object.Slice(left, start, end)
+		if err != nil {
+			return err
+		}
 
-		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+		if err := vm.pushNew(result); err != nil {
+			return err
+		}
 
-			if err := vm.push(vm.globals[globalIndex]); err != nil {
-				return err
-			}
+	case code.OpGetField:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
 
-		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		name := vm.constants[constIndex].(*object.String)
+		value, err := object.GetField(vm.pop(), name.Value)
+		if err != nil {
+			return err
+		}
 
-			frame := vm.currentFrame()
+		if err := vm.push(value); err != nil {
+			return err
+		}
 
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+	case code.OpWith:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
 
-		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		result, err := vm.buildWith(vm.sp-numElements, vm.sp)
+		if err != nil {
+			return err
+		}
+		vm.sp = vm.sp - numElements - 1
 
-			frame := vm.currentFrame()
+		if err := vm.pushNew(result); err != nil {
+			return err
+		}
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
-			if err != nil {
-				return err
-			}
+	// Functions
+	case code.OpCall:
+		numArgs := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
 
-		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		if err := vm.executeCall(int(numArgs)); err != nil {
+			return err
+		}
 
-			definition := object.Builtins[builtinIndex]
+	case code.OpClosure:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		numFree := code.ReadUint8(ins[ip+3:])
+		// Manual increment index
+		vm.currentFrame().ip += 3
+
+		if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+			return err
+		}
+
+	case code.OpReturnValue:
+		returnValue := vm.pop()
+
+		frame := vm.popFrame()
+		if vm.meter != nil {
+			vm.meter.Return()
+		}
+		vm.sp = frame.basePointer - 1
+
+		if err := vm.push(returnValue); err != nil {
+			return err
+		}
+
+	case code.OpReturn:
+		frame := vm.popFrame()
+		if vm.meter != nil {
+			vm.meter.Return()
+		}
+		vm.sp = frame.basePointer - 1
+
+		if err := vm.push(Null); err != nil {
+			return err
+		}
+
+	// Relational
+
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		if err := vm.executeComparison(op); err != nil {
+			return err
+		}
+
+	case code.OpBang:
+		if err := vm.executeBangOperator(); err != nil {
+			return err
+		}
+
+	case code.OpMinus:
+		if err := vm.executeMinusOperator(); err != nil {
+			return err
+		}
+
+	case code.OpJump:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip = pos - 1
+
+	case code.OpJumpNotTruthy:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+
+		condition := vm.pop()
+		if !isTruthy(condition) {
+			vm.currentFrame().ip = pos - 1
+		}
 
-			if err := vm.push(definition.Builtin); err != nil {
-				return err
-			}
+	case code.OpSetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
 
-		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		vm.globals[globalIndex] = vm.pop()
 
-			currentClosure := vm.currentFrame().cl
-			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
-				return err
-			}
+	case code.OpGetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
 
-		default:
-			panic(fmt.Sprintf("unexpected code.Opcode: %+v", op))
+		global := vm.globals[globalIndex]
+		if global == nil {
+			return object.Errorf("global %d is read before it is set", globalIndex)
 		}
 
+		if err := vm.push(global); err != nil {
+			return err
+		}
+
+	case code.OpSetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		frame := vm.currentFrame()
+
+		vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+	case code.OpGetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		frame := vm.currentFrame()
+
+		err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+		if err != nil {
+			return err
+		}
+
+	case code.OpGetBuiltin:
+		builtinIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		builtin, ok := vm.stdlib.At(int(builtinIndex))
+		if !ok {
+			return object.Errorf("unknown builtin: %d", builtinIndex)
+		}
+
+		if err := vm.push(builtin); err != nil {
+			return err
+		}
+
+	case code.OpGetFree:
+		freeIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+
+		currentClosure := vm.currentFrame().cl
+		if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
+			return err
+		}
+
+	default:
+		panic(fmt.Sprintf("unexpected code.Opcode: %+v", op))
 	}
 
 	return nil
@@ -291,7 +454,7 @@ func (vm *VM) Run() error {
 
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+		return object.Errorf("stack overflow")
 	}
 
 	vm.stack[vm.sp] = o
@@ -300,11 +463,28 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// pushNew pushes an object the VM just created, charging it to the budget.
+func (vm *VM) pushNew(o object.Object) error {
+	if err := vm.alloc(o); err != nil {
+		return err
+	}
+
+	return vm.push(o)
+}
+
+func (vm *VM) alloc(o object.Object) error {
+	if vm.meter == nil {
+		return nil
+	}
+
+	return vm.meter.Alloc(o)
+}
+
 func (vm *VM) pushClosure(constIndex, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
 	if !ok {
-		return fmt.Errorf("not a function: %+v", constant)
+		return object.Errorf("not a function: %+v", constant)
 	}
 
 	free := make([]object.Object, numFree)
@@ -315,7 +495,7 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 
 	closure := &object.Closure{Fn: function, Free: free}
 
-	return vm.push(closure)
+	return vm.pushNew(closure)
 }
 
 func (vm *VM) pop() object.Object {
@@ -338,20 +518,40 @@ func (vm *VM) executeCall(numArgs int) error {
 		return vm.callClosure(callee, numArgs)
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
+	case *object.StructType:
+		return vm.callStruct(callee, numArgs)
 	default:
-		return fmt.Errorf("calling non-function and non-built-in")
+		return object.Errorf("calling non-function and non-built-in")
 	}
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+		return object.Errorf("wrong number of arguments: want=%d, got=%d",
 			cl.Fn.NumParameters, numArgs)
 	}
 
+	if vm.framesIndex >= MaxFrames {
+		return object.Errorf("stack overflow: more than %d nested calls", MaxFrames-1)
+	}
+
+	if vm.meter != nil {
+		if err := vm.meter.Call(); err != nil {
+			return err
+		}
+	}
+
 	frame := NewFrame(cl, vm.sp-numArgs)
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return object.Errorf("stack overflow")
+	}
 	vm.pushFrame(frame)
 
+	// Locals that aren't parameters start out null rather than holding
+	// whatever an earlier call left on the stack.
+	for i := frame.basePointer + numArgs; i < frame.basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = object.NULL
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
 	return nil
@@ -360,9 +560,18 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 func (vm *VM) callBuiltin(fn *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
-	result := fn.Fn(args...)
+	result := object.LocalizeObject(fn.Call(vm.runtime, args...), vm.locale)
 	vm.sp = vm.sp - numArgs - 1
 
+	if err := vm.callErr; err != nil {
+		vm.callErr = nil
+		return err
+	}
+
+	if err := vm.alloc(result); err != nil {
+		return err
+	}
+
 	if result != nil {
 		vm.push(result)
 	} else {
@@ -372,6 +581,69 @@ func (vm *VM) callBuiltin(fn *object.Builtin, numArgs int) error {
 	return nil
 }
 
+func (vm *VM) callStruct(def *object.StructType, numArgs int) error {
+	instance, err := def.New(vm.stack[vm.sp-numArgs : vm.sp])
+	if err != nil {
+		return err
+	}
+	vm.sp = vm.sp - numArgs - 1
+
+	return vm.pushNew(instance)
+}
+
+// Call applies fn on behalf of a builtin. The arguments are pushed above
+// whatever the builtin's caller left on the stack, and a closure runs to
+// completion in a nested loop before its value is handed back.
+func (vm *VM) Call(fn object.Object, args ...object.Object) object.Object {
+	if vm.callErr != nil {
+		return object.NewError("%s", vm.callErr)
+	}
+
+	base := vm.sp
+	depth := vm.framesIndex
+
+	for _, o := range append([]object.Object{fn}, args...) {
+		if err := vm.push(o); err != nil {
+			return vm.failCall(err, base, depth)
+		}
+	}
+
+	if err := vm.executeCall(len(args)); err != nil {
+		return vm.failCall(err, base, depth)
+	}
+
+	if err := vm.run(depth); err != nil {
+		return vm.failCall(err, base, depth)
+	}
+
+	result := vm.pop()
+	vm.sp = base
+
+	return result
+}
+
+// failCall ends a call that failed. Running out of budget or time stops the
+// whole program once the builtin returns, as it does in the evaluator. Any
+// other error unwinds the frames of the call and is handed to the builtin
+// as an ERROR value, like the evaluator's errors are.
+func (vm *VM) failCall(err error, base, depth int) object.Object {
+	if errors.Is(err, object.ErrBudgetExceeded) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		vm.callErr = err
+		return object.NewError("%s", err)
+	}
+
+	for vm.framesIndex > depth {
+		vm.popFrame()
+		if vm.meter != nil {
+			vm.meter.Return()
+		}
+	}
+	vm.sp = base
+
+	return object.NewError("%s", err)
+}
+
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	elements := make([]object.Object, endIndex-startIndex)
 
@@ -379,27 +651,41 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 		elements[i-startIndex] = vm.stack[i]
 	}
 
-	return &object.Array{Elements: elements}
+	return object.NewArray(elements)
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
+		if !hash.Set(key, value) {
+			return nil, object.Errorf("unusable as hash key: %s", key.Type())
+		}
+	}
+
+	return hash, nil
+}
 
-		hashKey, ok := key.(object.Hashable)
+// buildWith applies OpWith to the struct just below the field name and
+// value pairs between startIndex and endIndex.
+func (vm *VM) buildWith(startIndex, endIndex int) (object.Object, error) {
+	names := []string{}
+	values := []object.Object{}
+
+	for i := startIndex; i < endIndex; i += 2 {
+		name, ok := vm.stack[i].(*object.String)
 		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			return nil, object.Errorf("field name must be STRING, got=%s", vm.stack[i].Type())
 		}
 
-		hashedPairs[hashKey.HashKey()] = pair
+		names = append(names, name.Value)
+		values = append(values, vm.stack[i+1])
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return object.With(vm.stack[startIndex-1], names, values)
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
@@ -407,40 +693,41 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
 
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.push(object.IndexString(left.(*object.String), index.(*object.Integer).Value))
+
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 
 	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
+		return object.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
 
-	if idx < 0 || idx > max {
+	idx, ok := object.ResolveIndex(index.(*object.Integer).Value, arrayObject.Len())
+	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(arrayObject.Elements[idx])
+	return vm.push(arrayObject.At(idx))
 }
 
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	if !object.IsHashable(index) {
+		return object.Errorf("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(index)
 	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(value)
 }
 
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
@@ -451,14 +738,14 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	leftType := left.Type()
 
 	switch {
-	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return vm.executeBinaryIntegerOperation(op, left, right)
 
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 
 	default:
-		return fmt.Errorf(
+		return object.Errorf(
 			"unsupported types for binary operation: %s %s",
 			leftType,
 			rightType,
@@ -470,27 +757,27 @@ func (vm *VM) executeBinaryIntegerOperation(
 	op code.Opcode,
 	left, right object.Object,
 ) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-
-	var result int64
+	var operator string
 
 	switch op {
 	case code.OpAdd:
-		result = leftValue + rightValue
+		operator = "+"
 	case code.OpSub:
-		result = leftValue - rightValue
+		operator = "-"
 	case code.OpMul:
-		result = leftValue * rightValue
+		operator = "*"
 	case code.OpDiv:
-		result = leftValue / rightValue
+		operator = "/"
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return object.Errorf("unknown integer operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{
-		Value: result,
-	})
+	result, err := object.IntegerArithmetic(operator, left, right)
+	if err != nil {
+		return err
+	}
+
+	return vm.pushNew(result)
 }
 
 func (vm *VM) executeBinaryStringOperation(
@@ -506,10 +793,10 @@ func (vm *VM) executeBinaryStringOperation(
 	case code.OpAdd:
 		result = leftValue + rightValue
 	default:
-		return fmt.Errorf("unknown string operator: %d", op)
+		return object.Errorf("unknown string operator: %d", op)
 	}
 
-	return vm.push(&object.String{
+	return vm.pushNew(&object.String{
 		Value: result,
 	})
 }
@@ -518,9 +805,12 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ || right.Type() == object.INTEGER_OBJ {
+	if object.IsInteger(left) && object.IsInteger(right) {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -528,7 +818,7 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	case code.OpNotEqual:
 		return vm.push(nativeBoolToBooleanObject(right != left))
 	default:
-		return fmt.Errorf(
+		return object.Errorf(
 			"unknown operator: %d (%s %s)",
 			op,
 			left.Type(),
@@ -541,18 +831,36 @@ func (vm *VM) executeIntegerComparison(
 	op code.Opcode,
 	left, right object.Object,
 ) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	cmp := object.CompareIntegers(left, right)
 
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+		return vm.push(nativeBoolToBooleanObject(cmp == 0))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+		return vm.push(nativeBoolToBooleanObject(cmp != 0))
 	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+		return vm.push(nativeBoolToBooleanObject(cmp > 0))
+	default:
+		return object.Errorf("unknown operator: %d", op)
+	}
+}
+
+// Strings are compared by value, as equal strings needn't be the same
+// object: some are constants, others are built at runtime.
+func (vm *VM) executeStringComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return object.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
 	}
 }
 
@@ -582,12 +890,11 @@ func (vm *VM) executeBangOperator() error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	if !object.IsInteger(operand) {
+		return object.Errorf("unsupported type for negation: %s", operand.Type())
 	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.pushNew(object.NegateInteger(operand))
 }
 
 func isTruthy(obj object.Object) bool {
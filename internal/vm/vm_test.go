@@ -1,14 +1,21 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/ast"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/code"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/compiler"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/lexer"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/object"
 	"github.com/ZeroBl21/go-monkey-visualizer/internal/parser"
+	"github.com/ZeroBl21/go-monkey-visualizer/internal/token"
 )
 
 type vmTestCase struct {
@@ -16,6 +23,11 @@ type vmTestCase struct {
 	expected any
 }
 
+type vmInspectTestCase struct {
+	input    string
+	expected string
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
@@ -45,6 +57,11 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + " banana"`, "monkey banana"},
+		// Folding the concatenation must not change what it equals.
+		{`"a" + "b" == "ab"`, true},
+		{`"a" + "b" != "ab"`, false},
+		{`let a = "a"; a + "b" == "a" + "b"`, true},
+		{`"a" == "b"`, false},
 	}
 
 	runVmTests(t, tests)
@@ -94,19 +111,19 @@ func TestArrayLiterals(t *testing.T) {
 
 func TestHashLiterals(t *testing.T) {
 	tests := []vmTestCase{
-		{"{}", map[object.HashKey]int64{}},
+		{"{}", map[object.Object]int64{}},
 		{
 			"{1: 2, 2: 3}",
-			map[object.HashKey]int64{
-				(&object.Integer{Value: 1}).HashKey(): 2,
-				(&object.Integer{Value: 2}).HashKey(): 3,
+			map[object.Object]int64{
+				&object.Integer{Value: 1}: 2,
+				&object.Integer{Value: 2}: 3,
 			},
 		},
 		{
 			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
-			map[object.HashKey]int64{
-				(&object.Integer{Value: 2}).HashKey(): 4,
-				(&object.Integer{Value: 6}).HashKey(): 16,
+			map[object.Object]int64{
+				&object.Integer{Value: 2}: 4,
+				&object.Integer{Value: 6}: 16,
 			},
 		},
 	}
@@ -123,7 +140,9 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"[][0]", Null},
 		{"[1, 2, 3][99]", Null},
-		{"[1][-1]", Null},
+		{"[1][-1]", 1},
+		{"[1, 2, 3][-3]", 1},
+		{"[1][-2]", Null},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
 	}
@@ -290,15 +309,15 @@ func TestCallingFunctionsWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn() { 1; }(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `1:1: wrong number of arguments: want=0, got=1`,
 		},
 		{
 			input:    `fn(a) { a; }();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `1:1: wrong number of arguments: want=1, got=0`,
 		},
 		{
 			input:    `fn(a, b) { a + b; }(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `1:1: wrong number of arguments: want=2, got=1`,
 		},
 	}
 
@@ -322,6 +341,52 @@ func TestCallingFunctionsWrongArguments(t *testing.T) {
 	}
 }
 
+func TestBudgets(t *testing.T) {
+	loop := "let loop = fn(n) { loop(n + 1) }; loop(0);"
+	grow := `let grow = fn(xs) { grow(push(xs, len(xs))) }; grow([]);`
+	double := `let double = fn(s) { double(s + s) }; double("ab");`
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		budget   object.Budget
+		expected error
+		limit    string
+	}{
+		{"1 + 2", context.Background(), object.Budget{Steps: 100, Depth: 10, Allocs: 10}, nil, ""},
+		{loop, context.Background(), object.Budget{Depth: 100}, object.ErrBudgetExceeded, "depth"},
+		{loop, context.Background(), object.Budget{Steps: 1_000}, object.ErrBudgetExceeded, "steps"},
+		{grow, context.Background(), object.Budget{Depth: 900, Allocs: 1_500}, object.ErrBudgetExceeded, "allocs"},
+		{double, context.Background(), object.Budget{Depth: 900, Allocs: 5_000}, object.ErrBudgetExceeded, "allocs"},
+		{loop, cancelled, object.Budget{Depth: 900}, context.Canceled, ""},
+	}
+
+	for _, tt := range tests {
+		vm := New(compile(t, tt.input))
+		err := vm.RunContext(tt.ctx, tt.budget)
+		if !errors.Is(err, tt.expected) {
+			t.Fatalf("%q: wrong error. want=%v, got=%v", tt.input, tt.expected, err)
+		}
+
+		var budgetErr *object.BudgetError
+		if errors.As(err, &budgetErr) && budgetErr.Limit != tt.limit {
+			t.Fatalf("%q: wrong limit exceeded. want=%s, got=%s", tt.input, tt.limit, budgetErr.Limit)
+		}
+	}
+}
+
+func TestFrameOverflow(t *testing.T) {
+	vm := New(compile(t, "let loop = fn() { loop() }; loop();"))
+	err := vm.Run()
+
+	expected := fmt.Sprintf("1:19: stack overflow: more than %d nested calls", MaxFrames-1)
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%v", expected, err)
+	}
+}
+
 func TestFunctionsWithReturnStatement(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -511,6 +576,17 @@ func TestClosures(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestOperatorDeclarations(t *testing.T) {
+	tests := []vmTestCase{
+		{"infixl 45 <+> = fn(a, b) { a * 10 + b }; 1 <+> 2 <+> 3", 123},
+		{"infixr 45 <+> = fn(a, b) { a * 10 + b }; 1 <+> 2 <+> 3", 33},
+		{"infixl 45 <+> = fn(a, b) { a * 10 + b }; 1 + 2 <+> 3 * 2", 27},
+		{"infixl 45 %% = fn(a, b) { if (a < b) { a } else { (a - b) %% b } }; 17 %% 5", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -565,6 +641,919 @@ func TestGlobalLetStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestConstantWide(t *testing.T) {
+	constants := make([]object.Object, 1<<16)
+	for i := range constants {
+		constants[i] = &object.Integer{Value: int64(i)}
+	}
+
+	comp := compiler.NewWithState(compiler.NewSymbolTable(), constants)
+	if err := comp.Compile(parse(`"wide" + "r"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, "wider", vm.LastPoppedStackElem())
+}
+
+func TestAssembledProgram(t *testing.T) {
+	input := `
+.const one 1
+.const limit 5
+
+; Counts to 5 in a global.
+    OpConstant one
+    OpSetGlobal 0
+loop:
+    OpGetGlobal 0
+    OpConstant limit
+    OpGreaterThan
+    OpJumpNotTruthy next
+    OpJump done
+next:
+    OpGetGlobal 0
+    OpConstant one
+    OpAdd
+    OpSetGlobal 0
+    OpJump loop
+done:
+    OpGetGlobal 0
+    OpPop
+`
+
+	bytecode, err := compiler.Assemble(input, object.DefaultStdlib())
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	vm := New(bytecode)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 6, vm.LastPoppedStackElem())
+
+	bytecode, err = compiler.Assemble(".const s \"a\"\n  OpTrue\n  OpConstant s\n  OpAdd", object.DefaultStdlib())
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	err = New(bytecode).Run()
+	want := "4:3: unsupported types for binary operation: BOOLEAN STRING"
+	if err == nil || err.Error() != want {
+		t.Fatalf("wrong error. want=%q, got=%v", want, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{".const a 1\n OpConstant a\n OpPop", ""},
+		{" OpConstant 1", "<main> at 0000: constant 1 out of range, the pool has 0"},
+		{" OpTrue\n OpJump 2", "<main> at 0001: jump to 0002, which is not an instruction"},
+		{" OpPop", "<main> at 0000: OpPop pops 1 values, the stack holds 0"},
+		{" OpTrue\n OpGetField 0", "<main> at 0001: constant 0 out of range, the pool has 0"},
+		{" OpGetGlobal 3", "<main> at 0000: global 3 is never set"},
+		{" OpGetLocal 0", "<main> at 0000: OpGetLocal outside a function"},
+		{" OpGetBuiltin 200", "<main> at 0000: unknown builtin 200"},
+		{" OpTrue\n OpReturnValue", "<main> at 0001: OpReturnValue outside a function"},
+		{" OpTrue\n OpTrue\n OpHash 1", "<main> at 0002: OpHash takes pairs, got 1 values"},
+		{
+			".const s \"s\"\n OpClosure s 0",
+			"<main> at 0000: constant 0 is not a function",
+		},
+		{
+			".func f 0\n OpGetFree 1\n OpReturnValue\n.end\n OpClosure f 1",
+			"<main> at 0000: the function reads 2 free variables, the closure gets 1",
+		},
+		{
+			".func f 1\n OpGetLocal 1\n OpReturnValue\n.end",
+			"f at 0000: local 1 out of range, the function has 1",
+		},
+		{
+			".func f 0\n OpTrue\n OpPop\n.end",
+			"f at 0001: the function runs off its end without returning",
+		},
+		{
+			// Only one path pushes a value before they meet.
+			" OpTrue\n OpJumpNotTruthy join\n OpTrue\njoin:\n OpNull\n OpPop",
+			"<main> at 0005: the stack holds 0 values on one path here and 1 on another",
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode, err := compiler.Assemble(tt.input, object.DefaultStdlib())
+		if err != nil {
+			t.Fatalf("assembler error: %s", err)
+		}
+
+		err = Verify(bytecode, object.DefaultStdlib())
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error.\nwant=%q\ngot =%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// What the assembler can't write.
+	raw := []struct {
+		ins      code.Instructions
+		expected string
+	}{
+		{code.Instructions{255}, "<main> at 0000: opcode 255 undefined"},
+		{code.Instructions{byte(code.OpConstant), 0}, "<main> at 0000: OpConstant is cut short"},
+	}
+
+	for _, tt := range raw {
+		err := Verify(&compiler.Bytecode{Instructions: tt.ins}, object.DefaultStdlib())
+
+		var verifyErr *VerifyError
+		if !errors.As(err, &verifyErr) || err.Error() != tt.expected {
+			t.Errorf("wrong error.\nwant=%q\ngot =%v", tt.expected, err)
+		}
+	}
+}
+
+// Verified bytecode may still read a variable before setting it, or name a
+// field with something other than a string, which must fail as a runtime
+// error rather than crash the VM.
+func TestVerifiedRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			".const s \"s\"\n OpGetGlobal 0\n OpGetGlobal 0\n OpAdd\n OpPop\n OpConstant s\n OpSetGlobal 0",
+			"2:2: global 0 is read before it is set",
+		},
+		{
+			".func f 0 1\n OpGetLocal 0\n OpGetLocal 0\n OpAdd\n OpReturnValue\n.end\n OpClosure f 0\n OpCall 0\n OpPop",
+			"4:2: unsupported types for binary operation: NULL NULL",
+		},
+		{
+			".const one 1\n OpTrue\n OpConstant one\n OpTrue\n OpWith 2\n OpPop",
+			"5:2: field name must be STRING, got=INTEGER",
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode, err := compiler.Assemble(tt.input, object.DefaultStdlib())
+		if err != nil {
+			t.Fatalf("assembler error: %s", err)
+		}
+		if err := Verify(bytecode, object.DefaultStdlib()); err != nil {
+			t.Fatalf("%q: verify error: %s", tt.input, err)
+		}
+
+		err = New(bytecode).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error.\nwant=%q\ngot =%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + "a"`, "1:1: tipos no soportados para la operación binaria: INTEGER STRING"},
+		{"len(1)", "argumento de `len` no soportado, se obtuvo=INTEGER"},
+		{`map([1], fn(x) { x + "a" })`, "tipos no soportados para la operación binaria: INTEGER STRING"},
+		{"1 / 0", "1:1: división por cero"},
+	}
+
+	for _, tt := range tests {
+		trace := NewTrace(DefaultTraceLimit)
+		vm := New(compile(t, tt.input))
+		vm.SetLocale(token.Spanish)
+		vm.SetTrace(trace)
+
+		if err := vm.Run(); err != nil {
+			if err.Error() != tt.expected {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, err)
+			}
+			if last := trace.Steps[len(trace.Steps)-1]; !strings.HasSuffix(tt.expected, last.Error) {
+				t.Errorf("%q: wrong trace error. got=%q", tt.input, last.Error)
+			}
+			continue
+		}
+
+		errObj, ok := vm.LastPoppedStackElem().(*object.Error)
+		if !ok || errObj.Message != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, vm.LastPoppedStackElem())
+		}
+	}
+
+	vm := New(compile(t, "let loop = fn(n) { loop(n + 1) }; loop(0);"))
+	vm.SetLocale(token.Spanish)
+	err := vm.RunContext(context.Background(), object.Budget{Depth: 10})
+
+	want := "presupuesto de ejecución agotado: se alcanzó el límite de 10 de profundidad"
+	if !errors.Is(err, object.ErrBudgetExceeded) || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("wrong budget error. want=%q, got=%v", want, err)
+	}
+}
+
+func TestBuiltinIO(t *testing.T) {
+	var stdout bytes.Buffer
+	vm := New(compile(t, `let name = gets(); puts("hello", name); puts([1, 2]); gets(); gets()`))
+	vm.SetIO(object.IO{Stdout: &stdout, Stdin: strings.NewReader("monkey\nlast")})
+
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, Null, vm.LastPoppedStackElem())
+
+	expected := "hello\nmonkey\n[1, 2]\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, stdout.String())
+	}
+}
+
+func TestCustomStdlib(t *testing.T) {
+	stdlib := object.DefaultStdlib().Only("len")
+	stdlib.Register(&object.Builtin{
+		Name:   "double",
+		Params: []object.Param{{Name: "n", Types: []object.ObjectType{object.INTEGER_OBJ}}},
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		},
+	})
+
+	comp := compiler.NewWithStdlib(stdlib)
+	if err := comp.Compile(parse(`double(len("abc"))`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := NewWithStdlib(comp.Bytecode(), stdlib)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, 6, vm.LastPoppedStackElem())
+
+	err := compiler.NewWithStdlib(stdlib).Compile(parse(`puts("hi")`))
+	if err == nil || err.Error() != "undefined variable puts" {
+		t.Fatalf("puts should not compile without it in the stdlib. got=%v", err)
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`map(["a", "bb"], len)`, "[1, 2]"},
+		{`map([[1, 2], [3]], fn(xs) { reduce(xs, 0, fn(a, b) { a + b }) })`, "[3, 3]"},
+		{`len(map(range(0, 5000), fn(x) { x }))`, "5000"},
+		{`filter(range(0, 10), fn(x) { x > 6 })`, "[7, 8, 9]"},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, "10"},
+		{`reduce([], "empty", fn(acc, x) { x })`, "empty"},
+		{`sort_by([3, 1, 2], fn(x) { -x })`, "[3, 2, 1]"},
+		{`sort_by(["bb", "a", "cc"], fn(s) { len(s) })`, "[a, bb, cc]"},
+		{`sort_by([1, "a"], fn(x) { x })`, "ERROR: keys of `sort_by` must be all INTEGER or all STRING, got=STRING"},
+		{`each([1, 2], fn(x) { x })`, "null"},
+		{`range(0, 3)`, "[0, 1, 2]"},
+		{`range(3, 0)`, "[]"},
+		{`map(1, fn(x) { x })`, "ERROR: argument to `map` must be ARRAY, got=INTEGER"},
+		{`map([1], 1)`, "ERROR: argument to `map` not supported, got=INTEGER"},
+		{`map([1], fn(x) { x + "a" })`, "ERROR: unsupported types for binary operation: INTEGER STRING"},
+		{`map([1], fn(x) { map([x], fn(y) { y() }) })`, "ERROR: calling non-function and non-built-in"},
+		// The failed call is unwound before the program goes on.
+		{`let f = fn(x) { fn() { x + "a" }() }; [map([1], f), map([2], fn(x) { x })]`,
+			"[ERROR: unsupported types for binary operation: INTEGER STRING, [2]]"},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+// A callback that fails hands the builtin an ERROR value, as in the
+// evaluator, which is tested against the same table.
+func TestHigherOrderBuiltinErrors(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{`reduce([1], 0, fn(x) { x })`, "ERROR: wrong number of arguments: want=1, got=2"},
+		{`map([1], fn(x) { first(x) })`, "ERROR: argument to `first` must be ARRAY, got=INTEGER"},
+		{
+			`map([[1]], fn(xs) { map(xs, fn(x) { reduce([x], 0, fn(a) { a }) }) })`,
+			"ERROR: wrong number of arguments: want=1, got=2",
+		},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"héllo"[5]`, "null"},
+		{`"héllo"[-1]`, "o"},
+		{`"héllo"[-6]`, "null"},
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`split("añb", "")`, "[a, ñ, b]"},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join([], "-")`, ""},
+		{`join(["a", 1], "-")`, "ERROR: argument to `join` must be ARRAY of STRING, got=INTEGER at index 1"},
+		{`trim("  hi  ")`, "hi"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ÀB")`, "àb"},
+		{`contains("monkey", "key")`, "true"},
+		{`if (contains("monkey", "cat")) { 1 } else { 2 }`, "2"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`index_of("héllo", "l")`, "2"},
+		{`index_of("héllo", "z")`, "-1"},
+		{`substr("héllo", 1, 3)`, "éll"},
+		{`substr("héllo", 3, 10)`, "lo"},
+		{`substr("héllo", -2, 2)`, "hé"},
+		{`substr("héllo", 9, 2)`, ""},
+		{`chars("añ")`, "[a, ñ]"},
+		{`format("{} + {} = {}", 1, 2, "three")`, "1 + 2 = three"},
+		{`format("{{}} {}", [1])`, "{} [1]"},
+		{`format("{} {}", 1)`, "ERROR: not enough values for `format`: got=1"},
+		{`format("{}", 1, 2)`, "ERROR: too many values for `format`: want=1, got=2"},
+		{`format()`, "ERROR: wrong number of arguments. got=0, want at least 1"},
+		{`to_int(" 42 ") + 1`, "43"},
+		{`to_int("4x2")`, "null"},
+		{`to_int(7)`, "7"},
+		{`to_string(12) + "!"`, "12!"},
+		{`to_string([1, "a"])`, "[1, a]"},
+		{`upper(1)`, "ERROR: argument to `upper` must be STRING, got=INTEGER"},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+		{"[1, 2, 3, 4][:2]", "[1, 2]"},
+		{"[1, 2, 3, 4][2:]", "[3, 4]"},
+		{"[1, 2, 3, 4][:]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4][-2:]", "[3, 4]"},
+		{"[1, 2, 3, 4][:-1]", "[1, 2, 3]"},
+		{"[1, 2, 3, 4][-10:10]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4][3:1]", "[]"},
+		{"[1, 2, 3, 4][9:]", "[]"},
+		{"let xs = [1, 2]; let ys = xs[:]; push(ys, 3); xs", "[1, 2]"},
+		{`"héllo"[1:4]`, "éll"},
+		{`"héllo"[-3:]`, "llo"},
+		{`"héllo"[5:]`, ""},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+func TestSliceErrors(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{`[1, 2]["a":]`, "1:1: slice bound must be INTEGER, got=STRING"},
+		{`{1: 2}[0:1]`, "1:1: slice operator not supported: HASH"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`keys({"b": 1, "a": 2, 3: 3, true: 4})`, "[b, a, 3, true]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`entries({"b": 1, "a": 2})`, "[[b, 1], [a, 2]]"},
+		{`{"x": 1, "y": 2, "x": 3}`, "{x: 3, y: 2}"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`has({"a": 1}, [len])`, "ERROR: unusable as hash key: ARRAY"},
+		{`has({[1, 2]: "a"}, [1, 2])`, "true"},
+		{`{[1, [2]]: "nested"}[[1, [2]]]`, "nested"},
+		{`{[1, 2]: "a", [2, 1]: "b"}[[2, 1]]`, "b"},
+		{`{{"x": 1, "y": 2}: "point"}[{"y": 2, "x": 1}]`, "point"},
+		{`{{"x": 1}: "point"}[{"x": 2}]`, "null"},
+		{`delete({[1]: 1, [2]: 2}, [1])`, "{[2]: 2}"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+		{`delete({"a": 1}, "z")`, "{a: 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+		{`merge({"a": 1, "b": 2}, {"c": 3, "a": 4})`, "{a: 4, b: 2, c: 3}"},
+		{`let h = {"a": 1}; merge(h, {"b": 2}); h`, "{a: 1}"},
+		{`map(entries({"a": 1, "b": 2}), fn(e) { e[0] + to_string(e[1]) })`, "[a1, b2]"},
+		{`keys([])`, "ERROR: argument to `keys` must be HASH, got=ARRAY"},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+// Programs that build or take apart a collection one element at a time. With
+// persistent arrays and hashes each step is cheap, so the time and memory a
+// run takes should grow about linearly with n, and ns/elem stay flat.
+var collectionBenchmarks = []struct {
+	name    string
+	program string
+}{
+	{"push", `len(reduce(range(0, %d), [], fn(xs, x) { push(xs, x) }))`},
+	{"rest", `let xs = range(0, %d); len(reduce(xs, xs, fn(acc, x) { rest(acc) }))`},
+	{"hash_insert", `len(keys(reduce(range(0, %d), {}, fn(h, x) { merge(h, {x: x}) })))`},
+}
+
+func BenchmarkCollections(b *testing.B) {
+	for _, bm := range collectionBenchmarks {
+		for _, n := range []int{1_000, 4_000, 16_000, 64_000} {
+			b.Run(fmt.Sprintf("%s/%d", bm.name, n), func(b *testing.B) {
+				comp := compiler.New()
+				if err := comp.Compile(parse(fmt.Sprintf(bm.program, n))); err != nil {
+					b.Fatal(err)
+				}
+				bytecode := comp.Bytecode()
+
+				for i := 0; i < b.N; i++ {
+					if err := New(bytecode).Run(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/elem")
+			})
+		}
+	}
+}
+
+// Copying the collection on every step would make a run on 8 times as many
+// elements allocate about 64 times as much.
+func TestCollectionsScale(t *testing.T) {
+	for _, bm := range collectionBenchmarks {
+		small := allocatedBytes(t, fmt.Sprintf(bm.program, 2_000))
+		large := allocatedBytes(t, fmt.Sprintf(bm.program, 16_000))
+
+		if large > 16*small {
+			t.Errorf("%s: allocations don't grow linearly. 2000 elements took %d bytes, 16000 took %d",
+				bm.name, small, large)
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{`let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(30)`, "265252859812191058636308480000000"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 1 - 1", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"99999999999999999999 / 3", "33333333333333333333"},
+		{"-99999999999999999999 / 7", "-14285714285714285714"},
+		{"99999999999999999999 * 0", "0"},
+		{"99999999999999999999 > 1", "true"},
+		{"1 < 99999999999999999999", "true"},
+		{"-99999999999999999999 < -1", "true"},
+		{"99999999999999999999 == 99999999999999999998 + 1", "true"},
+		{"99999999999999999999 != 99999999999999999999", "false"},
+		{`{9223372036854775807: "max"}[9223372036854775808 - 1]`, "max"},
+		{`{99999999999999999999: "big"}[99999999999999999998 + 1]`, "big"},
+		{`to_int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{`sort_by([99999999999999999999, 1, -99999999999999999999], fn(x) { x })`, "[-99999999999999999999, 1, 99999999999999999999]"},
+		{`range(0, 99999999999999999999)`, "ERROR: argument to `range` must be INTEGER, got=BIG_INTEGER"},
+		{"let sq = fn(x, n) { if (n == 0) { x } else { sq(x * x, n - 1) } }; len(to_string(sq(2, 15)))", "9865"},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+func TestIntegerErrors(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"1 / 0", "1:1: division by zero"},
+		{"99999999999999999999 / 0", "1:1: division by zero"},
+		{"1 > true", fmt.Sprintf("1:1: unknown operator: %d (INTEGER BOOLEAN)", code.OpGreaterThan)},
+		{"let sq = fn(x, n) { if (n == 0) { x } else { sq(x * x, n - 1) } }; sq(3, 26)", "1:49: integer too large: more than 65536 bits"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{`json_encode({"a": [1, true, first([])], "b": "x"})`, `{"a":[1,true,null],"b":"x"}`},
+		{`json_encode(9223372036854775807 + 1)`, "9223372036854775808"},
+		{`json_encode("<a & b>")`, `"<a & b>"`},
+		{`json_decode("[1, 2, null, true]")`, "[1, 2, null, true]"},
+		{`json_decode(json_encode({"b": 1, "a": [2, {}]}))`, "{b: 1, a: [2, {}]}"},
+		{`json_decode("123456789012345678901234567890") - 1`, "123456789012345678901234567889"},
+		{`json_decode("1.5")`, "ERROR: json_decode: number 1.5 is not an integer"},
+		{`json_decode("[1,")`, "ERROR: json_decode: unexpected end of JSON input"},
+		{`json_decode("1 2")`, "ERROR: json_decode: unexpected data after JSON value"},
+		{`json_encode([len])`, "ERROR: json_encode: no JSON form for BUILTIN"},
+		{`json_encode({1: 2})`, "ERROR: json_encode: unusable as JSON key: INTEGER"},
+		{`json_decode(1)`, "ERROR: argument to `json_decode` must be STRING, got=INTEGER"},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+func TestStructs(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"struct Point { x, y }; Point(1, 2)", "Point{x: 1, y: 2}"},
+		{"struct Point { x, y }; Point", "struct Point { x, y }"},
+		{"struct Point { x, y }; let p = Point(1, 2); p.x + p.y", "3"},
+		{"struct Point { x, y }; let p = Point(1, 2); p with { x: 5 }", "Point{x: 5, y: 2}"},
+		{"struct Point { x, y }; let p = Point(1, 2); let q = p with { y: 7 }; [p.y, q.y]", "[2, 7]"},
+		{"struct Point { x, y }; Point(1, 2) with { y: 3, x: 4, y: 5 }", "Point{x: 4, y: 5}"},
+		{"struct Line { from, to }; struct Point { x, y }; Line(Point(0, 0), Point(3, 4)).to.y", "4"},
+		{"struct Unit {}; Unit()", "Unit{}"},
+		{"struct Pair { a, b }; map([1, 2], fn(x) { Pair(x, x * 2) })", "[Pair{a: 1, b: 2}, Pair{a: 2, b: 4}]"},
+		{"let mk = fn(x) { struct Box { value }; Box(x) }; mk(5).value", "5"},
+		{"struct Point { x, y }; {Point(1, 2): \"a\"}[Point(1, 2)]", "a"},
+		{"struct Point { x, y }; json_encode(Point(1, [2]))", `{"x":1,"y":[2]}`},
+	}
+
+	runVmInspectTests(t, tests)
+}
+
+func TestStructErrors(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"struct Point { x, y }; Point(1)", "1:24: wrong number of arguments: want=2, got=1"},
+		{"struct Point { x, y }; Point(1, 2).z", "1:24: Point has no field `z`"},
+		{"struct Point { x, y }; Point(1, 2) with { z: 1 }", "1:24: Point has no field `z`"},
+		{`{"x": 1}.x`, "1:1: field access not supported: HASH"},
+		{"1 with { x: 1 }", "1:1: `with` not supported: INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let add = fn(a, b) { a + b }; let twice = fn(x) { add(x, "s") }; twice(1)`,
+			"unsupported types for binary operation: INTEGER STRING\n" +
+				"    at add (1:22, ip 4)\n" +
+				"    at twice (1:51, ip 8)\n" +
+				"    at <main> (1:66, ip 20)",
+		},
+	}
+
+	for _, tt := range tests {
+		vm := New(compile(t, tt.input))
+		err := vm.Run()
+
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("%s: expected *RuntimeError, got=%T (%v)", tt.input, err, err)
+		}
+		if trace := runtimeErr.Trace(); trace != tt.expected {
+			t.Errorf("%s: wrong trace:\nwant=%s\ngot=%s", tt.input, tt.expected, trace)
+		}
+	}
+}
+
+func TestRuntimeErrorTraceIsCapped(t *testing.T) {
+	vm := New(compile(t, "let f = fn(n) { f(n + 1) }; f(0)"))
+	err := vm.Run()
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError, got=%T (%v)", err, err)
+	}
+	if len(runtimeErr.Frames) != MaxTraceFrames {
+		t.Errorf("wrong number of frames. want=%d, got=%d", MaxTraceFrames, len(runtimeErr.Frames))
+	}
+	if total := len(runtimeErr.Frames) + runtimeErr.Omitted; total != MaxFrames {
+		t.Errorf("frames and omitted don't add up. want=%d, got=%d", MaxFrames, total)
+	}
+}
+
+const debuggerInput = `let add = fn(a, b) {
+  a + b
+};
+let x = add(1, 2);
+x * 2`
+
+func newTestDebugger(t *testing.T, input string) *Debugger {
+	t.Helper()
+
+	return NewDebugger(New(compile(t, input)), object.Budget{})
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	d := newTestDebugger(t, debuggerInput)
+
+	type step struct {
+		action   func(context.Context) error
+		expected Location
+	}
+	steps := []step{
+		{d.StepOver, Location{MainFunction, 4}},
+		{d.StepOver, Location{MainFunction, 7}},
+		{d.StepOver, Location{MainFunction, 10}},
+		{d.StepOver, Location{MainFunction, 13}},
+		{d.StepOver, Location{MainFunction, 16}},
+		{d.Step, Location{0, 0}},
+		{d.Step, Location{0, 2}},
+		{d.StepOut, Location{MainFunction, 18}},
+		{d.StepOver, Location{MainFunction, 21}},
+	}
+
+	for i, s := range steps {
+		if err := s.action(context.Background()); err != nil {
+			t.Fatalf("step %d: unexpected error: %s", i, err)
+		}
+		if loc := d.Location(); loc != s.expected {
+			t.Errorf("step %d: wrong location. want=%+v, got=%+v", i, s.expected, loc)
+		}
+	}
+
+	if err := d.Continue(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !d.Done() {
+		t.Fatalf("program not done after Continue")
+	}
+	if err := testIntegerObject(6, d.Result()); err != nil {
+		t.Errorf("wrong result: %s", err)
+	}
+}
+
+func TestDebuggerStepOverCall(t *testing.T) {
+	d := newTestDebugger(t, debuggerInput)
+
+	for range 5 {
+		d.StepOver(context.Background())
+	}
+	if err := d.StepOver(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if loc := d.Location(); loc != (Location{MainFunction, 18}) {
+		t.Errorf("StepOver entered the call, now at %+v", loc)
+	}
+	stack := d.Stack()
+	if len(stack) != 1 {
+		t.Fatalf("wrong stack size. want=1, got=%d", len(stack))
+	}
+	if err := testIntegerObject(3, stack[0]); err != nil {
+		t.Errorf("wrong return value: %s", err)
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	d := newTestDebugger(t, debuggerInput)
+
+	if err := d.SetBreakpoint(Location{0, 4}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	d.SetLineBreakpoint(5)
+
+	if err := d.Continue(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if loc := d.Location(); loc != (Location{0, 4}) {
+		t.Errorf("wrong location at offset breakpoint. want={0 4}, got=%+v", loc)
+	}
+
+	frame := d.Frame()
+	if frame.Function != "add" || frame.BasePointer != 1 {
+		t.Errorf("wrong frame. got=%+v", frame)
+	}
+	if frames := d.Frames(); len(frames) != 2 || frames[1].Function != "<main>" {
+		t.Errorf("wrong call stack. got=%+v", frames)
+	}
+
+	// The line breakpoint stops once on arriving at line 5, not on each of
+	// its instructions.
+	if err := d.Continue(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if loc := d.Location(); loc != (Location{MainFunction, 21}) {
+		t.Errorf("wrong location at line breakpoint. want={-1 21}, got=%+v", loc)
+	}
+
+	if err := d.Continue(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !d.Done() {
+		t.Errorf("program not done after the last breakpoint, at %+v", d.Location())
+	}
+}
+
+func TestDebuggerBreakpointErrors(t *testing.T) {
+	d := newTestDebugger(t, debuggerInput)
+
+	tests := []struct {
+		loc      Location
+		expected string
+	}{
+		{Location{1, 0}, "constant 1 is not a function"},
+		{Location{7, 0}, "constant 7 is not a function"},
+		{Location{0, 1}, "no instruction at offset 1"},
+		{Location{MainFunction, 100}, "no instruction at offset 100"},
+	}
+
+	for _, tt := range tests {
+		err := d.SetBreakpoint(tt.loc)
+		if err == nil {
+			t.Errorf("%+v: expected an error", tt.loc)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%+v: wrong error. want=%q, got=%q", tt.loc, tt.expected, err)
+		}
+	}
+}
+
+func TestDebuggerInspect(t *testing.T) {
+	d := newTestDebugger(t, "let n = 10; let f = fn(a) { fn(b) { a + b + n } }; f(1)(2)")
+
+	// Stop inside the inner closure, where `a` is free.
+	for d.Frame().Function != "<anonymous>" && !d.Done() {
+		d.Step(context.Background())
+	}
+	if d.Done() {
+		t.Fatalf("never entered the inner closure")
+	}
+
+	free := d.Frame().Free
+	if len(free) != 1 {
+		t.Fatalf("wrong number of free variables. want=1, got=%d", len(free))
+	}
+	if err := testIntegerObject(1, free[0]); err != nil {
+		t.Errorf("wrong free variable: %s", err)
+	}
+
+	globals := d.Globals()
+	if len(globals) != 2 {
+		t.Fatalf("wrong number of globals. want=2, got=%d", len(globals))
+	}
+	if err := testIntegerObject(10, globals[0]); err != nil {
+		t.Errorf("wrong global: %s", err)
+	}
+
+	if constants := d.Constants(); len(constants) == 0 {
+		t.Errorf("no constants")
+	}
+}
+
+func TestDebuggerError(t *testing.T) {
+	d := newTestDebugger(t, `let f = fn() { 1 + "a" }; f()`)
+
+	err := d.Continue(context.Background())
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError, got=%T (%v)", err, err)
+	}
+	if runtimeErr.Frames[0].Function != "f" {
+		t.Errorf("error not raised in f. got=%+v", runtimeErr.Frames)
+	}
+	if !d.Done() || d.Err() != err {
+		t.Errorf("debugger doesn't keep the error")
+	}
+	if again := d.Step(context.Background()); again != err {
+		t.Errorf("stepping a failed program: want=%v, got=%v", err, again)
+	}
+	if d.Result() != nil {
+		t.Errorf("failed program has a result: %s", d.Result().Inspect())
+	}
+}
+
+func TestDebuggerCancel(t *testing.T) {
+	d := newTestDebugger(t, debuggerInput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := d.Continue(ctx)
+	if err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled run, got=%v", err)
+	}
+	if !d.Done() {
+		t.Errorf("cancelled program not done")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	vm := New(compile(t, "let x = 1 + 2; let f = fn(a) { a * x }; f(5)"))
+	trace := NewTrace(DefaultTraceLimit)
+	vm.SetTrace(trace)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []struct {
+		function string
+		op       string
+		depth    int
+		stack    []string
+	}{
+		{"<main>", "OpConstant", 1, []string{"1"}},
+		{"<main>", "OpConstant", 1, []string{"1", "2"}},
+		{"<main>", "OpAdd", 1, []string{"3"}},
+		{"<main>", "OpSetGlobal", 1, []string{}},
+		{"<main>", "OpClosure", 1, []string{"f"}},
+		{"<main>", "OpSetGlobal", 1, []string{}},
+		{"<main>", "OpGetGlobal", 1, []string{"f"}},
+		{"<main>", "OpConstant", 1, []string{"f", "5"}},
+		{"<main>", "OpCall", 1, []string{"f", "5"}},
+		{"f", "OpGetLocal", 2, []string{"f", "5", "5"}},
+		{"f", "OpGetGlobal", 2, []string{"f", "5", "5", "3"}},
+		{"f", "OpMul", 2, []string{"f", "5", "15"}},
+		{"f", "OpReturnValue", 2, []string{"15"}},
+		{"<main>", "OpPop", 1, []string{}},
+	}
+
+	if len(trace.Steps) != len(expected) {
+		t.Fatalf("wrong number of steps. want=%d, got=%d", len(expected), len(trace.Steps))
+	}
+
+	// Replay the deltas, naming the closure so the stack is predictable.
+	stack := []string{}
+	for i, step := range trace.Steps {
+		want := expected[i]
+
+		if step.Function != want.function || step.Op != want.op || step.Depth != want.depth {
+			t.Errorf("step %d: want=%s %s at depth %d, got=%s %s at depth %d", i,
+				want.function, want.op, want.depth, step.Function, step.Op, step.Depth)
+		}
+
+		stack = stack[:len(stack)-step.Pop]
+		for _, pushed := range step.Push {
+			if strings.HasPrefix(pushed, "Closure[") {
+				pushed = "f"
+			}
+			stack = append(stack, pushed)
+		}
+
+		if strings.Join(stack, " ") != strings.Join(want.stack, " ") {
+			t.Errorf("step %d: wrong stack. want=%v, got=%v", i, want.stack, stack)
+		}
+	}
+
+	if globals := trace.Steps[3].Globals; len(globals) != 1 || globals[0] != "3" {
+		t.Errorf("wrong globals set by step 3. got=%v", globals)
+	}
+}
+
+func TestTraceLimit(t *testing.T) {
+	vm := New(compile(t, "let f = fn(n) { if (n > 0) { f(n - 1) } }; f(100)"))
+	trace := NewTrace(50)
+	vm.SetTrace(trace)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if len(trace.Steps) != 50 {
+		t.Errorf("wrong number of steps. want=50, got=%d", len(trace.Steps))
+	}
+	if !trace.Truncated {
+		t.Errorf("trace not marked truncated")
+	}
+}
+
+func TestTraceBytesLimit(t *testing.T) {
+	vm := New(compile(t, "let a = range(0, 100); map(range(0, 6000), fn(x) { a }); 1"))
+	trace := NewTrace(1_000_000)
+	vm.SetTrace(trace)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if !trace.Truncated {
+		t.Errorf("trace not marked truncated")
+	}
+
+	total := 0
+	for _, step := range trace.Steps {
+		for _, value := range step.Push {
+			if len(value) > MaxTraceValue {
+				t.Fatalf("value of %d bytes kept: %.20s...", len(value), value)
+			}
+			total += len(value)
+		}
+	}
+	if total > MaxTraceBytes+MaxTraceValue*StackSize {
+		t.Errorf("trace kept %d bytes of values", total)
+	}
+}
+
+func TestTraceError(t *testing.T) {
+	vm := New(compile(t, `1 + "a"`))
+	trace := NewTrace(DefaultTraceLimit)
+	vm.SetTrace(trace)
+	if err := vm.Run(); err == nil {
+		t.Fatalf("expected VM error but resulted in none")
+	}
+
+	last := trace.Steps[len(trace.Steps)-1]
+	if last.Op != "OpAdd" || last.Error != "unsupported types for binary operation: INTEGER STRING" {
+		t.Errorf("wrong last step. got=%+v", last)
+	}
+}
+
 // Helpers
 
 func runVmTests(t *testing.T, tests []vmTestCase) {
@@ -578,6 +1567,10 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("compiler error: %s", err)
 		}
 
+		if err := Verify(comp.Bytecode(), object.DefaultStdlib()); err != nil {
+			t.Fatalf("%s: verify error: %s", tt.input, err)
+		}
+
 		vm := New(comp.Bytecode())
 		if err := vm.Run(); err != nil {
 			t.Fatalf("vm error: %s", err)
@@ -586,7 +1579,58 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 		stackElem := vm.LastPoppedStackElem()
 
 		testExpectedObject(t, tt.expected, stackElem)
+
+		testOptimizedProgram(t, tt)
+		testMarshaledProgram(t, tt, comp.Bytecode())
+	}
+}
+
+// testMarshaledProgram checks the program does the same once saved and
+// loaded again.
+func testMarshaledProgram(t *testing.T, tt vmTestCase, bytecode *compiler.Bytecode) {
+	t.Helper()
+
+	data, err := compiler.Marshal(bytecode, object.DefaultStdlib(), true)
+	if err != nil {
+		t.Fatalf("%s: marshal error: %s", tt.input, err)
+	}
+	loaded, err := compiler.Unmarshal(data, object.DefaultStdlib())
+	if err != nil {
+		t.Fatalf("%s: unmarshal error: %s", tt.input, err)
+	}
+	if err := Verify(loaded, object.DefaultStdlib()); err != nil {
+		t.Fatalf("%s: verify error once loaded: %s", tt.input, err)
 	}
+
+	vm := New(loaded)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("%s: vm error once loaded: %s", tt.input, err)
+	}
+
+	testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+}
+
+// testOptimizedProgram checks the program does the same with every
+// optimization pass switched on.
+func testOptimizedProgram(t *testing.T, tt vmTestCase) {
+	t.Helper()
+
+	stages, err := compiler.Optimize(parse(tt.input), object.DefaultStdlib(), compiler.AllPasses())
+	if err != nil {
+		t.Fatalf("optimizer error: %s", err)
+	}
+
+	bytecode := stages[len(stages)-1].Bytecode
+	if err := Verify(bytecode, object.DefaultStdlib()); err != nil {
+		t.Fatalf("%s: verify error with optimizations: %s", tt.input, err)
+	}
+
+	vm := New(bytecode)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("%s: vm error with optimizations: %s", tt.input, err)
+	}
+
+	testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
 }
 
 func testExpectedObject(t *testing.T, expected any, actual object.Object) {
@@ -615,40 +1659,40 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 			return
 		}
 
-		if len(array.Elements) != len(expected) {
+		if array.Len() != len(expected) {
 			t.Errorf("wrong number of elements. want=%d, got=%d",
-				len(expected), len(array.Elements))
+				len(expected), array.Len())
 		}
 
 		for i, expectedElement := range expected {
-			err := testIntegerObject(int64(expectedElement), array.Elements[i])
+			err := testIntegerObject(int64(expectedElement), array.At(i))
 			if err != nil {
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 
 		}
 
-	case map[object.HashKey]int64:
+	case map[object.Object]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 
 		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+			value, ok := hash.Get(expectedKey)
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
 				return
 			}
 
-			if err := testIntegerObject(expectedValue, pair.Value); err != nil {
+			if err := testIntegerObject(expectedValue, value); err != nil {
 				t.Errorf("testIntegerObject failed: %s", err)
 				return
 			}
@@ -724,3 +1768,63 @@ func parse(input string) *ast.Program {
 
 	return p.ParseProgram()
 }
+
+// allocatedBytes runs a program and returns how many bytes it allocated.
+func allocatedBytes(t *testing.T, input string) uint64 {
+	t.Helper()
+
+	vm := New(compile(t, input))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("%s: vm error: %s", input, err)
+	}
+	runtime.ReadMemStats(&after)
+
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return comp.Bytecode()
+}
+
+// runVmInspectTests runs each program and compares the value it leaves, as
+// Inspect prints it.
+func runVmInspectTests(t *testing.T, tests []vmInspectTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		vm := New(compile(t, tt.input))
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", tt.input, err)
+		}
+
+		result := vm.LastPoppedStackElem().Inspect()
+		if result != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// runVmErrorTests runs each program and compares the error it stops with.
+func runVmErrorTests(t *testing.T, tests []vmInspectTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		err := New(compile(t, tt.input)).Run()
+		if err == nil {
+			t.Fatalf("%s: expected VM error but resulted in none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong VM error: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
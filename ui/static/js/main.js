@@ -13,6 +13,7 @@ document
 
 		const inputText = document.getElementById("inputText").value.trim();
 		const processType = document.getElementById("processType").value;
+		const locale = document.getElementById("locale").value;
 
 		if (inputText === "") {
 			document.getElementById("outputText").value =
@@ -27,7 +28,7 @@ document
 			headers: {
 				"Content-Type": "application/json",
 			},
-			body: JSON.stringify({ input: inputText }),
+			body: JSON.stringify({ input: inputText, locale }),
 		}).catch((err) => err);
 
 		if (response instanceof Error) {
@@ -49,9 +50,8 @@ document
 			return;
 		}
 
-		document.getElementById("outputText").value = JSON.stringify(
-			data.result,
-			null,
-			2,
-		);
+		const result = JSON.stringify(data.result, null, 2);
+		const output = data.output?.length ? data.output.join("\n") + "\n\n" : "";
+
+		document.getElementById("outputText").value = output + result;
 	});